@@ -0,0 +1,377 @@
+package apksig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Scheme identifies the APK signature scheme the signer certificates were
+// taken from.
+type Scheme int
+
+const (
+	SchemeV1 Scheme = 1
+	SchemeV2 Scheme = 2
+	SchemeV3 Scheme = 3
+)
+
+const (
+	eocdMinSize          = 22
+	eocdSignature        = 0x06054b50
+	maxEOCDCommentSize   = 0xffff
+	signingBlockMagic    = "APK Sig Block 42"
+	signingBlockFooterSz = 24
+	v2BlockID            = 0x7109871a
+	v3BlockID            = 0xf05368c0
+	v31BlockID           = 0x1b93ad61
+)
+
+var ErrNotSigned = errors.New("apk is not signed")
+
+// Signers holds the SHA-256 digests (lowercase hex) of the signer
+// certificates found in an APK. Only the highest signature scheme present is
+// reported: with key rotation the v3 signer is the current key, while the
+// v1/v2 signers may still carry the old one.
+//
+// The signatures themselves are not verified; use apksigner for that.
+type Signers struct {
+	Scheme Scheme
+	SHA256 []string
+}
+
+// Read extracts the signer certificate digests from the APK at filePath.
+func Read(filePath string) (Signers, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return Signers{}, fmt.Errorf("failed to open apk: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return Signers{}, fmt.Errorf("failed to stat apk: %w", err)
+	}
+	return ReadFrom(file, info.Size())
+}
+
+// ReadFrom extracts the signer certificate digests from an APK of the given
+// size.
+func ReadFrom(r io.ReaderAt, size int64) (Signers, error) {
+	block, err := findSigningBlock(r, size)
+	if err != nil {
+		return Signers{}, err
+	}
+	if block != nil {
+		for _, candidate := range []struct {
+			id     uint32
+			scheme Scheme
+		}{{v31BlockID, SchemeV3}, {v3BlockID, SchemeV3}, {v2BlockID, SchemeV2}} {
+			value, found := findBlockValue(block, candidate.id)
+			if !found {
+				continue
+			}
+			digests, err := parseSchemeSigners(value)
+			if err != nil {
+				return Signers{}, fmt.Errorf("invalid v%d signature: %w", candidate.scheme, err)
+			}
+			return Signers{Scheme: candidate.scheme, SHA256: digests}, nil
+		}
+	}
+	digests, err := readJarSigners(r, size)
+	if err != nil {
+		return Signers{}, err
+	}
+	return Signers{Scheme: SchemeV1, SHA256: digests}, nil
+}
+
+// NormalizeDigest lowercases a certificate SHA-256 digest and strips the
+// colons used by keytool and apksigner output. It returns an error if the
+// result is not 64 hex characters.
+func NormalizeDigest(digest string) (string, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(digest), ":", ""))
+	decoded, err := hex.DecodeString(normalized)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid certificate sha256 %q", digest)
+	}
+	return normalized, nil
+}
+
+// findSigningBlock returns the contents of the APK Signing Block without its
+// size fields and magic, or nil if the APK has none.
+func findSigningBlock(r io.ReaderAt, size int64) ([]byte, error) {
+	centralDirOffset, err := findCentralDirectoryOffset(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if centralDirOffset < signingBlockFooterSz {
+		return nil, nil
+	}
+	footer := make([]byte, signingBlockFooterSz)
+	if _, err := r.ReadAt(footer, centralDirOffset-signingBlockFooterSz); err != nil {
+		return nil, fmt.Errorf("failed to read signing block footer: %w", err)
+	}
+	if string(footer[8:]) != signingBlockMagic {
+		return nil, nil
+	}
+	blockSize := binary.LittleEndian.Uint64(footer[:8])
+	// The size excludes the leading 8-byte size field and covers the pairs,
+	// the trailing size field and the magic.
+	if blockSize < signingBlockFooterSz || blockSize > uint64(centralDirOffset-8) {
+		return nil, fmt.Errorf("invalid signing block size %d", blockSize)
+	}
+	start := centralDirOffset - int64(blockSize) - 8
+	block := make([]byte, blockSize+8)
+	if _, err := r.ReadAt(block, start); err != nil {
+		return nil, fmt.Errorf("failed to read signing block: %w", err)
+	}
+	if binary.LittleEndian.Uint64(block[:8]) != blockSize {
+		return nil, errors.New("signing block size fields do not match")
+	}
+	return block[8 : len(block)-signingBlockFooterSz], nil
+}
+
+func findCentralDirectoryOffset(r io.ReaderAt, size int64) (int64, error) {
+	if size < eocdMinSize {
+		return 0, errors.New("file is too small to be an apk")
+	}
+	tailSize := int64(eocdMinSize + maxEOCDCommentSize)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil {
+		return 0, fmt.Errorf("failed to read end of central directory: %w", err)
+	}
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != eocdSignature {
+			continue
+		}
+		commentSize := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+eocdMinSize+commentSize != len(tail) {
+			continue
+		}
+		return int64(binary.LittleEndian.Uint32(tail[i+16:])), nil
+	}
+	return 0, errors.New("end of central directory not found")
+}
+
+func findBlockValue(block []byte, id uint32) ([]byte, bool) {
+	for len(block) >= 12 {
+		pairSize := binary.LittleEndian.Uint64(block[:8])
+		if pairSize < 4 || pairSize > uint64(len(block)-8) {
+			return nil, false
+		}
+		pair := block[8 : 8+pairSize]
+		if binary.LittleEndian.Uint32(pair[:4]) == id {
+			return pair[4:], true
+		}
+		block = block[8+pairSize:]
+	}
+	return nil, false
+}
+
+// lengthPrefixed splits off a uint32 little-endian length-prefixed slice.
+func lengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("truncated length prefix")
+	}
+	size := binary.LittleEndian.Uint32(data[:4])
+	if uint64(size) > uint64(len(data)-4) {
+		return nil, nil, errors.New("length prefix exceeds data")
+	}
+	return data[4 : 4+size], data[4+size:], nil
+}
+
+// parseSchemeSigners reads the first certificate of every signer in a v2 or
+// v3 signature block value. Both schemes place the certificates right after
+// the digests in the signed data.
+func parseSchemeSigners(value []byte) ([]string, error) {
+	signers, _, err := lengthPrefixed(value)
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for len(signers) > 0 {
+		var signer []byte
+		signer, signers, err = lengthPrefixed(signers)
+		if err != nil {
+			return nil, err
+		}
+		signedData, _, err := lengthPrefixed(signer)
+		if err != nil {
+			return nil, err
+		}
+		_, rest, err := lengthPrefixed(signedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read digests: %w", err)
+		}
+		certificates, _, err := lengthPrefixed(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificates: %w", err)
+		}
+		certificate, _, err := lengthPrefixed(certificates)
+		if err != nil {
+			return nil, fmt.Errorf("signer has no certificate: %w", err)
+		}
+		digests = appendDigest(digests, certificate)
+	}
+	if len(digests) == 0 {
+		return nil, errors.New("no signers")
+	}
+	return digests, nil
+}
+
+func readJarSigners(r io.ReaderAt, size int64) ([]string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open apk as zip: %w", err)
+	}
+	var digests []string
+	for _, file := range archive.File {
+		dir, name := path.Split(file.Name)
+		if !strings.EqualFold(dir, "META-INF/") {
+			continue
+		}
+		switch strings.ToUpper(path.Ext(name)) {
+		case ".RSA", ".DSA", ".EC":
+		default:
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		certificates, err := parsePKCS7SignerCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid v1 signature %s: %w", file.Name, err)
+		}
+		for _, certificate := range certificates {
+			digests = appendDigest(digests, certificate)
+		}
+	}
+	if len(digests) == 0 {
+		return nil, ErrNotSigned
+	}
+	return digests, nil
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version              int
+	IssuerAndSerial      pkcs7IssuerAndSerial
+	DigestAlgorithm      asn1.RawValue
+	AuthenticatedAttrs   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgo asn1.RawValue
+	EncryptedDigest      []byte
+	UnauthenticatedAttrs asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// parsePKCS7SignerCertificates returns the DER certificates referenced by the
+// signer infos of a PKCS#7 SignedData structure, ignoring any additional
+// chain certificates.
+func parsePKCS7SignerCertificates(data []byte) ([][]byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse content info: %w", err)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected content type %s", contentInfo.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("failed to parse signed data: %w", err)
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	var result [][]byte
+	for _, signerInfo := range signedData.SignerInfos {
+		for _, certificate := range certificates {
+			if bytes.Equal(certificate.RawIssuer, signerInfo.IssuerAndSerial.Issuer.FullBytes) &&
+				certificate.SerialNumber.Cmp(signerInfo.IssuerAndSerial.SerialNumber) == 0 {
+				result = append(result, certificate.Raw)
+				break
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no signer certificate found")
+	}
+	return result, nil
+}
+
+func appendDigest(digests []string, certificate []byte) []string {
+	sum := sha256.Sum256(certificate)
+	digest := hex.EncodeToString(sum[:])
+	index, found := slices.BinarySearch(digests, digest)
+	if found {
+		return digests
+	}
+	return slices.Insert(digests, index, digest)
+}
+
+// ReadBundle extracts the signer certificate digests of the base APK inside
+// an XAPK or APKS container. The base APK is the entry named base.apk or
+// <packageName>.apk; split APKs carry the same certificate and are ignored.
+func ReadBundle(filePath, packageName string) (Signers, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return Signers{}, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		name := path.Base(file.Name)
+		if !strings.EqualFold(name, "base.apk") && !strings.EqualFold(name, packageName+".apk") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return Signers{}, fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return Signers{}, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		return ReadFrom(bytes.NewReader(data), int64(len(data)))
+	}
+	return Signers{}, errors.New("base apk not found in bundle")
+}
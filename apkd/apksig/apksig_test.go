@@ -0,0 +1,280 @@
+package apksig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, commonName string, serial int64) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return der
+}
+
+func certificateDigest(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := entry.Write(content); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newTestPKCS7 builds a minimal SignedData with one signer info referencing
+// signerCert, plus extraCerts as chain certificates.
+func newTestPKCS7(t *testing.T, signerCert []byte, extraCerts ...[]byte) []byte {
+	t.Helper()
+	certificate, err := x509.ParseCertificate(signerCert)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	var certificates []byte
+	for _, der := range append(extraCerts, signerCert) {
+		certificates = append(certificates, der...)
+	}
+	algorithm := asn1.RawValue{FullBytes: []byte{0x30, 0x00}}
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{FullBytes: []byte{0x31, 0x00}},
+		ContentInfo:      asn1.RawValue{FullBytes: mustMarshal(t, struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerial: pkcs7IssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: certificate.RawIssuer},
+				SerialNumber: certificate.SerialNumber,
+			},
+			DigestAlgorithm:      algorithm,
+			DigestEncryptionAlgo: algorithm,
+			EncryptedDigest:      []byte{1, 2, 3},
+		}},
+	}
+	content := mustMarshal(t, signedData)
+	return mustMarshal(t, pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+func mustMarshal(t *testing.T, value any) []byte {
+	t.Helper()
+	data, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal asn1: %v", err)
+	}
+	return data
+}
+
+func appendLengthPrefixed(dst []byte, data []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(data)))
+	return append(dst, data...)
+}
+
+// newSchemeValue builds a v2/v3 signature block value with one signer per
+// certificate.
+func newSchemeValue(certificates ...[]byte) []byte {
+	var signers []byte
+	for _, certificate := range certificates {
+		var signedData []byte
+		signedData = appendLengthPrefixed(signedData, nil)
+		signedData = appendLengthPrefixed(signedData, appendLengthPrefixed(nil, certificate))
+		signedData = appendLengthPrefixed(signedData, nil)
+		var signer []byte
+		signer = appendLengthPrefixed(signer, signedData)
+		signer = appendLengthPrefixed(signer, nil)
+		signer = appendLengthPrefixed(signer, nil)
+		signers = appendLengthPrefixed(signers, signer)
+	}
+	return appendLengthPrefixed(nil, signers)
+}
+
+// insertSigningBlock places an APK Signing Block with the given id/value
+// pairs before the central directory and patches the EOCD offset.
+func insertSigningBlock(t *testing.T, apk []byte, pairs map[uint32][]byte) []byte {
+	t.Helper()
+	cdOffset, err := findCentralDirectoryOffset(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("failed to find central directory: %v", err)
+	}
+	var body []byte
+	for id, value := range pairs {
+		body = binary.LittleEndian.AppendUint64(body, uint64(len(value)+4))
+		body = binary.LittleEndian.AppendUint32(body, id)
+		body = append(body, value...)
+	}
+	blockSize := uint64(len(body) + signingBlockFooterSz)
+	var block []byte
+	block = binary.LittleEndian.AppendUint64(block, blockSize)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint64(block, blockSize)
+	block = append(block, signingBlockMagic...)
+
+	result := append([]byte(nil), apk[:cdOffset]...)
+	result = append(result, block...)
+	result = append(result, apk[cdOffset:]...)
+	eocd := len(result) - eocdMinSize
+	binary.LittleEndian.PutUint32(result[eocd+16:], uint32(cdOffset)+uint32(len(block)))
+	return result
+}
+
+func TestReadV1Signers(t *testing.T) {
+	signer := newTestCertificate(t, "signer", 1)
+	chain := newTestCertificate(t, "intermediate", 2)
+	apk := newTestZip(t, map[string][]byte{
+		"AndroidManifest.xml":  []byte("manifest"),
+		"META-INF/MANIFEST.MF": []byte("Manifest-Version: 1.0\n"),
+		"META-INF/CERT.RSA":    newTestPKCS7(t, signer, chain),
+	})
+
+	signers, err := ReadFrom(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if signers.Scheme != SchemeV1 {
+		t.Fatalf("expected v1 scheme, got %d", signers.Scheme)
+	}
+	if len(signers.SHA256) != 1 || signers.SHA256[0] != certificateDigest(signer) {
+		t.Fatalf("expected only the signer certificate digest, got %v", signers.SHA256)
+	}
+}
+
+func TestReadPrefersHighestScheme(t *testing.T) {
+	oldKey := newTestCertificate(t, "old", 1)
+	newKey := newTestCertificate(t, "new", 2)
+	apk := newTestZip(t, map[string][]byte{
+		"AndroidManifest.xml": []byte("manifest"),
+		"META-INF/CERT.RSA":   newTestPKCS7(t, oldKey),
+	})
+	apk = insertSigningBlock(t, apk, map[uint32][]byte{
+		v2BlockID: newSchemeValue(oldKey),
+		v3BlockID: newSchemeValue(newKey),
+	})
+
+	signers, err := ReadFrom(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if signers.Scheme != SchemeV3 {
+		t.Fatalf("expected v3 scheme, got %d", signers.Scheme)
+	}
+	if len(signers.SHA256) != 1 || signers.SHA256[0] != certificateDigest(newKey) {
+		t.Fatalf("expected rotated certificate digest, got %v", signers.SHA256)
+	}
+}
+
+func TestReadV2MultipleSigners(t *testing.T) {
+	first := newTestCertificate(t, "first", 1)
+	second := newTestCertificate(t, "second", 2)
+	apk := insertSigningBlock(t, newTestZip(t, map[string][]byte{"classes.dex": []byte("dex")}), map[uint32][]byte{
+		v2BlockID: newSchemeValue(first, second, first),
+	})
+
+	signers, err := ReadFrom(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if signers.Scheme != SchemeV2 || len(signers.SHA256) != 2 {
+		t.Fatalf("expected two distinct v2 signers, got %+v", signers)
+	}
+}
+
+func TestReadUnsignedApk(t *testing.T) {
+	apk := newTestZip(t, map[string][]byte{"AndroidManifest.xml": []byte("manifest")})
+	_, err := ReadFrom(bytes.NewReader(apk), int64(len(apk)))
+	if !errors.Is(err, ErrNotSigned) {
+		t.Fatalf("expected ErrNotSigned, got %v", err)
+	}
+}
+
+func TestReadTruncatedSchemeValue(t *testing.T) {
+	apk := insertSigningBlock(t, newTestZip(t, map[string][]byte{"classes.dex": []byte("dex")}), map[uint32][]byte{
+		v2BlockID: {0xff, 0xff, 0x00, 0x00},
+	})
+	if _, err := ReadFrom(bytes.NewReader(apk), int64(len(apk))); err == nil {
+		t.Fatal("expected error for truncated signature block")
+	}
+}
+
+func TestNormalizeDigest(t *testing.T) {
+	digest, err := NormalizeDigest("43:23:8D:51:2C:1E:5E:B2:D6:56:9F:4A:3A:FB:F5:52:34:18:B8:2E:0A:3E:D1:55:27:70:AB:B9:A9:C9:CC:AB")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest != "43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab" {
+		t.Fatalf("unexpected normalized digest %s", digest)
+	}
+	if _, err := NormalizeDigest("abcd"); err == nil {
+		t.Fatal("expected error for short digest")
+	}
+}
+
+func TestReadBundleUsesBaseApk(t *testing.T) {
+	signer := newTestCertificate(t, "signer", 1)
+	base := insertSigningBlock(t, newTestZip(t, map[string][]byte{"classes.dex": []byte("dex")}), map[uint32][]byte{
+		v2BlockID: newSchemeValue(signer),
+	})
+	bundle := newTestZip(t, map[string][]byte{
+		"manifest.json":         []byte("{}"),
+		"config.arm64_v8a.apk":  []byte("not an apk"),
+		"com.example.app.apk":   base,
+		"Android/obb/readme.md": []byte("obb"),
+	})
+	bundlePath := filepath.Join(t.TempDir(), "app.xapk")
+	if err := os.WriteFile(bundlePath, bundle, 0o644); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+
+	signers, err := ReadBundle(bundlePath, "com.example.app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(signers.SHA256) != 1 || signers.SHA256[0] != certificateDigest(signer) {
+		t.Fatalf("unexpected signers %v", signers.SHA256)
+	}
+	if _, err := ReadBundle(bundlePath, "com.other.app"); err == nil {
+		t.Fatal("expected error when base apk is missing")
+	}
+}
@@ -0,0 +1,358 @@
+package apksig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	// Register the digests a JAR signature may use.
+	_ "crypto/sha1"
+	_ "crypto/sha512"
+)
+
+var (
+	oidDigestSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidAttributeDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// jarDigests maps the digest names used in manifest attributes, strongest
+// first.
+var jarDigests = []struct {
+	name string
+	hash crypto.Hash
+}{
+	{"SHA-512", crypto.SHA512},
+	{"SHA-384", crypto.SHA384},
+	{"SHA-256", crypto.SHA256},
+	{"SHA1", crypto.SHA1},
+	{"SHA-1", crypto.SHA1},
+}
+
+// VerifyJarEntry verifies the v1 (JAR) signature of the archive and returns
+// the contents of the named entry and the SHA-256 digest (lowercase hex) of
+// the signer certificate. The signature file must be signed by the
+// certificate, the manifest must match the signature file and the entry must
+// match its manifest digest.
+//
+// Certificate validity and chains are not checked: JAR signers are trusted by
+// pinning the certificate digest.
+func VerifyJarEntry(r io.ReaderAt, size int64, name string) ([]byte, string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open jar: %w", err)
+	}
+	var manifest, entry []byte
+	signatureFiles := make(map[string][]byte)
+	signatureBlocks := make(map[string][]byte)
+	for _, file := range archive.File {
+		dir, base := path.Split(file.Name)
+		isMetaInf := strings.EqualFold(dir, "META-INF/")
+		var target *[]byte
+		switch {
+		case file.Name == name:
+			target = &entry
+		case isMetaInf && strings.EqualFold(base, "MANIFEST.MF"):
+			target = &manifest
+		case isMetaInf && strings.EqualFold(path.Ext(base), ".SF"):
+			data, err := readZipFile(file)
+			if err != nil {
+				return nil, "", err
+			}
+			signatureFiles[strings.ToUpper(strings.TrimSuffix(base, path.Ext(base)))] = data
+			continue
+		case isMetaInf && isSignatureBlock(base):
+			data, err := readZipFile(file)
+			if err != nil {
+				return nil, "", err
+			}
+			signatureBlocks[strings.ToUpper(strings.TrimSuffix(base, path.Ext(base)))] = data
+			continue
+		default:
+			continue
+		}
+		if *target, err = readZipFile(file); err != nil {
+			return nil, "", err
+		}
+	}
+	if entry == nil {
+		return nil, "", fmt.Errorf("jar has no %s", name)
+	}
+	if manifest == nil || len(signatureBlocks) == 0 {
+		return nil, "", ErrNotSigned
+	}
+
+	var signer string
+	for signerName, block := range signatureBlocks {
+		signatureFile, found := signatureFiles[signerName]
+		if !found {
+			return nil, "", fmt.Errorf("signature block %s has no signature file", signerName)
+		}
+		certificate, err := verifyPKCS7Detached(block, signatureFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid signature %s: %w", signerName, err)
+		}
+		if err := verifySignatureFile(signatureFile, manifest); err != nil {
+			return nil, "", fmt.Errorf("invalid signature file %s: %w", signerName, err)
+		}
+		sum := sha256.Sum256(certificate)
+		digest := hex.EncodeToString(sum[:])
+		if signer != "" && signer != digest {
+			return nil, "", errors.New("jar has more than one signer")
+		}
+		signer = digest
+	}
+
+	_, sections, err := parseManifest(manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid manifest: %w", err)
+	}
+	section, found := sections[name]
+	if !found {
+		return nil, "", fmt.Errorf("%s is not signed", name)
+	}
+	if err := checkDigestAttribute(section, "-Digest", entry); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	return entry, signer, nil
+}
+
+func isSignatureBlock(name string) bool {
+	switch strings.ToUpper(path.Ext(name)) {
+	case ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return data, nil
+}
+
+// verifySignatureFile checks the whole-manifest digest of a signature file.
+func verifySignatureFile(signatureFile, manifest []byte) error {
+	mainSection, _, err := parseManifest(signatureFile)
+	if err != nil {
+		return err
+	}
+	return checkDigestAttribute(mainSection, "-Digest-Manifest", manifest)
+}
+
+// checkDigestAttribute compares data with the strongest <digest><suffix>
+// attribute of the section.
+func checkDigestAttribute(section map[string]string, suffix string, data []byte) error {
+	for _, digest := range jarDigests {
+		encoded, found := section[strings.ToLower(digest.name+suffix)]
+		if !found {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid %s%s attribute: %w", digest.name, suffix, err)
+		}
+		hash := digest.hash.New()
+		hash.Write(data)
+		if !bytes.Equal(hash.Sum(nil), expected) {
+			return fmt.Errorf("%s digest mismatch", digest.name)
+		}
+		return nil
+	}
+	return fmt.Errorf("no supported *%s attribute", suffix)
+}
+
+// parseManifest splits a JAR manifest or signature file into its main
+// section and the sections keyed by their Name attribute. Attribute names
+// are lowercased.
+func parseManifest(data []byte) (map[string]string, map[string]map[string]string, error) {
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+	var parsed []map[string]string
+	current := make(map[string]string)
+	lastKey := ""
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case line == "":
+			if len(current) > 0 {
+				parsed = append(parsed, current)
+				current = make(map[string]string)
+			}
+			lastKey = ""
+		case line[0] == ' ':
+			if lastKey == "" {
+				return nil, nil, errors.New("continuation line without attribute")
+			}
+			current[lastKey] += line[1:]
+		default:
+			key, value, found := strings.Cut(line, ": ")
+			if !found {
+				return nil, nil, fmt.Errorf("invalid attribute line %q", line)
+			}
+			lastKey = strings.ToLower(key)
+			current[lastKey] = value
+		}
+	}
+	if len(current) > 0 {
+		parsed = append(parsed, current)
+	}
+
+	mainSection := make(map[string]string)
+	if len(parsed) > 0 {
+		if _, named := parsed[0]["name"]; !named {
+			mainSection = parsed[0]
+			parsed = parsed[1:]
+		}
+	}
+	sections := make(map[string]map[string]string, len(parsed))
+	for _, section := range parsed {
+		if name, found := section["name"]; found {
+			sections[name] = section
+		}
+	}
+	return mainSection, sections, nil
+}
+
+// verifyPKCS7Detached verifies a PKCS#7 SignedData signature over content and
+// returns the DER certificate of the signer.
+func verifyPKCS7Detached(data, content []byte) ([]byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse content info: %w", err)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected content type %s", contentInfo.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("failed to parse signed data: %w", err)
+	}
+	if len(signedData.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer, got %d", len(signedData.SignerInfos))
+	}
+	signerInfo := signedData.SignerInfos[0]
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	var certificate *x509.Certificate
+	for _, candidate := range certificates {
+		if bytes.Equal(candidate.RawIssuer, signerInfo.IssuerAndSerial.Issuer.FullBytes) &&
+			candidate.SerialNumber.Cmp(signerInfo.IssuerAndSerial.SerialNumber) == 0 {
+			certificate = candidate
+			break
+		}
+	}
+	if certificate == nil {
+		return nil, errors.New("no signer certificate found")
+	}
+
+	var digestAlgorithm pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(signerInfo.DigestAlgorithm.FullBytes, &digestAlgorithm); err != nil {
+		return nil, fmt.Errorf("failed to parse digest algorithm: %w", err)
+	}
+	hash, err := pkcs7Hash(digestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := signatureAlgorithm(certificate, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := content
+	if len(signerInfo.AuthenticatedAttrs.FullBytes) > 0 {
+		if err := checkMessageDigest(signerInfo.AuthenticatedAttrs.Bytes, hash, content); err != nil {
+			return nil, err
+		}
+		// The attributes are signed as a SET, not with their implicit tag.
+		signed = append([]byte{0x31}, signerInfo.AuthenticatedAttrs.FullBytes[1:]...)
+	}
+	if err := certificate.CheckSignature(algorithm, signed, signerInfo.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("signature mismatch: %w", err)
+	}
+	return certificate.Raw, nil
+}
+
+func pkcs7Hash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+func signatureAlgorithm(certificate *x509.Certificate, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	algorithms := map[crypto.Hash][2]x509.SignatureAlgorithm{
+		crypto.SHA1:   {x509.SHA1WithRSA, x509.ECDSAWithSHA1},
+		crypto.SHA256: {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+		crypto.SHA384: {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+		crypto.SHA512: {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+	}[hash]
+	switch certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return algorithms[0], nil
+	case *ecdsa.PublicKey:
+		return algorithms[1], nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported public key algorithm %s", certificate.PublicKeyAlgorithm)
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// checkMessageDigest compares the messageDigest authenticated attribute with
+// the digest of content.
+func checkMessageDigest(attributes []byte, hash crypto.Hash, content []byte) error {
+	for len(attributes) > 0 {
+		var attribute pkcs7Attribute
+		rest, err := asn1.Unmarshal(attributes, &attribute)
+		if err != nil {
+			return fmt.Errorf("failed to parse authenticated attributes: %w", err)
+		}
+		attributes = rest
+		if !attribute.Type.Equal(oidAttributeDigest) {
+			continue
+		}
+		var expected []byte
+		if _, err := asn1.Unmarshal(attribute.Values.Bytes, &expected); err != nil {
+			return fmt.Errorf("failed to parse message digest: %w", err)
+		}
+		digest := hash.New()
+		digest.Write(content)
+		if !bytes.Equal(digest.Sum(nil), expected) {
+			return errors.New("message digest mismatch")
+		}
+		return nil
+	}
+	return errors.New("authenticated attributes have no message digest")
+}
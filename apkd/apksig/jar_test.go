@@ -0,0 +1,161 @@
+package apksig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testJarSigner struct {
+	key         *rsa.PrivateKey
+	certificate []byte
+}
+
+func newTestJarSigner(t *testing.T) testJarSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "repo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return testJarSigner{key: key, certificate: der}
+}
+
+func sha256Base64(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signedPKCS7 builds a SignedData with a SHA256withRSA signature over content.
+func (s testJarSigner) signedPKCS7(t *testing.T, content []byte) []byte {
+	t.Helper()
+	certificate, err := x509.ParseCertificate(s.certificate)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	digestAlgorithm := mustMarshal(t, pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256, Parameters: asn1.NullRawValue})
+	encryptionAlgorithm := mustMarshal(t, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, Parameters: asn1.NullRawValue})
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{FullBytes: append([]byte{0x31, byte(len(digestAlgorithm))}, digestAlgorithm...)},
+		ContentInfo:      asn1.RawValue{FullBytes: mustMarshal(t, struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.certificate},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerial: pkcs7IssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: certificate.RawIssuer},
+				SerialNumber: certificate.SerialNumber,
+			},
+			DigestAlgorithm:      asn1.RawValue{FullBytes: digestAlgorithm},
+			DigestEncryptionAlgo: asn1.RawValue{FullBytes: encryptionAlgorithm},
+			EncryptedDigest:      signature,
+		}},
+	}
+	return mustMarshal(t, pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: mustMarshal(t, signedData)},
+	})
+}
+
+// signedJar builds a jar holding entry.json signed like jarsigner does.
+func (s testJarSigner) signedJar(t *testing.T, entry []byte) map[string][]byte {
+	t.Helper()
+	section := "Name: entry.json\r\nSHA-256-Digest: " + sha256Base64(entry) + "\r\n\r\n"
+	manifest := []byte("Manifest-Version: 1.0\r\nCreated-By: test\r\n\r\n" + section)
+	signatureFile := []byte("Signature-Version: 1.0\r\nSHA-256-Digest-Manifest: " + sha256Base64(manifest) + "\r\n\r\n" +
+		"Name: entry.json\r\nSHA-256-Digest: " + sha256Base64([]byte(section)) + "\r\n\r\n")
+	return map[string][]byte{
+		"entry.json":           entry,
+		"META-INF/MANIFEST.MF": manifest,
+		"META-INF/REPO.SF":     signatureFile,
+		"META-INF/REPO.RSA":    s.signedPKCS7(t, signatureFile),
+	}
+}
+
+func TestVerifyJarEntry(t *testing.T) {
+	signer := newTestJarSigner(t)
+	jar := newTestZip(t, signer.signedJar(t, []byte(`{"timestamp":1}`)))
+
+	entry, digest, err := VerifyJarEntry(bytes.NewReader(jar), int64(len(jar)), "entry.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(entry) != `{"timestamp":1}` {
+		t.Fatalf("unexpected entry: %q", entry)
+	}
+	if digest != certificateDigest(signer.certificate) {
+		t.Fatalf("expected signer digest %s, got %s", certificateDigest(signer.certificate), digest)
+	}
+}
+
+func TestVerifyJarEntryRejectsTampering(t *testing.T) {
+	signer := newTestJarSigner(t)
+	for name, tamper := range map[string]func(files map[string][]byte){
+		"entry": func(files map[string][]byte) {
+			files["entry.json"] = []byte(`{"timestamp":2}`)
+		},
+		"manifest": func(files map[string][]byte) {
+			files["META-INF/MANIFEST.MF"] = append(files["META-INF/MANIFEST.MF"], "Name: other\r\n\r\n"...)
+		},
+		"signature file": func(files map[string][]byte) {
+			files["META-INF/REPO.SF"] = bytes.Replace(files["META-INF/REPO.SF"], []byte("1.0"), []byte("1.1"), 1)
+		},
+		"signature": func(files map[string][]byte) {
+			// The encrypted digest is the last field of the signature block.
+			files["META-INF/REPO.RSA"][len(files["META-INF/REPO.RSA"])-1] ^= 0xff
+		},
+		"unsigned": func(files map[string][]byte) {
+			delete(files, "META-INF/REPO.RSA")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			files := signer.signedJar(t, []byte(`{"timestamp":1}`))
+			tamper(files)
+			jar := newTestZip(t, files)
+			if _, _, err := VerifyJarEntry(bytes.NewReader(jar), int64(len(jar)), "entry.json"); err == nil {
+				t.Fatalf("expected verification error")
+			}
+		})
+	}
+}
+
+func TestParseManifestJoinsContinuationLines(t *testing.T) {
+	mainSection, sections, err := parseManifest([]byte("Manifest-Version: 1.0\n\nName: a/very/long/na\n me.json\nSHA-256-Digest: abc\n\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mainSection["manifest-version"] != "1.0" {
+		t.Fatalf("unexpected main section: %v", mainSection)
+	}
+	section, found := sections["a/very/long/name.json"]
+	if !found || section["sha-256-digest"] != "abc" {
+		t.Fatalf("unexpected sections: %v", sections)
+	}
+	if _, _, err := parseManifest([]byte(" orphan\n")); err == nil || !strings.Contains(err.Error(), "continuation") {
+		t.Fatalf("expected continuation line error, got %v", err)
+	}
+}
@@ -10,15 +10,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kiber-io/apkd/apkd/apksig"
+	"github.com/kiber-io/apkd/apkd/devices"
+
 	"gopkg.in/yaml.v3"
 )
 
 type AppConfig struct {
-	Version  int                     `yaml:"version"`
-	Defaults ConfigDefaults          `yaml:"defaults"`
-	Runtime  ConfigRuntime           `yaml:"runtime"`
-	Network  ConfigNetwork           `yaml:"network"`
-	Sources  map[string]SourceConfig `yaml:"sources"`
+	Version   int                               `yaml:"version"`
+	Defaults  ConfigDefaults                    `yaml:"defaults"`
+	Runtime   ConfigRuntime                     `yaml:"runtime"`
+	Network   ConfigNetwork                     `yaml:"network"`
+	Sources   map[string]SourceConfig           `yaml:"sources"`
+	Pins      map[string][]string               `yaml:"pins"`
+	Packages  []string                          `yaml:"packages"`
+	Selection ConfigSelection                   `yaml:"selection"`
+	Repos     map[string]ConfigRepo             `yaml:"repos"`
+	Device    ConfigDevice                      `yaml:"device"`
+	Devices   map[string]ConfigDeviceDefinition `yaml:"devices"`
 }
 
 const (
@@ -82,14 +91,15 @@ type ConfigNetwork struct {
 }
 
 type ConfigRetry struct {
-	MaxAttempts *int  `yaml:"max_attempts"`
-	DelayMs     *int  `yaml:"delay_ms"`
-	MaxDelayMs  *int  `yaml:"max_delay_ms"`
-	RetryStatus []int `yaml:"retry_status"`
+	MaxAttempts     *int  `yaml:"max_attempts"`
+	DelayMs         *int  `yaml:"delay_ms"`
+	MaxDelayMs      *int  `yaml:"max_delay_ms"`
+	MaxRetryAfterMs *int  `yaml:"max_retry_after_ms"`
+	RetryStatus     []int `yaml:"retry_status"`
 }
 
 func (r ConfigRetry) IsSet() bool {
-	return r.MaxAttempts != nil || r.DelayMs != nil || r.MaxDelayMs != nil || len(r.RetryStatus) > 0
+	return r.MaxAttempts != nil || r.DelayMs != nil || r.MaxDelayMs != nil || r.MaxRetryAfterMs != nil || len(r.RetryStatus) > 0
 }
 
 type ConfigProxy struct {
@@ -98,6 +108,73 @@ type ConfigProxy struct {
 	PerSource          map[string]string `yaml:"per_source"`
 }
 
+type ConfigSelection struct {
+	Strategy *string  `yaml:"strategy"`
+	Priority []string `yaml:"priority"`
+	Fallback *string  `yaml:"fallback"`
+}
+
+// ConfigRepo is an F-Droid-compatible repository added as its own source.
+type ConfigRepo struct {
+	BaseURL     string `yaml:"base_url"`
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+// ConfigDevice selects the device profile sources present to the stores.
+type ConfigDevice struct {
+	Name    *string  `yaml:"name"`
+	ABI     *string  `yaml:"abi"`
+	SDK     *int     `yaml:"sdk"`
+	DPI     *int     `yaml:"dpi"`
+	Locales []string `yaml:"locales"`
+}
+
+// ConfigDeviceDefinition is a custom device selectable by name. Density and
+// fingerprint are derived when left empty and checked when set.
+type ConfigDeviceDefinition struct {
+	Brand        string   `yaml:"brand"`
+	Manufacturer string   `yaml:"manufacturer"`
+	Model        string   `yaml:"model"`
+	Device       string   `yaml:"device"`
+	Product      string   `yaml:"product"`
+	SDK          int      `yaml:"sdk"`
+	DPI          int      `yaml:"dpi"`
+	Width        int      `yaml:"width"`
+	Height       int      `yaml:"height"`
+	ABIs         []string `yaml:"abis"`
+	BuildID      string   `yaml:"build_id"`
+	Density      string   `yaml:"density"`
+	Fingerprint  string   `yaml:"fingerprint"`
+	Locales      []string `yaml:"locales"`
+}
+
+func (d ConfigDeviceDefinition) device() (devices.Device, error) {
+	locales, err := devices.NormalizeLocales(d.Locales)
+	if err != nil {
+		return devices.Device{}, err
+	}
+	device := devices.Device{
+		Brand:        d.Brand,
+		Manufacturer: d.Manufacturer,
+		Model:        d.Model,
+		Device:       d.Device,
+		Product:      d.Product,
+		SDKInt:       d.SDK,
+		DPI:          d.DPI,
+		Width:        d.Width,
+		Height:       d.Height,
+		CPUAbis:      d.ABIs,
+		BuildID:      d.BuildID,
+		Density:      d.Density,
+		Fingerprint:  d.Fingerprint,
+		Locales:      locales,
+	}
+	if device.Width == 0 && device.Height == 0 {
+		device.Width, device.Height = 1080, 2400
+	}
+	return devices.Complete(device)
+}
+
 type SourceConfig struct {
 	Node *yaml.Node
 }
@@ -281,6 +358,111 @@ func normalizeConfig(cfg *AppConfig, configDir string) error {
 	}
 	cfg.Sources = normalizedSourceCfg
 
+	normalizedPins := make(map[string][]string, len(cfg.Pins))
+	for packageName, digests := range cfg.Pins {
+		normalizedPackageName := strings.TrimSpace(packageName)
+		if normalizedPackageName == "" {
+			return errors.New("pins contains an empty package name")
+		}
+		if len(digests) == 0 {
+			return fmt.Errorf("pins[%s] must list at least one certificate sha256", normalizedPackageName)
+		}
+		for _, digest := range digests {
+			normalizedDigest, err := apksig.NormalizeDigest(digest)
+			if err != nil {
+				return fmt.Errorf("pins[%s]: %w", normalizedPackageName, err)
+			}
+			normalizedPins[normalizedPackageName] = append(normalizedPins[normalizedPackageName], normalizedDigest)
+		}
+	}
+	cfg.Pins = normalizedPins
+
+	if cfg.Selection.Strategy != nil {
+		strategy := strings.ToLower(strings.TrimSpace(*cfg.Selection.Strategy))
+		if err := validateSelectionStrategyName(strategy); err != nil {
+			return fmt.Errorf("selection.strategy: %w", err)
+		}
+		cfg.Selection.Strategy = &strategy
+	}
+	if cfg.Selection.Fallback != nil {
+		fallback := strings.ToLower(strings.TrimSpace(*cfg.Selection.Fallback))
+		if err := validateFallbackPolicyName(fallback); err != nil {
+			return fmt.Errorf("selection.fallback: %w", err)
+		}
+		cfg.Selection.Fallback = &fallback
+	}
+	normalizedPriority := make([]string, 0, len(cfg.Selection.Priority))
+	for _, sourceName := range cfg.Selection.Priority {
+		normalizedSourceName := strings.ToLower(strings.TrimSpace(sourceName))
+		if normalizedSourceName == "" {
+			return errors.New("selection.priority contains an empty source name")
+		}
+		normalizedPriority = append(normalizedPriority, normalizedSourceName)
+	}
+	cfg.Selection.Priority = normalizedPriority
+
+	normalizedPackages := make([]string, 0, len(cfg.Packages))
+	for _, packageSpec := range cfg.Packages {
+		normalizedPackageSpec := strings.TrimSpace(packageSpec)
+		if _, _, err := parsePackageSpec(normalizedPackageSpec); err != nil {
+			return fmt.Errorf("packages: %w", err)
+		}
+		normalizedPackages = append(normalizedPackages, normalizedPackageSpec)
+	}
+	cfg.Packages = normalizedPackages
+
+	normalizedRepos := make(map[string]ConfigRepo, len(cfg.Repos))
+	for repoName, repo := range cfg.Repos {
+		normalizedRepoName := strings.ToLower(strings.TrimSpace(repoName))
+		if normalizedRepoName == "" {
+			return errors.New("repos contains an empty repository name")
+		}
+		repo.BaseURL = strings.TrimSpace(repo.BaseURL)
+		if repo.BaseURL == "" {
+			return fmt.Errorf("repos.%s.base_url must be non-empty", normalizedRepoName)
+		}
+		if strings.TrimSpace(repo.Fingerprint) != "" {
+			fingerprint, err := apksig.NormalizeDigest(repo.Fingerprint)
+			if err != nil {
+				return fmt.Errorf("repos.%s.fingerprint: %w", normalizedRepoName, err)
+			}
+			repo.Fingerprint = fingerprint
+		}
+		normalizedRepos[normalizedRepoName] = repo
+	}
+	cfg.Repos = normalizedRepos
+
+	if cfg.Device.Name != nil {
+		name := strings.ToLower(strings.TrimSpace(*cfg.Device.Name))
+		cfg.Device.Name = &name
+	}
+	if cfg.Device.ABI != nil {
+		abi := strings.TrimSpace(*cfg.Device.ABI)
+		cfg.Device.ABI = &abi
+	}
+	if len(cfg.Device.Locales) > 0 {
+		locales, err := devices.NormalizeLocales(cfg.Device.Locales)
+		if err != nil {
+			return fmt.Errorf("device.locales: %w", err)
+		}
+		cfg.Device.Locales = locales
+	}
+	normalizedDevices := make(map[string]ConfigDeviceDefinition, len(cfg.Devices))
+	for deviceName, definition := range cfg.Devices {
+		normalizedDeviceName := strings.ToLower(strings.TrimSpace(deviceName))
+		if normalizedDeviceName == "" {
+			return errors.New("devices contains an empty device name")
+		}
+		if devices.IsBuiltIn(normalizedDeviceName) {
+			return fmt.Errorf("devices.%s: name is reserved by a built-in device", normalizedDeviceName)
+		}
+		if _, err := definition.device(); err != nil {
+			return fmt.Errorf("devices.%s: %w", normalizedDeviceName, err)
+		}
+		normalizedDevices[normalizedDeviceName] = definition
+	}
+	cfg.Devices = normalizedDevices
+
 	return nil
 }
 
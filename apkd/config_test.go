@@ -23,6 +23,11 @@ type mainStateSnapshot struct {
 	verbosity               int
 	selectedSources         []string
 	workers                 int
+	deviceName              string
+	deviceABI               string
+	deviceSDK               int
+	deviceDPI               int
+	deviceLocales           []string
 }
 
 func snapshotMainState() mainStateSnapshot {
@@ -38,6 +43,11 @@ func snapshotMainState() mainStateSnapshot {
 		verbosity:               verbosity,
 		selectedSources:         append([]string(nil), selectedSources...),
 		workers:                 workers,
+		deviceName:              deviceName,
+		deviceABI:               deviceABI,
+		deviceSDK:               deviceSDK,
+		deviceDPI:               deviceDPI,
+		deviceLocales:           append([]string(nil), deviceLocales...),
 	}
 }
 
@@ -53,6 +63,11 @@ func restoreMainState(state mainStateSnapshot) {
 	verbosity = state.verbosity
 	selectedSources = append([]string(nil), state.selectedSources...)
 	workers = state.workers
+	deviceName = state.deviceName
+	deviceABI = state.deviceABI
+	deviceSDK = state.deviceSDK
+	deviceDPI = state.deviceDPI
+	deviceLocales = append([]string(nil), state.deviceLocales...)
 }
 
 func newConfigApplyCommand(t *testing.T, args ...string) *cobra.Command {
@@ -68,6 +83,10 @@ func newConfigApplyCommand(t *testing.T, args ...string) *cobra.Command {
 	cmd.Flags().String("proxy", "", "")
 	cmd.Flags().Bool("proxy-insecure", false, "")
 	cmd.Flags().StringArray("source-proxy", nil, "")
+	cmd.Flags().String("device", "", "")
+	cmd.Flags().String("abi", "", "")
+	cmd.Flags().Int("sdk", 0, "")
+	cmd.Flags().Int("dpi", 0, "")
 	if err := cmd.Flags().Parse(args); err != nil {
 		t.Fatalf("failed to parse test flags: %v", err)
 	}
@@ -307,6 +326,7 @@ network:
     max_attempts: 7
     delay_ms: 1500
     max_delay_ms: 9000
+    max_retry_after_ms: 60000
     retry_status: [429, 500]
   proxy:
     global: http://127.0.0.1:8080
@@ -372,7 +392,7 @@ sources:
 	if resolvedCfg.clientTimeout == nil || resolvedCfg.clientTimeout.String() != "45s" {
 		t.Fatalf("unexpected client timeout: %v", resolvedCfg.clientTimeout)
 	}
-	if resolvedCfg.retryPolicy == nil || resolvedCfg.retryPolicy.MaxAttempts != 7 {
+	if resolvedCfg.retryPolicy == nil || resolvedCfg.retryPolicy.MaxAttempts != 7 || resolvedCfg.retryPolicy.MaxRetryAfter != 60000 {
 		t.Fatalf("unexpected retry policy: %+v", resolvedCfg.retryPolicy)
 	}
 	configAny, configExists := resolvedCfg.sourceConfigs["rustore"]
@@ -537,3 +557,175 @@ network:
 		}
 	}
 }
+
+func TestLoadConfigNormalizesPins(t *testing.T) {
+	configPath := writeTestConfig(t, `
+version: 2
+pins:
+  org.example.app:
+    - "43:23:8D:51:2C:1E:5E:B2:D6:56:9F:4A:3A:FB:F5:52:34:18:B8:2E:0A:3E:D1:55:27:70:AB:B9:A9:C9:CC:AB"
+`)
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pins := cfg.Pins["org.example.app"]
+	if len(pins) != 1 || pins[0] != "43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab" {
+		t.Fatalf("unexpected pins: %v", cfg.Pins)
+	}
+}
+
+func TestLoadConfigRejectsInvalidPin(t *testing.T) {
+	configPath := writeTestConfig(t, `
+version: 2
+pins:
+  org.example.app: ["not-a-digest"]
+`)
+	if _, err := loadConfig(configPath); err == nil || !strings.Contains(err.Error(), "pins[org.example.app]") {
+		t.Fatalf("expected invalid pin error, got %v", err)
+	}
+}
+
+func TestLoadConfigNormalizesRepos(t *testing.T) {
+	configPath := writeTestConfig(t, `
+version: 2
+repos:
+  " IzzyOnDroid ":
+    base_url: " https://apt.izzysoft.de/fdroid "
+    fingerprint: "3D:C8:98:0F:A5:B7:2E:5E:D3:A8:3D:54:2B:AD:8F:6B:9D:C4:7E:D4:1E:05:0C:A2:5E:95:5B:85:B2:14:9D:11"
+`)
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo, ok := cfg.Repos["izzyondroid"]
+	if !ok {
+		t.Fatalf("expected normalized repository name, got %+v", cfg.Repos)
+	}
+	if repo.BaseURL != "https://apt.izzysoft.de/fdroid" || repo.Fingerprint != "3dc8980fa5b72e5ed3a83d542bad8f6b9dc47ed41e050ca25e955b85b2149d11" {
+		t.Fatalf("unexpected repository: %+v", repo)
+	}
+}
+
+func TestLoadConfigRejectsRepoWithoutBaseURL(t *testing.T) {
+	configPath := writeTestConfig(t, `
+version: 2
+repos:
+  izzy:
+    fingerprint: "3dc8980fa5b72e5ed3a83d542bad8f6b9dc47ed41e050ca25e955b85b2149d11"
+`)
+	if _, err := loadConfig(configPath); err == nil || !strings.Contains(err.Error(), "repos.izzy.base_url") {
+		t.Fatalf("expected missing base_url error, got %v", err)
+	}
+}
+
+func TestLoadConfigRejectsInvalidPackageSpec(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(configPath, []byte("version: 2\npackages:\n  - com.example@latest-x\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := loadConfig(configPath); err == nil || !strings.Contains(err.Error(), "packages") {
+		t.Fatalf("expected packages validation error, got %v", err)
+	}
+}
+
+func TestLoadConfigNormalizesSelection(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(configPath, []byte("version: 2\nselection:\n  strategy: Priority-Order\n  priority: [FDroid, rustore]\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *cfg.Selection.Strategy != strategyPriorityOrder || len(cfg.Selection.Priority) != 2 || cfg.Selection.Priority[0] != "fdroid" {
+		t.Fatalf("unexpected selection config: %+v", cfg.Selection)
+	}
+
+	if err := os.WriteFile(configPath, []byte("version: 2\nselection:\n  strategy: newest\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := loadConfig(configPath); err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+}
+
+func TestApplyConfigDeviceProfile(t *testing.T) {
+	state := snapshotMainState()
+	t.Cleanup(func() {
+		restoreMainState(state)
+	})
+	configFile = writeTestConfig(t, `
+version: 2
+device:
+  name: " Tablet "
+  abi: x86
+  sdk: 30
+  locales: [de-DE]
+devices:
+  tablet:
+    brand: lenovo
+    manufacturer: Lenovo
+    model: TB-X606F
+    device: X606F
+    product: X606F
+    sdk: 29
+    dpi: 213
+    width: 1200
+    height: 1920
+    abis: [x86_64, x86]
+    build_id: QP1A.190711.020
+`)
+	deviceName, deviceABI, deviceSDK, deviceDPI = "", "", 0, 0
+	cmd := newConfigApplyCommand(t, "--sdk=31")
+
+	resolved, overrideLogs, err := applyConfig(cmd)
+	if err != nil {
+		t.Fatalf("unexpected apply config error: %v", err)
+	}
+	if deviceName != "tablet" || deviceABI != "x86" || deviceSDK != 0 {
+		t.Fatalf("unexpected device flags: name=%q abi=%q sdk=%d", deviceName, deviceABI, deviceSDK)
+	}
+	if len(deviceLocales) != 1 || deviceLocales[0] != "de_DE" {
+		t.Fatalf("unexpected device locales: %v", deviceLocales)
+	}
+	if !strings.Contains(strings.Join(overrideLogs, "\n"), "CLI flag --sdk overrides config value device.sdk") {
+		t.Fatalf("expected --sdk override log, got %v", overrideLogs)
+	}
+	tablet, ok := resolved.devices["tablet"]
+	if !ok || tablet.Density != "hdpi" || tablet.Fingerprint != "lenovo/X606F/X606F:10/QP1A.190711.020:user/release-keys" {
+		t.Fatalf("unexpected custom device: %+v", tablet)
+	}
+}
+
+func TestLoadConfigRejectsInvalidDevices(t *testing.T) {
+	for name, body := range map[string]string{
+		"devices.pixel7": `
+devices:
+  pixel7: {brand: google}
+`,
+		"devices.tablet": `
+devices:
+  tablet:
+    brand: lenovo
+    manufacturer: Lenovo
+    model: TB-X606F
+    device: X606F
+    product: X606F
+    sdk: 29
+    dpi: 213
+    abis: [x86_64]
+    build_id: QP1A.190711.020
+    density: xxhdpi
+`,
+		"device.locales": `
+device:
+  locales: [english]
+`,
+	} {
+		configPath := writeTestConfig(t, "version: 2\n"+body)
+		if _, err := loadConfig(configPath); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected %s error, got %v", name, err)
+		}
+	}
+}
@@ -24,6 +24,9 @@ type Device struct {
 	BuildID        string
 	Fingerprint    string
 	AndroidID      string
+	// Locales are the locales the device reports, e.g. en_US. Empty leaves
+	// the choice to the source.
+	Locales []string
 }
 
 type sdkInfo struct {
@@ -53,8 +56,15 @@ var buildIDs = []string{
 	"AP1A.240205.002",
 }
 
-var templates = []Device{
-	{
+// template is a built-in device. The SDK, DPI and build of the device are
+// used when it is selected by name; RandomDevice replaces them.
+type template struct {
+	name   string
+	device Device
+}
+
+var templates = []template{
+	{"pixel7", Device{
 		Brand:        "google",
 		Manufacturer: "Google",
 		Model:        "Pixel 7",
@@ -63,8 +73,11 @@ var templates = []Device{
 		CPUAbis:      []string{"arm64-v8a", "armeabi-v7a"},
 		Width:        1080,
 		Height:       2400,
-	},
-	{
+		SDKInt:       34,
+		DPI:          420,
+		BuildID:      "UP1A.231005.007",
+	}},
+	{"pixel6", Device{
 		Brand:        "google",
 		Manufacturer: "Google",
 		Model:        "Pixel 6",
@@ -73,8 +86,11 @@ var templates = []Device{
 		CPUAbis:      []string{"arm64-v8a", "armeabi-v7a"},
 		Width:        1080,
 		Height:       2400,
-	},
-	{
+		SDKInt:       33,
+		DPI:          420,
+		BuildID:      "TQ3A.230805.001",
+	}},
+	{"galaxy-s21", Device{
 		Brand:        "samsung",
 		Manufacturer: "Samsung",
 		Model:        "SM-G991B",
@@ -83,8 +99,11 @@ var templates = []Device{
 		CPUAbis:      []string{"arm64-v8a", "armeabi-v7a"},
 		Width:        1080,
 		Height:       2400,
-	},
-	{
+		SDKInt:       33,
+		DPI:          420,
+		BuildID:      "TQ3A.230805.001",
+	}},
+	{"galaxy-s23", Device{
 		Brand:        "samsung",
 		Manufacturer: "Samsung",
 		Model:        "SM-S911B",
@@ -93,8 +112,11 @@ var templates = []Device{
 		CPUAbis:      []string{"arm64-v8a", "armeabi-v7a"},
 		Width:        1080,
 		Height:       2340,
-	},
-	{
+		SDKInt:       34,
+		DPI:          420,
+		BuildID:      "UP1A.231005.007",
+	}},
+	{"xiaomi-12", Device{
 		Brand:        "xiaomi",
 		Manufacturer: "Xiaomi",
 		Model:        "2201123G",
@@ -103,12 +125,16 @@ var templates = []Device{
 		CPUAbis:      []string{"arm64-v8a", "armeabi-v7a"},
 		Width:        1080,
 		Height:       2400,
-	},
+		SDKInt:       34,
+		DPI:          440,
+		BuildID:      "AP1A.240205.002",
+	}},
 }
 
 func RandomDevice() Device {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	tpl := templates[rng.Intn(len(templates))]
+	tpl := templates[rng.Intn(len(templates))].device
+	tpl.CPUAbis = append([]string(nil), tpl.CPUAbis...)
 	sdk := sdks[rng.Intn(len(sdks))]
 	dpi := dpis[rng.Intn(len(dpis))]
 	build := buildIDs[rng.Intn(len(buildIDs))]
@@ -125,11 +151,18 @@ func RandomDevice() Device {
 	return tpl
 }
 
+// densityBucket returns the resource density bucket Android picks for dpi.
 func densityBucket(dpi int) string {
 	switch {
+	case dpi <= 120:
+		return "ldpi"
+	case dpi <= 160:
+		return "mdpi"
+	case dpi <= 240:
+		return "hdpi"
 	case dpi <= 320:
 		return "xhdpi"
-	case dpi <= 400:
+	case dpi <= 480:
 		return "xxhdpi"
 	default:
 		return "xxxhdpi"
@@ -20,3 +20,94 @@ func TestRandomDeviceContainsAndroidID(t *testing.T) {
 		t.Fatalf("unexpected android id in device: %q", device.AndroidID)
 	}
 }
+
+func TestResolveDefaultsToPixel7(t *testing.T) {
+	device, err := Resolve(Profile{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device.Model != "Pixel 7" || device.SDKInt != 34 || device.DPI != 420 || device.Density != "xxhdpi" {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+	if device.Fingerprint != "google/panther/panther:14/UP1A.231005.007:user/release-keys" {
+		t.Fatalf("unexpected fingerprint: %s", device.Fingerprint)
+	}
+	if !androidIDFormatRegexp.MatchString(device.AndroidID) {
+		t.Fatalf("unexpected android id in device: %q", device.AndroidID)
+	}
+}
+
+func TestResolveAppliesOverrides(t *testing.T) {
+	device, err := Resolve(Profile{Device: "Galaxy-S21", ABI: "armeabi-v7a", SDK: 29, DPI: 560, Locales: []string{"de-DE"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(device.CPUAbis) != 1 || device.CPUAbis[0] != "armeabi-v7a" {
+		t.Fatalf("unexpected ABIs: %v", device.CPUAbis)
+	}
+	if device.SDKInt != 29 || device.AndroidVersion != "10" || device.Density != "xxxhdpi" {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+	if device.Fingerprint != "samsung/o1sxx/o1s:10/TQ3A.230805.001:user/release-keys" {
+		t.Fatalf("fingerprint was not rebuilt: %s", device.Fingerprint)
+	}
+	if len(device.Locales) != 1 || device.Locales[0] != "de_DE" {
+		t.Fatalf("unexpected locales: %v", device.Locales)
+	}
+	if template, _ := builtIn("galaxy-s21"); len(template.CPUAbis) != 2 {
+		t.Fatalf("override changed the built-in device: %v", template.CPUAbis)
+	}
+}
+
+func TestResolveRejectsInconsistentProfile(t *testing.T) {
+	for name, profile := range map[string]Profile{
+		"unknown device":  {Device: "nokia3310"},
+		"unknown ABI":     {ABI: "mips"},
+		"unsupported ABI": {ABI: "x86_64"},
+		"unknown SDK":     {SDK: 19},
+		"DPI too high":    {DPI: 900},
+		"bad locale":      {Locales: []string{"english"}},
+	} {
+		if _, err := Resolve(profile, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResolveCustomDevice(t *testing.T) {
+	tablet, err := Complete(Device{
+		Brand: "lenovo", Manufacturer: "Lenovo", Model: "TB-X606F", Device: "X606F", Product: "X606F",
+		SDKInt: 29, DPI: 213, Width: 1200, Height: 1920, CPUAbis: []string{"x86_64", "x86"}, BuildID: "QP1A.190711.020",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device, err := Resolve(Profile{Device: "tablet", ABI: "x86"}, map[string]Device{"tablet": tablet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device.Model != "TB-X606F" || device.Density != "hdpi" || len(device.CPUAbis) != 1 || device.CPUAbis[0] != "x86" {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+}
+
+func TestCompleteChecksDerivedFields(t *testing.T) {
+	device, _ := builtIn("pixel6")
+	device.Density = "mdpi"
+	if _, err := Complete(device); err == nil {
+		t.Fatalf("expected error for density that does not match the DPI")
+	}
+	device, _ = builtIn("pixel6")
+	device.Fingerprint = "google/oriole/oriole:12/SQ1A.220105.002:user/release-keys"
+	if _, err := Complete(device); err == nil {
+		t.Fatalf("expected error for fingerprint that does not match the build")
+	}
+}
+
+func TestDensityBucket(t *testing.T) {
+	for dpi, want := range map[int]string{120: "ldpi", 160: "mdpi", 213: "hdpi", 320: "xhdpi", 420: "xxhdpi", 480: "xxhdpi", 560: "xxxhdpi"} {
+		if got := densityBucket(dpi); got != want {
+			t.Errorf("densityBucket(%d) = %s, want %s", dpi, got, want)
+		}
+	}
+}
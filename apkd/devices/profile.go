@@ -0,0 +1,235 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// Random selects a random built-in device with a random SDK and DPI on
+	// every run.
+	Random = "random"
+	// DefaultDevice is used when no device is selected.
+	DefaultDevice = "pixel7"
+
+	minDPI = 120
+	maxDPI = 640
+)
+
+// Profile selects the device sources present to the stores. Zero values keep
+// the values of the named device.
+type Profile struct {
+	// Device is the name of a built-in or custom device, or Random.
+	Device  string
+	ABI     string
+	SDK     int
+	DPI     int
+	Locales []string
+}
+
+// androidVersions maps the supported SDK levels to their Android release.
+var androidVersions = map[int]string{
+	21: "5.0",
+	22: "5.1",
+	23: "6.0",
+	24: "7.0",
+	25: "7.1",
+	26: "8.0",
+	27: "8.1",
+	28: "9",
+	29: "10",
+	30: "11",
+	31: "12",
+	32: "12",
+	33: "13",
+	34: "14",
+	35: "15",
+	36: "16",
+}
+
+// abiFamilies lists the ABIs a device with the given primary ABI runs.
+var abiFamilies = map[string][]string{
+	"arm64-v8a":   {"arm64-v8a", "armeabi-v7a"},
+	"armeabi-v7a": {"armeabi-v7a"},
+	"x86_64":      {"x86_64", "x86"},
+	"x86":         {"x86"},
+}
+
+var localeRegexp = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?$`)
+
+// Names returns the names of the built-in devices.
+func Names() []string {
+	names := make([]string, 0, len(templates))
+	for _, tpl := range templates {
+		names = append(names, tpl.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func builtIn(name string) (Device, bool) {
+	for _, tpl := range templates {
+		if tpl.name == name {
+			device := tpl.device
+			device.CPUAbis = append([]string(nil), device.CPUAbis...)
+			return device, true
+		}
+	}
+	return Device{}, false
+}
+
+// IsBuiltIn reports whether name is reserved by a built-in device or Random.
+func IsBuiltIn(name string) bool {
+	if name == Random {
+		return true
+	}
+	_, exists := builtIn(name)
+	return exists
+}
+
+// Complete fills the fields derived from the others (Android version,
+// density bucket, fingerprint) and checks that a custom device is
+// consistent. Density and Fingerprint, when set, must match the values
+// derived from the DPI and the build.
+func Complete(d Device) (Device, error) {
+	for field, value := range map[string]string{
+		"brand":        d.Brand,
+		"manufacturer": d.Manufacturer,
+		"model":        d.Model,
+		"device":       d.Device,
+		"product":      d.Product,
+		"build_id":     d.BuildID,
+	} {
+		if strings.TrimSpace(value) == "" {
+			return Device{}, fmt.Errorf("%s cannot be empty", field)
+		}
+	}
+	if len(d.CPUAbis) == 0 {
+		return Device{}, errors.New("abis cannot be empty")
+	}
+	for _, abi := range d.CPUAbis {
+		if _, known := abiFamilies[abi]; !known {
+			return Device{}, fmt.Errorf("unknown ABI %q, use one of %s", abi, strings.Join(knownABIs(), ", "))
+		}
+	}
+	androidVersion, ok := androidVersions[d.SDKInt]
+	if !ok {
+		return Device{}, fmt.Errorf("sdk %d is not supported, use 21-36", d.SDKInt)
+	}
+	if d.DPI < minDPI || d.DPI > maxDPI {
+		return Device{}, fmt.Errorf("dpi %d must be between %d and %d", d.DPI, minDPI, maxDPI)
+	}
+	if d.Width <= 0 || d.Height <= 0 {
+		return Device{}, errors.New("width and height must be > 0")
+	}
+	if d.AndroidVersion != "" && d.AndroidVersion != androidVersion {
+		return Device{}, fmt.Errorf("android version %s does not match sdk %d (Android %s)", d.AndroidVersion, d.SDKInt, androidVersion)
+	}
+	d.AndroidVersion = androidVersion
+	if density := densityBucket(d.DPI); d.Density == "" {
+		d.Density = density
+	} else if d.Density != density {
+		return Device{}, fmt.Errorf("density %s does not match %d dpi (%s)", d.Density, d.DPI, density)
+	}
+	if fingerprint := buildFingerprint(d); d.Fingerprint == "" {
+		d.Fingerprint = fingerprint
+	} else if d.Fingerprint != fingerprint {
+		return Device{}, fmt.Errorf("fingerprint %s does not match the device, expected %s", d.Fingerprint, fingerprint)
+	}
+	return d, nil
+}
+
+// Resolve builds the device selected by profile. custom holds the devices
+// defined in the config, already passed through Complete. The ABI, SDK and
+// DPI of the profile override those of the device; the ABI must be one the
+// device supports.
+func Resolve(profile Profile, custom map[string]Device) (Device, error) {
+	name := strings.ToLower(strings.TrimSpace(profile.Device))
+	if name == "" {
+		name = DefaultDevice
+	}
+	var device Device
+	if name == Random {
+		device = RandomDevice()
+	} else if customDevice, exists := custom[name]; exists {
+		device = customDevice
+		device.CPUAbis = append([]string(nil), device.CPUAbis...)
+	} else if builtInDevice, exists := builtIn(name); exists {
+		device = builtInDevice
+	} else {
+		return Device{}, fmt.Errorf("unknown device %q, use random or one of %s", name, strings.Join(availableNames(custom), ", "))
+	}
+
+	if abi := strings.TrimSpace(profile.ABI); abi != "" {
+		family, known := abiFamilies[abi]
+		if !known {
+			return Device{}, fmt.Errorf("unknown ABI %q, use one of %s", abi, strings.Join(knownABIs(), ", "))
+		}
+		if !slices.Contains(device.CPUAbis, abi) {
+			return Device{}, fmt.Errorf("device %s does not support ABI %s (supports %s)", name, abi, strings.Join(device.CPUAbis, ", "))
+		}
+		device.CPUAbis = slices.DeleteFunc(slices.Clone(family), func(candidate string) bool {
+			return !slices.Contains(device.CPUAbis, candidate)
+		})
+	}
+	if profile.SDK != 0 {
+		device.SDKInt = profile.SDK
+		device.AndroidVersion = ""
+	}
+	if profile.DPI != 0 {
+		device.DPI = profile.DPI
+		device.Density = ""
+	}
+	if len(profile.Locales) > 0 {
+		locales, err := NormalizeLocales(profile.Locales)
+		if err != nil {
+			return Device{}, err
+		}
+		device.Locales = locales
+	}
+	// The fingerprint embeds the Android version, so it is rebuilt after the
+	// overrides.
+	device.Fingerprint = ""
+	device, err := Complete(device)
+	if err != nil {
+		return Device{}, fmt.Errorf("device %s: %w", name, err)
+	}
+	device.AndroidID = generateAndroidID()
+	return device, nil
+}
+
+// NormalizeLocales turns locales such as en-US into the en_US form stores
+// expect and rejects malformed ones.
+func NormalizeLocales(locales []string) ([]string, error) {
+	normalized := make([]string, 0, len(locales))
+	for _, locale := range locales {
+		locale = strings.ReplaceAll(strings.TrimSpace(locale), "-", "_")
+		if !localeRegexp.MatchString(locale) {
+			return nil, fmt.Errorf("locale %q must look like en_US", locale)
+		}
+		normalized = append(normalized, locale)
+	}
+	return normalized, nil
+}
+
+func knownABIs() []string {
+	abis := make([]string, 0, len(abiFamilies))
+	for abi := range abiFamilies {
+		abis = append(abis, abi)
+	}
+	sort.Strings(abis)
+	return abis
+}
+
+func availableNames(custom map[string]Device) []string {
+	names := Names()
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+const (
+	partFileSuffix        = ".part"
+	partStateFileSuffix   = ".part.json"
+	maxDownloadAttempts   = 3
+	partStateSaveInterval = 8 * 1024 * 1024
+)
+
+// partState is the sidecar stored next to a .part file. It records where the
+// partial data came from so that a later attempt can ask the server for the
+// remaining bytes only.
+type partState struct {
+	Source       string `json:"source"`
+	PackageName  string `json:"package"`
+	VersionCode  int    `json:"version_code"`
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+	Written      int64  `json:"written"`
+}
+
+// validator returns the value to send as If-Range. Weak ETags cannot be used
+// for range requests, so Last-Modified is preferred over them.
+func (p *partState) validator() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+func (p *partState) matches(task VersionTask) bool {
+	return p.Source == task.Source.Name() &&
+		p.PackageName == task.Version.PackageName &&
+		p.VersionCode == task.Version.Code
+}
+
+func partStatePath(partPath string) string {
+	return strings.TrimSuffix(partPath, partFileSuffix) + partStateFileSuffix
+}
+
+func loadPartState(partPath string) (*partState, error) {
+	data, err := os.ReadFile(partStatePath(partPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read part state: %w", err)
+	}
+	var state partState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse part state: %w", err)
+	}
+	return &state, nil
+}
+
+func savePartState(partPath string, state *partState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode part state: %w", err)
+	}
+	statePath := partStatePath(partPath)
+	tmpPath := statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write part state: %w", err)
+	}
+	if err := os.Rename(tmpPath, statePath); err != nil {
+		return fmt.Errorf("failed to store part state: %w", err)
+	}
+	return nil
+}
+
+func removePartFiles(partPath string) error {
+	var errs []error
+	for _, path := range []string{partPath, partStatePath(partPath)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resumeRange returns the byte range to request for an existing part file, or
+// a zero range when the part cannot be resumed.
+func resumeRange(task VersionTask, partPath string) sources.ByteRange {
+	state, err := loadPartState(partPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Logd(fmt.Sprintf("Ignoring part state for %s: %v", partPath, err))
+		}
+		return sources.ByteRange{}
+	}
+	if !state.matches(task) || state.validator() == "" {
+		return sources.ByteRange{}
+	}
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return sources.ByteRange{}
+	}
+	if state.Size > 0 && info.Size() >= state.Size {
+		return sources.ByteRange{}
+	}
+	return sources.ByteRange{Offset: info.Size(), Validator: state.validator()}
+}
+
+// streamReadError marks errors returned by the download stream, as opposed to
+// errors writing the local file. Only the former are worth a resume attempt.
+type streamReadError struct {
+	err error
+}
+
+func (e *streamReadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamReadError) Unwrap() error {
+	return e.err
+}
+
+type streamReader struct {
+	reader io.Reader
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, &streamReadError{err: err}
+	}
+	return n, err //nolint:wrapcheck // io.EOF must pass through per io.Reader contract
+}
+
+// partWriter writes to the part file and periodically persists the number of
+// bytes written, so a crash leaves a sidecar close to the real file size.
+type partWriter struct {
+	file      *os.File
+	partPath  string
+	state     *partState
+	sinceSave int64
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.state.Written += int64(n)
+	w.sinceSave += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write part file: %w", err)
+	}
+	if w.sinceSave >= partStateSaveInterval {
+		w.sinceSave = 0
+		if err := savePartState(w.partPath, w.state); err != nil {
+			logger.Logd(fmt.Sprintf("Failed to save part state for %s: %v", w.partPath, err))
+		}
+	}
+	return n, nil
+}
+
+// downloadResult describes a completed part file.
+type downloadResult struct {
+	Size   int64
+	SHA256 string
+}
+
+// downloadToPart downloads the task's file into partPath, resuming from an
+// existing part file when possible and retrying interrupted streams with a
+// Range request. The file is hashed while it is written; when the source
+// published a checksum and it does not match, the part file is removed and a
+// *sources.ChecksumMismatchError is returned; a mismatch of an advisory
+// checksum is only logged. When ctx is cancelled the part
+// file is kept if it can be resumed later and removed otherwise.
+func (tq *TaskQueue) downloadToPart(ctx context.Context, task VersionTask, partPath string, bar *mpb.Bar) (downloadResult, error) {
+	byteRange := resumeRange(task, partPath)
+	if byteRange.Offset == 0 {
+		if err := removePartFiles(partPath); err != nil {
+			return downloadResult{}, fmt.Errorf("error removing stale part file %s: %w", partPath, err)
+		}
+	} else {
+		logger.Logd(fmt.Sprintf("Resuming download of %s from byte %d", task.Version.PackageName, byteRange.Offset))
+	}
+	var lastErr error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		result, err := tq.downloadAttempt(ctx, task, partPath, byteRange, bar)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			discardInterruptedPart(task, partPath)
+			return downloadResult{}, fmt.Errorf("download of %s interrupted: %w", task.Version.PackageName, context.Cause(ctx))
+		}
+		// A bundle part that fails its checksum fails the stream as well, but
+		// downloading the bundle again would give the same bytes.
+		var mismatchErr *sources.ChecksumMismatchError
+		if errors.As(err, &mismatchErr) {
+			if removeErr := removePartFiles(partPath); removeErr != nil {
+				logger.Logd(fmt.Sprintf("Failed to remove corrupt part file %s: %v", partPath, removeErr))
+			}
+			break
+		}
+		var readErr *streamReadError
+		if !errors.As(err, &readErr) || attempt == maxDownloadAttempts {
+			break
+		}
+		byteRange = resumeRange(task, partPath)
+		logger.Logw(fmt.Sprintf("Download of %s interrupted (%v), retrying from byte %d (attempt %d/%d)", task.Version.PackageName, err, byteRange.Offset, attempt+1, maxDownloadAttempts))
+	}
+	return downloadResult{}, lastErr
+}
+
+// discardInterruptedPart removes the part file left by a cancelled download
+// unless resumeRange would be able to continue it on the next run.
+func discardInterruptedPart(task VersionTask, partPath string) {
+	if resumeRange(task, partPath).Offset > 0 {
+		logger.Logd(fmt.Sprintf("Keeping %s to resume the download later", partPath))
+		return
+	}
+	if err := removePartFiles(partPath); err != nil {
+		logger.Logd(fmt.Sprintf("Failed to remove part file %s: %v", partPath, err))
+	}
+}
+
+func (tq *TaskQueue) downloadAttempt(ctx context.Context, task VersionTask, partPath string, byteRange sources.ByteRange, bar *mpb.Bar) (downloadResult, error) {
+	stream, err := sources.DownloadFrom(ctx, task.Source, task.Version, byteRange)
+	if err != nil && byteRange.Offset > 0 && ctx.Err() == nil {
+		logger.Logd(fmt.Sprintf("Range request for %s failed (%v), restarting download", task.Version.PackageName, err))
+		byteRange = sources.ByteRange{}
+		stream, err = sources.DownloadFrom(ctx, task.Source, task.Version, byteRange)
+	}
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("error downloading package %s from source %s: %w", task.Version.PackageName, task.Source.Name(), err)
+	}
+	if byteRange.Offset > 0 && stream.Offset == 0 {
+		logger.Logd(fmt.Sprintf("Server ignored range request for %s, restarting download", task.Version.PackageName))
+	}
+	// Prefer Content-Length from the response (authoritative). Fall back to
+	// source-reported metadata size, which is sometimes inaccurate. Zero means
+	// unknown: the bar tracks bytes without a target percentage.
+	totalSize := int64(-1)
+	if stream.Size >= 0 {
+		totalSize = stream.Offset + stream.Size
+	}
+	barSize := totalSize
+	if barSize <= 0 {
+		barSize = int64(task.Version.Size) //nolint:gosec // G115: APK sizes never approach int64 max
+	}
+	if barSize < 0 {
+		barSize = 0
+	}
+	bar.SetTotal(barSize, false)
+	bar.SetCurrent(stream.Offset)
+	progressReader := bar.ProxyReader(&streamReader{reader: stream.Body})
+	defer func() {
+		if closeErr := progressReader.Close(); closeErr != nil {
+			logger.Logd(fmt.Sprintf("Error closing download stream for package %s: %v", task.Version.PackageName, closeErr))
+		}
+	}()
+
+	expectedChecksum := task.Version.Checksum
+	if !stream.Checksum.IsZero() {
+		expectedChecksum = stream.Checksum
+	}
+	advisoryChecksum := false
+	if expectedChecksum.IsZero() && !stream.AdvisoryChecksum.IsZero() {
+		expectedChecksum, advisoryChecksum = stream.AdvisoryChecksum, true
+	}
+	hashers := []hash.Hash{sha256.New()}
+	if !expectedChecksum.IsZero() && expectedChecksum.Algorithm != sources.SHA256 {
+		verifyHash, err := expectedChecksum.NewHash()
+		if err != nil {
+			return downloadResult{}, fmt.Errorf("cannot verify package %s: %w", task.Version.PackageName, err)
+		}
+		hashers = append(hashers, verifyHash)
+	}
+	hashWriters := make([]io.Writer, 0, len(hashers))
+	for _, h := range hashers {
+		hashWriters = append(hashWriters, h)
+	}
+	hashWriter := io.MultiWriter(hashWriters...)
+
+	flags := os.O_RDWR | os.O_CREATE
+	if stream.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("error creating file %s: %w", partPath, err)
+	}
+	if stream.Offset > 0 {
+		if err := file.Truncate(stream.Offset); err != nil {
+			_ = file.Close()
+			return downloadResult{}, fmt.Errorf("error truncating file %s: %w", partPath, err)
+		}
+		// The bytes already on disk were never hashed by this process; feed
+		// them to the hashers once so the stream can continue from there.
+		if _, err := io.CopyN(hashWriter, file, stream.Offset); err != nil {
+			_ = file.Close()
+			return downloadResult{}, fmt.Errorf("error reading file %s: %w", partPath, err)
+		}
+	}
+	state := &partState{
+		Source:       task.Source.Name(),
+		PackageName:  task.Version.PackageName,
+		VersionCode:  task.Version.Code,
+		URL:          stream.URL,
+		ETag:         stream.ETag,
+		LastModified: stream.LastModified,
+		Size:         totalSize,
+		Written:      stream.Offset,
+	}
+	if err := savePartState(partPath, state); err != nil {
+		logger.Logd(fmt.Sprintf("Failed to save part state for %s: %v", partPath, err))
+	}
+	writer := io.MultiWriter(&partWriter{file: file, partPath: partPath, state: state}, hashWriter)
+	if _, err := io.Copy(writer, progressReader); err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			logger.Logd(fmt.Sprintf("Error closing file %s after write error: %v", partPath, closeErr))
+		}
+		if saveErr := savePartState(partPath, state); saveErr != nil {
+			logger.Logd(fmt.Sprintf("Failed to save part state for %s: %v", partPath, saveErr))
+		}
+		return downloadResult{}, fmt.Errorf("error saving file %s: %w", partPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return downloadResult{}, fmt.Errorf("error closing file %s: %w", partPath, err)
+	}
+	if totalSize >= 0 && state.Written != totalSize {
+		if saveErr := savePartState(partPath, state); saveErr != nil {
+			logger.Logd(fmt.Sprintf("Failed to save part state for %s: %v", partPath, saveErr))
+		}
+		return downloadResult{}, &streamReadError{err: fmt.Errorf("download of %s ended after %d of %d bytes", task.Version.PackageName, state.Written, totalSize)}
+	}
+	if err := os.Remove(partStatePath(partPath)); err != nil && !os.IsNotExist(err) {
+		logger.Logd(fmt.Sprintf("Failed to remove part state for %s: %v", partPath, err))
+	}
+	sha256Sum := hashers[0].Sum(nil)
+	if !expectedChecksum.IsZero() {
+		actualSum := hashers[len(hashers)-1].Sum(nil)
+		mismatchErr := &sources.ChecksumMismatchError{
+			Path:     partPath,
+			Expected: expectedChecksum,
+			Actual:   hex.EncodeToString(actualSum),
+		}
+		switch {
+		case expectedChecksum.Matches(actualSum):
+			logger.Logd(fmt.Sprintf("Verified %s checksum of package %s", expectedChecksum.Algorithm, task.Version.PackageName))
+		case advisoryChecksum:
+			logger.Logw(fmt.Sprintf("Keeping package %s from source %s despite an unconfirmed checksum: %v", task.Version.PackageName, task.Source.Name(), mismatchErr))
+		default:
+			if err := removePartFiles(partPath); err != nil {
+				logger.Logd(fmt.Sprintf("Failed to remove corrupt part file %s: %v", partPath, err))
+			}
+			return downloadResult{}, mismatchErr
+		}
+	}
+	return downloadResult{Size: state.Written, SHA256: hex.EncodeToString(sha256Sum)}, nil
+}
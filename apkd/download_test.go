@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+type rangeStubSource struct {
+	sources.BaseSource
+	content   string
+	etag      string
+	failAfter int
+	// interrupt, when set, is called instead of failing with a connection
+	// reset once failAfter bytes were read.
+	interrupt context.CancelFunc
+	advisory  sources.Checksum
+	ranges    []sources.ByteRange
+}
+
+func (s *rangeStubSource) Name() string { return "rangestub" }
+
+func (s *rangeStubSource) Download(ctx context.Context, version sources.Version) (*sources.DownloadStream, error) {
+	return s.DownloadRange(ctx, version, sources.ByteRange{})
+}
+
+func (s *rangeStubSource) DownloadRange(_ context.Context, _ sources.Version, byteRange sources.ByteRange) (*sources.DownloadStream, error) {
+	s.ranges = append(s.ranges, byteRange)
+	offset := byteRange.Offset
+	if byteRange.Validator != s.etag {
+		offset = 0
+	}
+	var body io.Reader = strings.NewReader(s.content[offset:])
+	if s.failAfter > 0 {
+		var failReader io.Reader = errReader{}
+		if s.interrupt != nil {
+			failReader = cancelReader{cancel: s.interrupt}
+		}
+		body = io.MultiReader(strings.NewReader(s.content[offset:s.failAfter]), failReader)
+		s.failAfter = 0
+	}
+	return &sources.DownloadStream{
+		Body:             io.NopCloser(body),
+		Size:             int64(len(s.content)) - offset,
+		Offset:           offset,
+		URL:              "https://example.com/app.apk",
+		ETag:             s.etag,
+		AdvisoryChecksum: s.advisory,
+	}, nil
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// cancelReader mimics an HTTP body whose request context gets cancelled.
+type cancelReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancelReader) Read([]byte) (int, error) {
+	r.cancel()
+	return 0, context.Canceled
+}
+
+func newTestBar(t *testing.T) *mpb.Bar {
+	t.Helper()
+	progress := mpb.New(mpb.WithOutput(io.Discard))
+	t.Cleanup(progress.Shutdown)
+	return progress.AddBar(0)
+}
+
+func TestDownloadToPartRetriesWithRange(t *testing.T) {
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`, failAfter: 4}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	result, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t))
+	if err != nil {
+		t.Fatalf("unexpected download error: %v", err)
+	}
+	if result.Size != 10 {
+		t.Fatalf("expected 10 bytes written, got %d", result.Size)
+	}
+	data, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("failed to read part file: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("unexpected part content %q", data)
+	}
+	if len(src.ranges) != 2 || src.ranges[1].Offset != 4 || src.ranges[1].Validator != `"v1"` {
+		t.Fatalf("expected retry from offset 4, got ranges %+v", src.ranges)
+	}
+	if _, err := os.Stat(partStatePath(partPath)); !os.IsNotExist(err) {
+		t.Fatalf("expected part state to be removed after completion, stat error: %v", err)
+	}
+}
+
+func TestDownloadToPartResumesExistingPart(t *testing.T) {
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+	if err := os.WriteFile(partPath, []byte("012345"), 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+	if err := savePartState(partPath, &partState{
+		Source:      src.Name(),
+		PackageName: "com.example",
+		VersionCode: 1,
+		ETag:        `"v1"`,
+		Size:        10,
+		Written:     6,
+	}); err != nil {
+		t.Fatalf("failed to save part state: %v", err)
+	}
+
+	tq := &TaskQueue{}
+	if _, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t)); err != nil {
+		t.Fatalf("unexpected download error: %v", err)
+	}
+	data, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("failed to read part file: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("unexpected part content %q", data)
+	}
+	if len(src.ranges) != 1 || src.ranges[0].Offset != 6 {
+		t.Fatalf("expected a single request from offset 6, got %+v", src.ranges)
+	}
+}
+
+func TestDownloadToPartRestartsWhenPartBelongsToOtherVersion(t *testing.T) {
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 2}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+	if err := os.WriteFile(partPath, []byte("xxxxxx"), 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+	if err := savePartState(partPath, &partState{Source: src.Name(), PackageName: "com.example", VersionCode: 1, ETag: `"v1"`}); err != nil {
+		t.Fatalf("failed to save part state: %v", err)
+	}
+
+	tq := &TaskQueue{}
+	if _, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t)); err != nil {
+		t.Fatalf("unexpected download error: %v", err)
+	}
+	data, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("failed to read part file: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("unexpected part content %q", data)
+	}
+	if len(src.ranges) != 1 || src.ranges[0].Offset != 0 {
+		t.Fatalf("expected a full download, got %+v", src.ranges)
+	}
+}
+
+func TestPartStateValidatorSkipsWeakETag(t *testing.T) {
+	state := &partState{ETag: `W/"weak"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	if got := state.validator(); got != state.LastModified {
+		t.Fatalf("expected Last-Modified validator, got %q", got)
+	}
+	state.ETag = `"strong"`
+	if got := state.validator(); got != `"strong"` {
+		t.Fatalf("expected strong ETag validator, got %q", got)
+	}
+}
+
+func TestDownloadToPartVerifiesChecksumAcrossResume(t *testing.T) {
+	// sha256("0123456789")
+	checksum, err := sources.NewChecksum(sources.SHA256, "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882")
+	if err != nil {
+		t.Fatalf("failed to build checksum: %v", err)
+	}
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`, failAfter: 4}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1, Checksum: checksum}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	result, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t))
+	if err != nil {
+		t.Fatalf("unexpected download error: %v", err)
+	}
+	if result.SHA256 != checksum.Digest {
+		t.Fatalf("expected sha256 %s, got %s", checksum.Digest, result.SHA256)
+	}
+}
+
+func TestDownloadToPartRemovesFileOnChecksumMismatch(t *testing.T) {
+	checksum, err := sources.NewChecksum(sources.MD5, "00000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("failed to build checksum: %v", err)
+	}
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1, Checksum: checksum}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	_, err = tq.downloadToPart(context.Background(), task, partPath, newTestBar(t))
+	var mismatchErr *sources.ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("expected checksum mismatch error, got %v", err)
+	}
+	// md5("0123456789")
+	if mismatchErr.Actual != "781e5e245d69b566979b86e28d23f2c7" {
+		t.Fatalf("unexpected actual digest %s", mismatchErr.Actual)
+	}
+	if len(src.ranges) != 1 {
+		t.Fatalf("expected checksum mismatch not to be retried, got ranges %+v", src.ranges)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupt part file to be removed, stat error: %v", err)
+	}
+}
+
+// bundleMismatchSource streams a bundle whose second part fails its checksum,
+// the way downloadBundle reports it through the pipe.
+type bundleMismatchSource struct {
+	sources.BaseSource
+	downloads int
+}
+
+func (s *bundleMismatchSource) Name() string { return "bundlestub" }
+
+func (s *bundleMismatchSource) Download(context.Context, sources.Version) (*sources.DownloadStream, error) {
+	s.downloads++
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("base.apk"))
+		writer.CloseWithError(&sources.ChecksumMismatchError{Path: "split_config.arm64_v8a.apk", Actual: "00"})
+	}()
+	return &sources.DownloadStream{Body: reader, Size: -1}, nil
+}
+
+func TestDownloadToPartDoesNotRetryBundlePartChecksumMismatch(t *testing.T) {
+	src := &bundleMismatchSource{}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1, Type: sources.APKS}}
+	partPath := filepath.Join(t.TempDir(), "app.apks"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	_, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t))
+	var mismatchErr *sources.ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) || mismatchErr.Path != "split_config.arm64_v8a.apk" {
+		t.Fatalf("expected the part checksum mismatch, got %v", err)
+	}
+	if src.downloads != 1 {
+		t.Fatalf("expected the bundle to be downloaded once, got %d downloads", src.downloads)
+	}
+	for _, path := range []string{partPath, partStatePath(partPath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat error: %v", path, err)
+		}
+	}
+}
+
+func TestDownloadToPartKeepsFileOnAdvisoryChecksumMismatch(t *testing.T) {
+	checksum, err := sources.NewChecksum(sources.MD5, "00000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("failed to build checksum: %v", err)
+	}
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`, advisory: checksum}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	result, err := tq.downloadToPart(context.Background(), task, partPath, newTestBar(t))
+	if err != nil {
+		t.Fatalf("expected an advisory mismatch not to fail the download, got %v", err)
+	}
+	if result.Size != 10 {
+		t.Fatalf("expected 10 bytes written, got %d", result.Size)
+	}
+	if data, err := os.ReadFile(partPath); err != nil || string(data) != "0123456789" {
+		t.Fatalf("expected the part file to be kept, got %q: %v", data, err)
+	}
+}
+
+func TestDownloadToPartKeepsResumablePartOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &rangeStubSource{content: "0123456789", etag: `"v1"`, failAfter: 4, interrupt: cancel}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	_, err := tq.downloadToPart(ctx, task, partPath, newTestBar(t))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(src.ranges) != 1 {
+		t.Fatalf("expected no retry after cancellation, got ranges %+v", src.ranges)
+	}
+	if got := resumeRange(task, partPath); got.Offset != 4 {
+		t.Fatalf("expected part to be resumable from byte 4, got %+v", got)
+	}
+}
+
+func TestDownloadToPartRemovesUnresumablePartOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &rangeStubSource{content: "0123456789", failAfter: 4, interrupt: cancel}
+	task := VersionTask{Source: src, Version: sources.Version{PackageName: "com.example", Code: 1}}
+	partPath := filepath.Join(t.TempDir(), "app.apk"+partFileSuffix)
+
+	tq := &TaskQueue{}
+	if _, err := tq.downloadToPart(ctx, task, partPath, newTestBar(t)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	for _, path := range []string{partPath, partStatePath(partPath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat error: %v", path, err)
+		}
+	}
+}
+
+type failingDownloadSource struct {
+	sources.BaseSource
+	name string
+}
+
+func (s *failingDownloadSource) Name() string { return s.name }
+
+func (s *failingDownloadSource) Download(context.Context, sources.Version) (*sources.DownloadStream, error) {
+	return nil, errors.New("checkin failed: 403")
+}
+
+func TestDownloadWithFallbackTriesNextSource(t *testing.T) {
+	prevOutputDir := outputDir
+	defer func() {
+		outputDir = prevOutputDir
+	}()
+	outputDir = t.TempDir()
+
+	version := sources.Version{PackageName: "com.example", Name: "1.0", Code: 1, Type: sources.APK}
+	failing := sourcedVersion{Version: version, Source: &failingDownloadSource{name: "apkcombo"}}
+	working := sourcedVersion{Version: version, Source: &rangeStubSource{content: "apk"}}
+	progress := mpb.New(mpb.WithOutput(io.Discard))
+	t.Cleanup(progress.Shutdown)
+	tq := &TaskQueue{progress: progress}
+
+	var record reportRecord
+	outcome := tq.downloadWithFallback(context.Background(), failing, []sourcedVersion{working}, nil, &record)
+	if outcome.Status != reportStatusDownloaded {
+		t.Fatalf("expected download from the fallback source, got %+v", outcome)
+	}
+	if record.Source != "rangestub" {
+		t.Fatalf("expected record to name the fallback source, got %q", record.Source)
+	}
+	if len(record.Fallbacks) != 1 || record.Fallbacks[0].Source != "apkcombo" || !strings.Contains(record.Fallbacks[0].Error, "403") {
+		t.Fatalf("unexpected fallback chain: %+v", record.Fallbacks)
+	}
+	data, err := os.ReadFile(outcome.OutputPath)
+	if err != nil || string(data) != "apk" {
+		t.Fatalf("unexpected downloaded file %q: %v", data, err)
+	}
+}
+
+func TestDownloadWithFallbackReportsOnlyFinalFailure(t *testing.T) {
+	prevOutputDir := outputDir
+	prevErrors := downloadErrorCount.Load()
+	defer func() {
+		outputDir = prevOutputDir
+		downloadErrorCount.Store(prevErrors)
+	}()
+	outputDir = t.TempDir()
+	downloadErrorCount.Store(0)
+
+	version := sources.Version{PackageName: "com.example", Name: "1.0", Code: 1, Type: sources.APK}
+	failing := sourcedVersion{Version: version, Source: &failingDownloadSource{name: "apkcombo"}}
+	progress := mpb.New(mpb.WithOutput(io.Discard))
+	t.Cleanup(progress.Shutdown)
+	tq := &TaskQueue{progress: progress}
+
+	working := sourcedVersion{Version: version, Source: &rangeStubSource{content: "apk"}}
+	outcome := tq.downloadWithFallback(context.Background(), failing, []sourcedVersion{working}, nil, &reportRecord{})
+	if outcome.Status != reportStatusDownloaded {
+		t.Fatalf("expected download from the fallback source, got %+v", outcome)
+	}
+	if got := downloadErrorCount.Load(); got != 0 {
+		t.Fatalf("expected a recovered failure not to be counted, got %d errors", got)
+	}
+
+	version.Code = 2
+	failing.Version = version
+	otherFailing := sourcedVersion{Version: version, Source: &failingDownloadSource{name: "apkpure"}}
+	outcome = tq.downloadWithFallback(context.Background(), failing, []sourcedVersion{otherFailing}, nil, &reportRecord{})
+	if outcome.Status != reportStatusError {
+		t.Fatalf("expected the exhausted chain to fail, got %+v", outcome)
+	}
+	if got := downloadErrorCount.Load(); got != 1 {
+		t.Fatalf("expected the exhausted chain to be counted once, got %d errors", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Process exit codes. They are part of the CLI contract and documented in
+// the README.
+const (
+	exitCodeOK          = 0
+	exitCodeUsage       = 1
+	exitCodePartial     = 2
+	exitCodeFailed      = 3
+	exitCodeInterrupted = 130
+)
+
+// exitError carries an exit code through cobra's RunE. err is nil when the
+// outcome has already been reported by the summary line.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCodeFor maps an error returned by the root command to an exit code.
+// Errors without an explicit code are configuration or usage errors.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitCodeOK
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitCodeUsage
+}
+
+// runExitCode derives the exit code from the package outcomes of a run.
+// Packages skipped because the file already exists, because the state file
+// records them, or, in sync, because the mirror is up to date count as
+// successful.
+func runExitCode() int {
+	failed := packageFailedCount.Load()
+	succeeded := packageDownloadedCount.Load() + packageSkippedCount.Load()
+	switch {
+	case failed == 0:
+		return exitCodeOK
+	case succeeded == 0:
+		return exitCodeFailed
+	default:
+		return exitCodePartial
+	}
+}
+
+func runSummaryLine() string {
+	return fmt.Sprintf("Summary: %d downloaded, %d skipped, %d failed",
+		packageDownloadedCount.Load(),
+		packageSkippedCount.Load(),
+		packageFailedCount.Load(),
+	)
+}
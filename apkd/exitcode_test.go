@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func setPackageOutcomeCounts(t *testing.T, downloaded, skipped, failed int64) {
+	t.Helper()
+	prevDownloaded, prevSkipped, prevFailed := packageDownloadedCount.Load(), packageSkippedCount.Load(), packageFailedCount.Load()
+	t.Cleanup(func() {
+		packageDownloadedCount.Store(prevDownloaded)
+		packageSkippedCount.Store(prevSkipped)
+		packageFailedCount.Store(prevFailed)
+	})
+	packageDownloadedCount.Store(downloaded)
+	packageSkippedCount.Store(skipped)
+	packageFailedCount.Store(failed)
+}
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		name                        string
+		downloaded, skipped, failed int64
+		want                        int
+	}{
+		{"all downloaded", 3, 0, 0, exitCodeOK},
+		{"skipped counts as success", 1, 2, 0, exitCodeOK},
+		{"partial failure", 1, 0, 2, exitCodePartial},
+		{"skipped and failed", 0, 1, 1, exitCodePartial},
+		{"all failed", 0, 0, 3, exitCodeFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPackageOutcomeCounts(t, tt.downloaded, tt.skipped, tt.failed)
+			if got := runExitCode(); got != tt.want {
+				t.Fatalf("runExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSummaryLine(t *testing.T) {
+	setPackageOutcomeCounts(t, 2, 1, 3)
+	if got := runSummaryLine(); got != "Summary: 2 downloaded, 1 skipped, 3 failed" {
+		t.Fatalf("unexpected summary line %q", got)
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	if got := exitCodeFor(nil); got != exitCodeOK {
+		t.Fatalf("expected %d for nil error, got %d", exitCodeOK, got)
+	}
+	if got := exitCodeFor(errors.New("bad flag")); got != exitCodeUsage {
+		t.Fatalf("expected usage exit code, got %d", got)
+	}
+	wrapped := fmt.Errorf("run: %w", &exitError{code: exitCodePartial})
+	if got := exitCodeFor(wrapped); got != exitCodePartial {
+		t.Fatalf("expected partial exit code, got %d", got)
+	}
+}
+
+func TestPreRunReturnsUsageErrorInsteadOfExiting(t *testing.T) {
+	state := snapshotMainState()
+	defer restoreMainState(state)
+
+	configFile = writeTestConfig(t, "version: 2\n")
+	workers = 0
+	err := rootCmd.PreRunE(newConfigApplyCommand(t, "--workers", "0"), nil)
+	if err == nil || !strings.Contains(err.Error(), "--workers must be > 0") {
+		t.Fatalf("expected workers validation error, got %v", err)
+	}
+	if got := exitCodeFor(err); got != exitCodeUsage {
+		t.Fatalf("expected usage exit code, got %d", got)
+	}
+}
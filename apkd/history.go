@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/spf13/cobra"
+)
+
+var historyOutputJSON bool
+
+var historyCmd = cobra.Command{
+	Use:           "history",
+	Short:         "Show the downloads recorded in the state file",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		resetRunState()
+		if _, _, err := prepareRun(cmd); err != nil {
+			return err
+		}
+		for _, packageSpec := range packageNames {
+			packageName, selector, err := parsePackageSpec(packageSpec)
+			if err != nil {
+				return fmt.Errorf("error parsing package %s: %w", packageSpec, err)
+			}
+			packageNamesMap[packageName] = selector
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := resolveStatePath()
+		if err != nil {
+			return fmt.Errorf("error resolving state file path: %w", err)
+		}
+		store, err := openStateStore(path)
+		if err != nil {
+			return err
+		}
+		var records []stateRecord
+		for _, record := range store.Records("") {
+			if len(packageNamesMap) > 0 {
+				selector, listed := packageNamesMap[record.Package]
+				if !listed || !selector.matches(sources.Version{Code: record.VersionCode, Name: record.VersionName}) {
+					continue
+				}
+			}
+			records = append(records, record)
+		}
+		if historyOutputJSON {
+			return writeHistoryJSON(os.Stdout, records)
+		}
+		return writeHistoryTable(os.Stdout, records)
+	},
+}
+
+func writeHistoryJSON(w io.Writer, records []stateRecord) error {
+	if records == nil {
+		records = []stateRecord{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+func writeHistoryTable(w io.Writer, records []stateRecord) error {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No downloads recorded")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOWNLOADED\tPACKAGE\tVERSION\tCODE\tSOURCE\tSIZE\tPATH")
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			record.DownloadedAt.Local().Format(time.DateTime), record.Package, record.VersionName,
+			record.VersionCode, record.Source, record.Size, record.Path)
+	}
+	return tw.Flush()
+}
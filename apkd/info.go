@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var infoOutputJSON bool
+
+var infoCmd = cobra.Command{
+	Use:           "info",
+	Short:         "Show what every source offers for a package without downloading it",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		resetRunState()
+		sourceProxies, resolvedCfg, err := prepareRun(cmd)
+		if err != nil {
+			return err
+		}
+		if err := parsePackageNames(); err != nil {
+			return err
+		}
+		return selectActiveSources(sourceProxies, resolvedCfg)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := notifyInterrupt(cmd.Context())
+		defer stop()
+
+		packages := make([]string, 0, len(packageNamesMap))
+		for packageName := range packageNamesMap {
+			packages = append(packages, packageName)
+		}
+		sort.Strings(packages)
+
+		var tq TaskQueue
+		var infos []packageInfo
+		var found, missing int
+		for _, packageName := range packages {
+			selector := packageNamesMap[packageName]
+			search := tq.findVersion(ctx, packageName, selector)
+			if ctx.Err() != nil {
+				return &exitError{code: exitCodeInterrupted}
+			}
+			info := newPackageInfo(packageName, selector, sourceSelection, search)
+			if len(search.Matches) == 0 {
+				missing++
+			} else {
+				found++
+			}
+			infos = append(infos, info)
+		}
+
+		var err error
+		if infoOutputJSON {
+			err = writeInfoJSON(os.Stdout, infos)
+		} else {
+			err = writeInfoTable(os.Stdout, infos)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing package info: %w", err)
+		}
+		switch {
+		case missing == 0:
+			return nil
+		case found == 0:
+			return &exitError{code: exitCodeFailed}
+		default:
+			return &exitError{code: exitCodePartial}
+		}
+	},
+}
+
+// packageInfo is the resolution of one package against the active sources.
+type packageInfo struct {
+	Package          string              `json:"package"`
+	RequestedVersion string              `json:"requested_version"`
+	Hits             []packageInfoHit    `json:"hits"`
+	SourceErrors     []reportSourceError `json:"source_errors,omitempty"`
+}
+
+// packageInfoHit is a version found at one source. Reason explains why it
+// would or would not be downloaded.
+type packageInfoHit struct {
+	Source      string `json:"source"`
+	Name        string `json:"name"`
+	Code        int    `json:"code"`
+	Size        uint64 `json:"size"`
+	Type        string `json:"type"`
+	DeveloperId string `json:"developer_id,omitempty"`
+	Selected    bool   `json:"selected"`
+	Reason      string `json:"reason"`
+}
+
+func newPackageInfo(packageName string, selector versionSelector, strategy selectionStrategy, search versionSearch) packageInfo {
+	info := packageInfo{
+		Package:          packageName,
+		RequestedVersion: selector.String(),
+		Hits:             make([]packageInfoHit, 0, len(search.Hits)),
+		SourceErrors:     newReportSourceErrors(search.Errors),
+	}
+	for _, hit := range search.Hits {
+		selected, reason := selectionReason(selector, strategy, hit, search.Matches)
+		info.Hits = append(info.Hits, packageInfoHit{
+			Source:      hit.Source.Name(),
+			Name:        hit.Version.Name,
+			Code:        hit.Version.Code,
+			Size:        hit.Version.Size,
+			Type:        string(hit.Version.Type),
+			DeveloperId: hit.Version.DeveloperId,
+			Selected:    selected,
+			Reason:      reason,
+		})
+	}
+	sort.SliceStable(info.Hits, func(i, j int) bool {
+		if info.Hits[i].Code != info.Hits[j].Code {
+			return info.Hits[i].Code > info.Hits[j].Code
+		}
+		return info.Hits[i].Selected && !info.Hits[j].Selected
+	})
+	return info
+}
+
+// selectionReason reports whether hit is one of the versions the selector and
+// strategy picked and explains the decision.
+func selectionReason(selector versionSelector, strategy selectionStrategy, hit sourcedVersion, matches []sourcedVersion) (bool, string) {
+	for _, match := range matches {
+		if match.Version.Code != hit.Version.Code {
+			continue
+		}
+		if match.Source.Name() != hit.Source.Name() {
+			return false, fmt.Sprintf("same version code is taken from %s", match.Source.Name())
+		}
+		switch selector.kind {
+		case selectLatest:
+			return true, strategy.describe()
+		case selectExact:
+			return true, "requested version code"
+		case selectAll:
+			return true, "all versions requested"
+		default:
+			return true, "matches " + selector.String()
+		}
+	}
+	if !selector.matches(hit.Version) {
+		return false, "does not match " + selector.String()
+	}
+	if selector.kind == selectLatestBack {
+		return false, "not " + selector.String()
+	}
+	if len(matches) > 0 {
+		if strategy.Name == strategyPriorityOrder {
+			return false, fmt.Sprintf("%s comes first in priority order", matches[0].Source.Name())
+		}
+		return false, fmt.Sprintf("older than version code %d", matches[0].Version.Code)
+	}
+	return false, "not selected"
+}
+
+func writeInfoJSON(w io.Writer, infos []packageInfo) error {
+	if infos == nil {
+		infos = []packageInfo{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(infos)
+}
+
+func writeInfoTable(w io.Writer, infos []packageInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tSOURCE\tVERSION\tCODE\tSIZE\tTYPE\tDEVELOPER\tSELECTED\tREASON")
+	for _, info := range infos {
+		if len(info.Hits) == 0 {
+			reason := "not found in active sources"
+			if len(info.SourceErrors) > 0 {
+				reason = "all sources failed"
+			}
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\tno\t%s\n", info.Package, reason)
+			continue
+		}
+		for _, hit := range info.Hits {
+			size := "-"
+			if hit.Size > 0 {
+				size = strconv.FormatUint(hit.Size, 10)
+			}
+			developer := hit.DeveloperId
+			if developer == "" {
+				developer = "-"
+			}
+			selected := "no"
+			if hit.Selected {
+				selected = "yes"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+				info.Package, hit.Source, hit.Name, hit.Code, size, hit.Type, developer, selected, hit.Reason)
+		}
+	}
+	return tw.Flush()
+}
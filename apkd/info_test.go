@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func TestNewPackageInfoExplainsSelection(t *testing.T) {
+	fdroid := &findStubSource{name: "fdroid"}
+	rustore := &findStubSource{name: "rustore"}
+	apkcombo := &findStubSource{name: "apkcombo"}
+	hits := []sourcedVersion{
+		{Version: sources.Version{Name: "1.0", Code: 1, Type: sources.APK}, Source: fdroid},
+		{Version: sources.Version{Name: "2.0", Code: 2, Type: sources.APK, DeveloperId: "Acme"}, Source: rustore},
+		{Version: sources.Version{Name: "2.0", Code: 2, Type: sources.XAPK}, Source: apkcombo},
+	}
+	strategy := selectionStrategy{Name: strategyHighestVersion, Priority: []string{"rustore"}}
+	search := versionSearch{Hits: hits, Matches: versionSelector{}.selectVersions(hits, strategy)}
+
+	info := newPackageInfo("com.example", versionSelector{}, strategy, search)
+	if len(info.Hits) != 3 {
+		t.Fatalf("expected every source hit, got %+v", info.Hits)
+	}
+	selected := info.Hits[0]
+	if !selected.Selected || selected.Source != "rustore" || selected.Reason != "highest version code" || selected.DeveloperId != "Acme" {
+		t.Fatalf("unexpected selected hit: %+v", selected)
+	}
+	if info.Hits[1].Selected || !strings.Contains(info.Hits[1].Reason, "rustore") {
+		t.Fatalf("unexpected duplicate hit: %+v", info.Hits[1])
+	}
+	if info.Hits[2].Selected || info.Hits[2].Reason != "older than version code 2" {
+		t.Fatalf("unexpected older hit: %+v", info.Hits[2])
+	}
+}
+
+func TestWriteInfoJSONAndTable(t *testing.T) {
+	infos := []packageInfo{
+		{Package: "com.example", RequestedVersion: "latest", Hits: []packageInfoHit{{Source: "fdroid", Name: "1.0", Code: 1, Type: "apk", Selected: true, Reason: "highest version code"}}},
+		{Package: "com.missing", RequestedVersion: "latest", Hits: []packageInfoHit{}},
+	}
+
+	var jsonOut bytes.Buffer
+	if err := writeInfoJSON(&jsonOut, infos); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded []packageInfo
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if len(decoded) != 2 || len(decoded[0].Hits) != 1 || !decoded[0].Hits[0].Selected {
+		t.Fatalf("unexpected decoded info: %+v", decoded)
+	}
+
+	var tableOut bytes.Buffer
+	if err := writeInfoTable(&tableOut, infos); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(tableOut.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "highest version code") || !strings.Contains(lines[2], "not found") {
+		t.Fatalf("unexpected table output:\n%s", tableOut.String())
+	}
+}
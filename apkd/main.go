@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -8,12 +9,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/kiber-io/apkd/apkd/devices"
 	"github.com/kiber-io/apkd/apkd/logging"
 	"github.com/kiber-io/apkd/apkd/network"
 	"github.com/kiber-io/apkd/apkd/sources"
@@ -21,7 +22,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var packageNamesMap = make(map[string]int)
+var packageNamesMap = make(map[string]versionSelector)
 var forceDownload bool
 var batchDeveloperDownloadMode bool
 var outputDir string
@@ -37,6 +38,15 @@ var listSources bool
 var printVersion bool
 var workers int
 var onlyApk bool
+var reportPath string
+var reportFormat string
+var statePath string
+var skipKnown bool
+var deviceName string
+var deviceABI string
+var deviceSDK int
+var deviceDPI int
+var deviceLocales []string
 
 var selectedSources []string
 var activeSources []sources.Source
@@ -44,6 +54,10 @@ var activeSources []sources.Source
 var downloadSuccessCount atomic.Int64
 var downloadErrorCount atomic.Int64
 
+var packageDownloadedCount atomic.Int64
+var packageSkippedCount atomic.Int64
+var packageFailedCount atomic.Int64
+
 var sanitizeFileNameRe = regexp.MustCompile(`[<>:"/\\|?*]+`)
 
 var (
@@ -53,182 +67,59 @@ var (
 )
 
 var rootCmd = cobra.Command{
-	Use:   "apkd",
-	Short: "apkd is a tool for downloading APKs from multiple sources",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		// Reset mutable global state to keep repeated in-process runs deterministic.
-		packageNamesMap = make(map[string]int)
-		activeSources = nil
-		downloadSuccessCount.Store(0)
-		downloadErrorCount.Store(0)
+	Use:           "apkd",
+	Short:         "apkd is a tool for downloading APKs from multiple sources",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		resetRunState()
 		if printVersion {
-			return
-		}
-		resolvedCfg, configOverrideLogs, err := applyConfig(cmd)
-		if err != nil {
-			fmt.Printf("Error loading config: %v\n", err)
-			os.Exit(1)
-		}
-		if workers <= 0 {
-			fmt.Println("Error validating workers: --workers must be > 0")
-			os.Exit(1)
+			return nil
 		}
-		if verbosity == 0 {
-			verbosity = *builtInDefaultConfig.Defaults.Verbose
-		}
-		logging.Init(verbosity)
-		if resolvedCfg.path != "" {
-			logging.Logd("Loaded config: " + resolvedCfg.path)
-		}
-		for _, overrideLog := range configOverrideLogs {
-			logging.Logd(overrideLog)
-		}
-		network.ResetClientDefaults()
-		if err := network.ConfigureClientDefaults(resolvedCfg.clientTimeout, resolvedCfg.retryPolicy); err != nil {
-			fmt.Printf("Error applying network settings: %v\n", err)
-			os.Exit(1)
-		}
-
-		sourceProxies, err := parseSourceProxyEntries(sourceProxyEntries)
+		sourceProxies, resolvedCfg, err := prepareRun(cmd)
 		if err != nil {
-			fmt.Printf("Error parsing --source-proxy: %v\n", err)
-			os.Exit(1)
+			return err
 		}
-		if err := network.ConfigureProxies(globalProxy, sourceProxies, proxyInsecureSkipVerify); err != nil {
-			fmt.Printf("Error applying proxy settings: %v\n", err)
-			os.Exit(1)
-		}
-		sources.ConfigureSourceConfigs(resolvedCfg.sourceConfigs)
-
-		if err := sources.InitializeRegisteredSources(); err != nil {
-			fmt.Printf("Error initializing sources: %v\n", err)
-			os.Exit(1)
-		}
-
 		if listSources {
-			return
-		}
-
-		if packagesFile != "" {
-			file, err := os.Open(packagesFile)
-			if err != nil {
-				fmt.Printf("Error opening file %s: %v\n", packagesFile, err)
-				os.Exit(1)
-			}
-			defer file.Close()
-
-			var packageName string
-			for {
-				_, err := fmt.Fscanf(file, "%s\n", &packageName)
-				if err != nil {
-					break
-				}
-				// support comments
-				if strings.HasPrefix(packageName, "#") {
-					continue
-				}
-				packageNames = append(packageNames, packageName)
-			}
-		}
-
-		for _, pkgName := range packageNames {
-			var versionCode int
-			if strings.Contains(pkgName, ":") {
-				parts := strings.Split(pkgName, ":")
-				pkgName = parts[0]
-				var err error
-				versionCode, err = strconv.Atoi(parts[1])
-				if err != nil {
-					fmt.Printf("Error parsing version code for package %s: %v\n", pkgName, err)
-					os.Exit(1)
-				}
-			}
-			packageNamesMap[pkgName] = versionCode
-		}
-
-		if len(packageNamesMap) == 0 {
-			fmt.Println("No package names provided. Use --package or --file to specify package names.")
-			os.Exit(1)
-		}
-
-		for i, src := range selectedSources {
-			selectedSources[i] = strings.ToLower(src)
-		}
-		allSources := sources.GetAll()
-		if err := validateKnownSources(selectedSources, sourceProxies, resolvedCfg.configuredSourceNames, allSources); err != nil {
-			fmt.Printf("Error validating source names: %v\n", err)
-			os.Exit(1)
+			return nil
 		}
-		if len(selectedSources) > 0 {
-			selectedSourcesSet := make(map[string]struct{}, len(selectedSources))
-			for _, src := range selectedSources {
-				selectedSourcesSet[src] = struct{}{}
-			}
-			for src := range allSources {
-				if _, exists := selectedSourcesSet[src]; exists {
-					activeSources = append(activeSources, allSources[src])
-				}
-			}
-		} else {
-			for src := range allSources {
-				activeSources = append(activeSources, allSources[src])
-			}
+		if err := parsePackageNames(); err != nil {
+			return err
 		}
-		if len(activeSources) == 0 {
-			fmt.Println("No sources available. Please check your sources.")
-			os.Exit(1)
+		if err := selectActiveSources(sourceProxies, resolvedCfg); err != nil {
+			return err
 		}
-		if outputDir != "" {
-			var err, warn error
-			outputDir, err, warn = sanitizedAndAbsoluteName(outputDir)
-			if err != nil {
-				fmt.Printf("Error getting absolute path for output directory %s: %v\n", outputDir, err)
-				os.Exit(1)
-			}
-			if warn != nil {
-				fmt.Println("Warning:", warn)
-			}
-			info, err := os.Stat(outputDir)
-			if os.IsNotExist(err) {
-				err = os.MkdirAll(outputDir, 0o750)
-				if err != nil {
-					fmt.Printf("Error creating output directory %s: %v\n", outputDir, err)
-					os.Exit(1)
-				}
-			} else if err != nil {
-				fmt.Printf("Error checking output directory %s: %v\n", outputDir, err)
-				os.Exit(1)
-			} else if !info.IsDir() {
-				fmt.Printf("Output path %s is not a directory\n", outputDir)
-				os.Exit(1)
-			}
+		if err := prepareOutputDir(); err != nil {
+			return err
 		}
 		if outputFileName != "" {
 			if len(packageNamesMap) > 1 {
-				fmt.Println("Output file name is not supported when downloading multiple packages.")
-				os.Exit(1)
+				return errors.New("output file name is not supported when downloading multiple packages")
+			}
+			for _, selector := range packageNamesMap {
+				if selector.kind == selectAll {
+					return errors.New("output file name is not supported when downloading all versions")
+				}
 			}
 			var err, warn error
 			outputFileName, err, warn = sanitizedAndAbsoluteName(outputFileName)
 			if err != nil {
-				fmt.Printf("Error getting absolute path for output file %s: %v\n", outputFileName, err)
-				os.Exit(1)
+				return fmt.Errorf("error getting absolute path for output file %s: %w", outputFileName, err)
 			}
 			if warn != nil {
 				fmt.Println("Warning:", warn)
 			}
 			if _, err := os.Stat(outputFileName); err == nil {
 				if !forceDownload {
-					fmt.Printf("Output file %s already exists. Use --force to overwrite.\n", outputFileName)
-					os.Exit(1)
+					return fmt.Errorf("output file %s already exists. Use --force to overwrite", outputFileName)
 				}
 			} else if !os.IsNotExist(err) {
-				fmt.Printf("Error checking output file %s: %v\n", outputFileName, err)
-				os.Exit(1)
+				return fmt.Errorf("error checking output file %s: %w", outputFileName, err)
 			}
 		}
+		return nil
 	},
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if printVersion {
 			fmt.Printf("Version: %s\nCommit: %s\nBuilt at: %s\n", version, commit, buildDate)
 		} else if listSources {
@@ -238,26 +129,279 @@ var rootCmd = cobra.Command{
 				fmt.Printf("- %s\n", src.Name())
 			}
 		} else {
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-			go func() {
-				<-sigChan
-				os.Exit(0)
-			}()
-
-			tq := NewTaskQueue(workers)
-			for packageName, versionCode := range packageNamesMap {
-				tq.AddTask(PackageTask{
+			ctx, stop := notifyInterrupt(cmd.Context())
+			defer stop()
+
+			tasks := make([]PackageTask, 0, len(packageNamesMap))
+			for packageName, selector := range packageNamesMap {
+				tasks = append(tasks, PackageTask{
 					PackageName: packageName,
-					VersionCode: versionCode,
+					Selector:    selector,
 				})
 			}
-
-			tq.Wait()
+			if err := runPackageTasks(ctx, tasks); err != nil {
+				return err
+			}
+			return finishRun(ctx)
 		}
+		return nil
 	},
 }
 
+// notifyInterrupt returns a context that is cancelled on the first SIGINT or
+// SIGTERM so running downloads can stop cleanly. A second signal exits
+// immediately. The returned stop function releases the signal handler.
+func notifyInterrupt(parent context.Context) (context.Context, func()) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+		case <-done:
+			return
+		}
+		fmt.Println()
+		fmt.Println("Interrupted, stopping downloads (press Ctrl-C again to force exit)")
+		cancel()
+		select {
+		case <-sigChan:
+			fmt.Println()
+			fmt.Println("Interrupted. " + runSummaryLine())
+			os.Exit(exitCodeInterrupted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigChan)
+		close(done)
+		cancel()
+	}
+}
+
+// prepareOutputDir makes outputDir absolute and creates it when missing.
+func prepareOutputDir() error {
+	if outputDir == "" {
+		return nil
+	}
+	var err, warn error
+	outputDir, err, warn = sanitizedAndAbsoluteName(outputDir)
+	if err != nil {
+		return fmt.Errorf("error getting absolute path for output directory %s: %w", outputDir, err)
+	}
+	if warn != nil {
+		fmt.Println("Warning:", warn)
+	}
+	info, err := os.Stat(outputDir)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(outputDir, 0o750)
+		if err != nil {
+			return fmt.Errorf("error creating output directory %s: %w", outputDir, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error checking output directory %s: %w", outputDir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("output path %s is not a directory", outputDir)
+	}
+	return nil
+}
+
+// runPackageTasks runs the package tasks on a TaskQueue, writing the run
+// report when --report is set and recording downloads in the state file, and
+// returns once every task has finished or ctx was cancelled.
+func runPackageTasks(ctx context.Context, tasks []PackageTask) error {
+	path, err := resolveStatePath()
+	if err != nil {
+		return fmt.Errorf("error resolving state file path: %w", err)
+	}
+	runState, err = openStateStore(path)
+	if err != nil {
+		return err
+	}
+	if reportPath != "" {
+		runReport, err = newReportWriter(reportPath, reportFormat)
+		if err != nil {
+			return fmt.Errorf("error creating report: %w", err)
+		}
+		defer func() {
+			if err := runReport.Close(); err != nil {
+				fmt.Println("Warning:", err)
+			}
+		}()
+	}
+
+	tq := NewTaskQueue(ctx, workers)
+	for _, task := range tasks {
+		tq.AddTask(task)
+	}
+	tq.Wait()
+	return nil
+}
+
+// finishRun prints the summary line and returns the exit error for the run
+// outcome, or nil when every package succeeded.
+func finishRun(ctx context.Context) error {
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted. " + runSummaryLine())
+		return &exitError{code: exitCodeInterrupted}
+	}
+	fmt.Println(runSummaryLine())
+	if code := runExitCode(); code != exitCodeOK {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// resetRunState resets mutable global state to keep repeated in-process runs
+// deterministic.
+func resetRunState() {
+	packageNamesMap = make(map[string]versionSelector)
+	packagePins = make(map[string][]string)
+	sourceSelection = selectionStrategy{Name: defaultSelectionStrategyName}
+	downloadFallback = defaultFallbackPolicy
+	activeSources = nil
+	runReport = nil
+	runState = nil
+	downloadSuccessCount.Store(0)
+	downloadErrorCount.Store(0)
+	packageDownloadedCount.Store(0)
+	packageSkippedCount.Store(0)
+	packageFailedCount.Store(0)
+}
+
+// prepareRun applies the config file and flags, then configures logging, the
+// network layer and the registered sources. It returns the parsed
+// --source-proxy mapping for selectActiveSources.
+func prepareRun(cmd *cobra.Command) (map[string]string, *resolvedConfig, error) {
+	resolvedCfg, configOverrideLogs, err := applyConfig(cmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading config: %w", err)
+	}
+	if workers <= 0 {
+		return nil, nil, errors.New("error validating workers: --workers must be > 0")
+	}
+	if err := validateReportFormat(reportFormat); err != nil {
+		return nil, nil, fmt.Errorf("error validating --report-format: %w", err)
+	}
+	if verbosity == 0 {
+		verbosity = *builtInDefaultConfig.Defaults.Verbose
+	}
+	logging.Init(verbosity)
+	if resolvedCfg.path != "" {
+		logging.Logd("Loaded config: " + resolvedCfg.path)
+	}
+	for _, overrideLog := range configOverrideLogs {
+		logging.Logd(overrideLog)
+	}
+	network.ResetClientDefaults()
+	if err := network.ConfigureClientDefaults(resolvedCfg.clientTimeout, resolvedCfg.retryPolicy); err != nil {
+		return nil, nil, fmt.Errorf("error applying network settings: %w", err)
+	}
+
+	sourceProxies, err := parseSourceProxyEntries(sourceProxyEntries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing --source-proxy: %w", err)
+	}
+	if err := network.ConfigureProxies(globalProxy, sourceProxies, proxyInsecureSkipVerify); err != nil {
+		return nil, nil, fmt.Errorf("error applying proxy settings: %w", err)
+	}
+	device, err := devices.Resolve(devices.Profile{
+		Device:  deviceName,
+		ABI:     deviceABI,
+		SDK:     deviceSDK,
+		DPI:     deviceDPI,
+		Locales: deviceLocales,
+	}, resolvedCfg.devices)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error selecting device profile: %w", err)
+	}
+	logging.Logd(fmt.Sprintf("Using device: %s %s (SDK %d, %d dpi, %s)", device.Brand, device.Model, device.SDKInt, device.DPI, strings.Join(device.CPUAbis, ", ")))
+	sources.ConfigureDevice(device)
+	sessionDir, err := sources.DefaultSessionDir()
+	if err != nil {
+		logging.Logw(fmt.Sprintf("Sessions are kept in memory only: %v", err))
+	}
+	sources.ConfigureSessionDir(sessionDir)
+	sources.ConfigureSourceConfigs(resolvedCfg.sourceConfigs)
+
+	if err := sources.InitializeRegisteredSources(); err != nil {
+		return nil, nil, fmt.Errorf("error initializing sources: %w", err)
+	}
+	return sourceProxies, resolvedCfg, nil
+}
+
+// parsePackageNames fills packageNamesMap from --package and --file.
+func parsePackageNames() error {
+	if packagesFile != "" {
+		filePackages, err := readPackageList(packagesFile)
+		if err != nil {
+			return err
+		}
+		packageNames = append(packageNames, filePackages...)
+	}
+
+	for _, packageSpec := range packageNames {
+		pkgName, selector, err := parsePackageSpec(packageSpec)
+		if err != nil {
+			return fmt.Errorf("error parsing package %s: %w", packageSpec, err)
+		}
+		packageNamesMap[pkgName] = selector
+	}
+
+	if len(packageNamesMap) == 0 {
+		return errors.New("no package names provided. Use --package or --file to specify package names")
+	}
+	return nil
+}
+
+// selectActiveSources fills activeSources from --source, or with every
+// registered source when none was selected.
+func selectActiveSources(sourceProxies map[string]string, resolvedCfg *resolvedConfig) error {
+	for i, src := range selectedSources {
+		selectedSources[i] = strings.ToLower(src)
+	}
+	allSources := sources.GetAll()
+	if err := validateKnownSources(selectedSources, sourceProxies, resolvedCfg.configuredSourceNames, allSources); err != nil {
+		return fmt.Errorf("error validating source names: %w", err)
+	}
+	for _, sourceName := range sourceSelection.Priority {
+		if _, exists := allSources[sourceName]; !exists {
+			return fmt.Errorf("error validating source names: unknown source name %s in config.selection.priority. Use --list-sources to see available sources", sourceName)
+		}
+	}
+	if len(selectedSources) > 0 {
+		selectedSourcesSet := make(map[string]struct{}, len(selectedSources))
+		for _, src := range selectedSources {
+			selectedSourcesSet[src] = struct{}{}
+		}
+		for src := range allSources {
+			if _, exists := selectedSourcesSet[src]; exists {
+				if err := sources.CheckConfig(allSources[src]); err != nil {
+					return fmt.Errorf("source %s is not configured: %w", src, err)
+				}
+				activeSources = append(activeSources, allSources[src])
+			}
+		}
+	} else {
+		for src := range allSources {
+			// Sources that need credentials are only used once configured.
+			if err := sources.CheckConfig(allSources[src]); err != nil {
+				logging.Logd(fmt.Sprintf("Skipping source %s: %v", src, err))
+				continue
+			}
+			activeSources = append(activeSources, allSources[src])
+		}
+	}
+	if len(activeSources) == 0 {
+		return errors.New("no sources available. Please check your sources")
+	}
+	return nil
+}
+
 func reportError(errText string) {
 	downloadErrorCount.Add(1)
 	logging.Loge(strings.ReplaceAll(errText, "\n", "\\n"))
@@ -267,18 +411,37 @@ func reportDownloadSuccess() {
 	downloadSuccessCount.Add(1)
 }
 
+// recordPackageOutcome counts the final status of a package task for the exit
+// code and summary line, and writes it to the run report.
+func recordPackageOutcome(record reportRecord) {
+	switch record.Status {
+	case reportStatusDownloaded:
+		packageDownloadedCount.Add(1)
+	case reportStatusSkippedExists, reportStatusUpToDate, reportStatusSkippedKnown:
+		packageSkippedCount.Add(1)
+	case reportStatusInterrupted:
+		// Interrupted packages are neither successes nor failures; the run
+		// exits with exitCodeInterrupted anyway.
+	default:
+		packageFailedCount.Add(1)
+	}
+	runReport.Write(record)
+}
+
 type resolvedConfig struct {
 	path                  string
 	sourceConfigs         map[string]any
 	configuredSourceNames map[string]struct{}
 	clientTimeout         *time.Duration
 	retryPolicy           *network.RetryPolice
+	devices               map[string]devices.Device
 }
 
 func applyConfig(cmd *cobra.Command) (*resolvedConfig, []string, error) {
 	resolved := &resolvedConfig{
 		sourceConfigs:         make(map[string]any),
 		configuredSourceNames: make(map[string]struct{}),
+		devices:               make(map[string]devices.Device),
 	}
 	configPath, err := resolveConfigPath(configFile)
 	if err != nil {
@@ -381,6 +544,67 @@ func applyConfig(cmd *cobra.Command) (*resolvedConfig, []string, error) {
 			onlyApk = *cfg.Defaults.OnlyApk
 		}
 	}
+	if len(cfg.Packages) > 0 {
+		if cmd.Flags().Changed("package") || cmd.Flags().Changed("file") {
+			recordOverride("CLI flags --package/--file override config value packages")
+		} else {
+			packageNames = append([]string(nil), cfg.Packages...)
+		}
+	}
+	if cfg.Selection.Strategy != nil {
+		sourceSelection.Name = *cfg.Selection.Strategy
+	}
+	sourceSelection.Priority = append([]string(nil), cfg.Selection.Priority...)
+	if cfg.Selection.Fallback != nil {
+		downloadFallback = *cfg.Selection.Fallback
+	}
+	for packageName, digests := range cfg.Pins {
+		addPackagePins(packageName, digests)
+	}
+	for repoName, repo := range cfg.Repos {
+		if err := sources.RegisterFDroidRepo(repoName, sources.FDroidRepo{
+			BaseURL:     repo.BaseURL,
+			Fingerprint: repo.Fingerprint,
+		}); err != nil {
+			return nil, nil, fmt.Errorf("invalid repos.%s: %w", repoName, err)
+		}
+	}
+	if cfg.Device.Name != nil {
+		if cmd.Flags().Changed("device") {
+			recordOverride("CLI flag --device overrides config value device.name")
+		} else {
+			deviceName = *cfg.Device.Name
+		}
+	}
+	if cfg.Device.ABI != nil {
+		if cmd.Flags().Changed("abi") {
+			recordOverride("CLI flag --abi overrides config value device.abi")
+		} else {
+			deviceABI = *cfg.Device.ABI
+		}
+	}
+	if cfg.Device.SDK != nil {
+		if cmd.Flags().Changed("sdk") {
+			recordOverride("CLI flag --sdk overrides config value device.sdk")
+		} else {
+			deviceSDK = *cfg.Device.SDK
+		}
+	}
+	if cfg.Device.DPI != nil {
+		if cmd.Flags().Changed("dpi") {
+			recordOverride("CLI flag --dpi overrides config value device.dpi")
+		} else {
+			deviceDPI = *cfg.Device.DPI
+		}
+	}
+	deviceLocales = append([]string(nil), cfg.Device.Locales...)
+	for name, definition := range cfg.Devices {
+		device, err := definition.device()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid devices.%s: %w", name, err)
+		}
+		resolved.devices[name] = device
+	}
 	for sourceName, sourceCfg := range cfg.Sources {
 		resolved.configuredSourceNames[sourceName] = struct{}{}
 		sourceConfig, err := sources.DecodeSourceConfig(sourceName, sourceCfg.Node)
@@ -406,6 +630,9 @@ func buildRetryPolicy(cfg ConfigRetry) (*network.RetryPolice, error) {
 	if cfg.MaxDelayMs != nil {
 		retryPolicy.MaxDelay = *cfg.MaxDelayMs
 	}
+	if cfg.MaxRetryAfterMs != nil {
+		retryPolicy.MaxRetryAfter = *cfg.MaxRetryAfterMs
+	}
 	if len(cfg.RetryStatus) > 0 {
 		retryPolicy.RetryStatus = append([]int(nil), cfg.RetryStatus...)
 	}
@@ -418,6 +645,9 @@ func buildRetryPolicy(cfg ConfigRetry) (*network.RetryPolice, error) {
 	if retryPolicy.MaxDelay < 0 {
 		return nil, errors.New("max_delay_ms must be >= 0")
 	}
+	if retryPolicy.MaxRetryAfter < 0 {
+		return nil, errors.New("max_retry_after_ms must be >= 0")
+	}
 	for _, retryStatusCode := range retryPolicy.RetryStatus {
 		if retryStatusCode < 100 || retryStatusCode > 599 {
 			return nil, fmt.Errorf("retry_status contains invalid HTTP status code %d", retryStatusCode)
@@ -529,11 +759,33 @@ func main() {
 	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "set verbosity level. Use -v or -vv for more verbosity")
 	rootCmd.PersistentFlags().BoolVarP(&listSources, "list-sources", "l", false, "list available sources")
 	rootCmd.PersistentFlags().BoolVarP(&printVersion, "version", "V", false, "print version and exit")
+	rootCmd.PersistentFlags().StringVar(&reportPath, "report", "", "write a machine-readable report of every package task to this file")
+	rootCmd.PersistentFlags().StringVar(&reportFormat, "report-format", reportFormatJSON, "report format: json or ndjson")
+	rootCmd.PersistentFlags().StringVar(&statePath, "state", "", "path to the state file of downloaded packages (defaults to "+stateFileName+" in the output directory, or the apkd data directory without one)")
+	rootCmd.PersistentFlags().BoolVar(&skipKnown, "skip-known", false, "skip versions the state file already records as downloaded; exact, range and name selectors are skipped without querying sources")
+	rootCmd.PersistentFlags().StringVar(&deviceName, "device", "", "device profile presented to the stores: random, a custom device from the config or one of "+strings.Join(devices.Names(), ", ")+" (default "+devices.DefaultDevice+")")
+	rootCmd.PersistentFlags().StringVar(&deviceABI, "abi", "", "primary ABI of the device profile (arm64-v8a, armeabi-v7a, x86_64, x86)")
+	rootCmd.PersistentFlags().IntVar(&deviceSDK, "sdk", 0, "Android SDK level of the device profile (21-36)")
+	rootCmd.PersistentFlags().IntVar(&deviceDPI, "dpi", 0, "screen density of the device profile in dpi (120-640)")
 	rootCmd.PersistentFlags().BoolVarP(&onlyApk, "only-apk", "", valueOrZero(builtInDefaultConfig.Defaults.OnlyApk), "download only APK files, skip other types (e.g. XAPK, APKs)")
 
+	versionsCmd.Flags().BoolVar(&versionsOutputJSON, "json", false, "print versions as JSON")
+	rootCmd.AddCommand(&versionsCmd)
+	infoCmd.Flags().BoolVar(&infoOutputJSON, "json", false, "print package info as JSON")
+	rootCmd.AddCommand(&infoCmd)
+	syncCmd.Flags().IntVar(&syncKeepVersions, "keep", 0, "number of versions to keep per package; older files are deleted (0 keeps all)")
+	rootCmd.AddCommand(&syncCmd)
+	historyCmd.Flags().BoolVar(&historyOutputJSON, "json", false, "print history as JSON")
+	rootCmd.AddCommand(&historyCmd)
+	sessionCmd.AddCommand(&sessionResetCmd)
+	rootCmd.AddCommand(&sessionCmd)
+
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		var exitErr *exitError
+		if !errors.As(err, &exitErr) || exitErr.err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(exitCodeFor(err))
 	}
 }
 
@@ -7,11 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +22,7 @@ import (
 	"time"
 
 	"github.com/kiber-io/apkd/apkd/logging"
+	"golang.org/x/net/publicsuffix"
 )
 
 var reqSeq uint64
@@ -30,6 +34,7 @@ var sourceProxyURLs = map[string]*url.URL{}
 var proxyInsecureSkipVerify bool
 var defaultClientTimeout = 30 * time.Second
 var defaultRetryPolicy = DefaultRetryPolice()
+var hostCooldowns = &cooldowns{until: map[string]time.Time{}}
 
 func nextRequestID() uint64 {
 	n := atomic.AddUint64(&reqSeq, 1)
@@ -66,8 +71,11 @@ type RetryPolice struct {
 	MaxAttempts int
 	Delay       int
 	MaxDelay    int
-	RetryStatus []int
-	RetryIf     RetryDecider
+	// MaxRetryAfter caps the wait requested by Retry-After and rate limit
+	// reset headers, in milliseconds. Zero caps it with MaxDelay.
+	MaxRetryAfter int
+	RetryStatus   []int
+	RetryIf       RetryDecider
 }
 
 func DefaultRetryPolice() *RetryPolice {
@@ -204,6 +212,9 @@ func ConfigureClientDefaults(timeout *time.Duration, retryPolicy *RetryPolice) e
 		if retryPolicy.MaxDelay < 0 {
 			return errors.New("retry max delay must be >= 0")
 		}
+		if retryPolicy.MaxRetryAfter < 0 {
+			return errors.New("retry max retry-after must be >= 0")
+		}
 		for _, retryStatusCode := range retryPolicy.RetryStatus {
 			if retryStatusCode < 100 || retryStatusCode > 599 {
 				return fmt.Errorf("invalid retry status code %d", retryStatusCode)
@@ -313,6 +324,31 @@ func (c *Client) DisableHTTP2() *Client {
 	return c
 }
 
+// NewCookieJar returns an in-memory cookie jar. The public suffix list keeps a
+// site from setting cookies for a whole public suffix such as co.uk.
+func NewCookieJar() *cookiejar.Jar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar
+}
+
+// WithCookieJar makes the client keep the cookies of responses in jar and send
+// them with later requests, retries and redirects. A nil jar disables
+// cookies.
+func (c *Client) WithCookieJar(jar http.CookieJar) *Client {
+	if base, ok := c.doer.(*http.Client); ok {
+		base.Jar = jar
+	}
+	return c
+}
+
+// CookieJar returns the jar of the client, or nil when cookies are disabled.
+func (c *Client) CookieJar() http.CookieJar {
+	if base, ok := c.doer.(*http.Client); ok {
+		return base.Jar
+	}
+	return nil
+}
+
 func (c *Client) DefaultHeaders() http.Header {
 	c.defaultMu.RLock()
 	defer c.defaultMu.RUnlock()
@@ -414,13 +450,42 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	// http.Client adds the cookies of its jar to the Cookie header of req, so
+	// the header is restored before every attempt: a retry sends the cookies
+	// the jar holds by then, once.
+	var requestCookies []string
+	usesCookieJar := false
+	if baseHTTPClient, ok := effectiveDoer.(*http.Client); ok && baseHTTPClient.Jar != nil {
+		usesCookieJar = true
+		requestCookies = append([]string(nil), req.Header.Values("Cookie")...)
+	}
+
 	for attempt := 1; attempt <= c.retry.MaxAttempts; attempt++ {
+		if wait := hostCooldowns.remaining(req.URL.Host); wait > 0 {
+			activeLogger.Logd(fmt.Sprintf("%s Host %s is rate limited, waiting %v...", logContext, req.URL.Host, wait))
+			if err := sleepContext(req.Context(), wait); err != nil {
+				return nil, fmt.Errorf("request context done: %w", err)
+			}
+		}
+		if usesCookieJar {
+			req.Header.Del("Cookie")
+			for _, cookie := range requestCookies {
+				req.Header.Add("Cookie", cookie)
+			}
+		}
 		resp, err := effectiveDoer.Do(req)
 		if err == nil {
 			activeLogger.Logd(fmt.Sprintf("%s Received response: %d %s", logContext, resp.StatusCode, http.StatusText(resp.StatusCode)))
 		} else {
 			activeLogger.Logd(fmt.Sprintf("%s Request error: %v", logContext, err))
 		}
+		// A rate limited host is cooled down for every request to it, not
+		// only for this one.
+		retryAfter, rateLimited := rateLimitDelay(resp, time.Now())
+		if rateLimited {
+			retryAfter = min(retryAfter, c.retry.retryAfterLimit())
+			hostCooldowns.extend(req.URL.Host, time.Now().Add(retryAfter))
+		}
 		if !shouldRetry(resp, err, attempt) {
 			if err != nil {
 				return nil, fmt.Errorf("request failed: %w", err)
@@ -445,13 +510,12 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 		}
 
 		delay := backoffWithJitter(c.retry.Delay, c.retry.MaxDelay, attempt)
+		if rateLimited {
+			delay = retryAfter
+		}
 		activeLogger.Logw(fmt.Sprintf("%s Attempt %d/%d failed with %s, retrying in %v...", logContext, attempt, c.retry.MaxAttempts, reason, delay))
-		timer := time.NewTimer(delay)
-		select {
-		case <-timer.C:
-		case <-req.Context().Done():
-			timer.Stop()
-			return nil, fmt.Errorf("request context done: %w", req.Context().Err())
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, fmt.Errorf("request context done: %w", err)
 		}
 	}
 
@@ -573,6 +637,90 @@ func backoffWithJitter(baseDelay, maxDelay, attempt int) time.Duration {
 	return time.Duration(rand.Intn(capped+1)) * time.Millisecond
 }
 
+func (p *RetryPolice) retryAfterLimit() time.Duration {
+	if p.MaxRetryAfter > 0 {
+		return time.Duration(p.MaxRetryAfter) * time.Millisecond
+	}
+	return time.Duration(p.MaxDelay) * time.Millisecond
+}
+
+// rateLimitDelay returns how long a 429 or 503 response asks the client to
+// wait: Retry-After in seconds or as an HTTP date, or else the reset time of
+// the rate limit window. X-RateLimit-Reset is sent either as seconds left or
+// as a Unix timestamp; values past 2001 are taken as timestamps.
+func rateLimitDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	if value := strings.TrimSpace(resp.Header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+			return secondsDuration(seconds), true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	for _, header := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		value := strings.TrimSpace(resp.Header.Get(header))
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds < 0 {
+			continue
+		}
+		if seconds >= 1_000_000_000 {
+			return max(time.Unix(seconds, 0).Sub(now), 0), true
+		}
+		return secondsDuration(seconds), true
+	}
+	return 0, false
+}
+
+func secondsDuration(seconds int64) time.Duration {
+	if seconds > int64(math.MaxInt64/time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// cooldowns holds, per host, the time until which requests to it wait.
+type cooldowns struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (c *cooldowns) extend(host string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until.After(c.until[host]) {
+		c.until[host] = until
+	}
+}
+
+func (c *cooldowns) remaining(host string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, exists := c.until[host]
+	if !exists {
+		return 0
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		delete(c.until, host)
+		return 0
+	}
+	return wait
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func ReadAndRestoreBody(resp *http.Response) ([]byte, error) {
 	if resp == nil || resp.Body == nil {
 		return nil, errors.New("response or response body is nil")
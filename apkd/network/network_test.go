@@ -2,11 +2,13 @@ package network
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
 	"testing"
@@ -652,3 +654,238 @@ func TestUpdateDoerDefaultHeadersUnsupported(t *testing.T) {
 		t.Fatalf("expected error for unsupported doer")
 	}
 }
+
+func cookieTestResponse(req *http.Request, status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
+
+func TestDoSendsJarCookiesOnceAcrossRetries(t *testing.T) {
+	var cookieHeaders []string
+	client := &Client{
+		doer: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			cookieHeaders = append(cookieHeaders, strings.Join(req.Header.Values("Cookie"), "; "))
+			switch len(cookieHeaders) {
+			case 1:
+				// A challenge page sets the clearance cookie on a failed attempt.
+				return cookieTestResponse(req, http.StatusServiceUnavailable, http.Header{"Set-Cookie": {"clearance=abc; Path=/"}}), nil
+			case 2:
+				return cookieTestResponse(req, http.StatusServiceUnavailable, http.Header{"Set-Cookie": {"clearance=def; Path=/"}}), nil
+			}
+			return cookieTestResponse(req, http.StatusOK, nil), nil
+		})},
+		retry: &RetryPolice{MaxAttempts: 3, RetryStatus: []int{http.StatusServiceUnavailable}},
+	}
+	client.WithCookieJar(NewCookieJar())
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/app", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	req.Header.Set("Cookie", "manual=1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	resp.Body.Close()
+
+	expected := []string{"manual=1", "manual=1; clearance=abc", "manual=1; clearance=def"}
+	if len(cookieHeaders) != len(expected) {
+		t.Fatalf("expected %d attempts, got %d", len(expected), len(cookieHeaders))
+	}
+	for i, header := range cookieHeaders {
+		if header != expected[i] {
+			t.Fatalf("attempt %d sent cookies %q, expected %q", i+1, header, expected[i])
+		}
+	}
+}
+
+func TestDoKeepsJarWithCheckRedirect(t *testing.T) {
+	var finalCookies string
+	redirects := 0
+	client := &Client{
+		doer: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/start" {
+				return cookieTestResponse(req, http.StatusFound, http.Header{
+					"Location":   {"https://example.com/final"},
+					"Set-Cookie": {"session=xyz; Path=/"},
+				}), nil
+			}
+			finalCookies = req.Header.Get("Cookie")
+			return cookieTestResponse(req, http.StatusOK, nil), nil
+		})},
+		retry: &RetryPolice{MaxAttempts: 1},
+	}
+	client.WithCookieJar(NewCookieJar())
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/start", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	req = WithCheckRedirect(req, func(req *http.Request, via []*http.Request) error {
+		redirects++
+		return nil
+	})
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	resp.Body.Close()
+	if redirects != 1 {
+		t.Fatalf("expected the redirect check to run once, got %d", redirects)
+	}
+	if finalCookies != "session=xyz" {
+		t.Fatalf("expected the redirected request to carry the cookie, got %q", finalCookies)
+	}
+	if cookies := client.CookieJar().Cookies(req.URL); len(cookies) != 1 || cookies[0].Value != "xyz" {
+		t.Fatalf("unexpected jar cookies: %v", cookies)
+	}
+}
+
+func TestCookieJarRejectsPublicSuffix(t *testing.T) {
+	jar := NewCookieJar()
+	site, _ := url.Parse("https://store.example.co.uk/")
+	jar.SetCookies(site, []*http.Cookie{{Name: "wide", Value: "1", Domain: "co.uk"}})
+	if other, _ := url.Parse("https://other.co.uk/"); len(jar.Cookies(other)) != 0 {
+		t.Fatalf("expected a cookie for a public suffix to be rejected")
+	}
+	if client := NewHttpClient(time.Second, nil); client.CookieJar() != nil {
+		t.Fatalf("expected cookies to be disabled by default")
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		expected time.Duration
+		ok       bool
+	}{
+		{"retry-after seconds", http.StatusTooManyRequests, http.Header{"Retry-After": {"120"}}, 2 * time.Minute, true},
+		{"retry-after date", http.StatusServiceUnavailable, http.Header{"Retry-After": {now.Add(30 * time.Second).Format(http.TimeFormat)}}, 30 * time.Second, true},
+		{"retry-after past date", http.StatusTooManyRequests, http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, true},
+		{"reset seconds", http.StatusTooManyRequests, http.Header{"X-Ratelimit-Reset": {"15"}}, 15 * time.Second, true},
+		{"reset timestamp", http.StatusTooManyRequests, http.Header{"X-Ratelimit-Reset": {strconv.FormatInt(now.Add(45*time.Second).Unix(), 10)}}, 45 * time.Second, true},
+		{"ietf reset", http.StatusTooManyRequests, http.Header{"Ratelimit-Reset": {"5"}}, 5 * time.Second, true},
+		{"retry-after wins", http.StatusTooManyRequests, http.Header{"Retry-After": {"3"}, "X-Ratelimit-Reset": {"60"}}, 3 * time.Second, true},
+		{"invalid retry-after falls back", http.StatusTooManyRequests, http.Header{"Retry-After": {"soon"}, "X-Ratelimit-Reset": {"60"}}, time.Minute, true},
+		{"no headers", http.StatusTooManyRequests, http.Header{}, 0, false},
+		{"not rate limited", http.StatusInternalServerError, http.Header{"Retry-After": {"120"}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := rateLimitDelay(&http.Response{StatusCode: tt.status, Header: tt.header}, now)
+			if ok != tt.ok || delay != tt.expected {
+				t.Fatalf("expected %v (%v), got %v (%v)", tt.expected, tt.ok, delay, ok)
+			}
+		})
+	}
+	if _, ok := rateLimitDelay(nil, now); ok {
+		t.Fatalf("expected no delay for nil response")
+	}
+}
+
+func TestDoWaitsForRetryAfterCappedAndSharesCooldown(t *testing.T) {
+	attempts := 0
+	var attemptTimes []time.Time
+	client := &Client{
+		doer: doFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			attemptTimes = append(attemptTimes, time.Now())
+			if attempts == 1 {
+				return cookieTestResponse(req, http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}), nil
+			}
+			return cookieTestResponse(req, http.StatusOK, nil), nil
+		}),
+		retry: &RetryPolice{
+			MaxAttempts:   2,
+			MaxDelay:      0,
+			MaxRetryAfter: 100,
+			RetryStatus:   []int{http.StatusTooManyRequests},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://retry-after.example.com/app", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	resp.Body.Close()
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if wait := attemptTimes[1].Sub(attemptTimes[0]); wait < 100*time.Millisecond || wait > 2*time.Second {
+		t.Fatalf("expected the retry after the capped 100ms, got %v", wait)
+	}
+
+	// A request started while the host cools down waits as well.
+	hostCooldowns.extend("shared.example.com", time.Now().Add(100*time.Millisecond))
+	other := &Client{
+		doer: doFunc(func(req *http.Request) (*http.Response, error) {
+			return cookieTestResponse(req, http.StatusOK, nil), nil
+		}),
+		retry: &RetryPolice{MaxAttempts: 1},
+	}
+	started := time.Now()
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "https://shared.example.com/app", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	resp, err = other.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	resp.Body.Close()
+	if wait := time.Since(started); wait < 90*time.Millisecond {
+		t.Fatalf("expected the request to wait for the host cooldown, waited %v", wait)
+	}
+}
+
+func TestDoCooldownRespectsContext(t *testing.T) {
+	hostCooldowns.extend("cancelled.example.com", time.Now().Add(time.Hour))
+	client := &Client{
+		doer: doFunc(func(req *http.Request) (*http.Response, error) {
+			t.Fatalf("unexpected request during cooldown")
+			return nil, nil
+		}),
+		retry: &RetryPolice{MaxAttempts: 1},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://cancelled.example.com/app", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestCooldownsOnlyExtend(t *testing.T) {
+	c := &cooldowns{until: map[string]time.Time{}}
+	c.extend("example.com", time.Now().Add(time.Hour))
+	c.extend("example.com", time.Now().Add(time.Second))
+	if wait := c.remaining("example.com"); wait < 59*time.Minute {
+		t.Fatalf("expected the longer cooldown to be kept, got %v", wait)
+	}
+	c.extend("expired.example.com", time.Now().Add(-time.Second))
+	if wait := c.remaining("expired.example.com"); wait != 0 {
+		t.Fatalf("expected no wait for an expired cooldown, got %v", wait)
+	}
+	if _, exists := c.until["expired.example.com"]; exists {
+		t.Fatalf("expected the expired cooldown to be removed")
+	}
+}
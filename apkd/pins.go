@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/apksig"
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+const packageListPinPrefix = "pin="
+
+// packagePins maps a package name to the SHA-256 digests of the certificates
+// its APK may be signed with. It is filled from the config pins section and
+// from pin= fields in the package list file.
+var packagePins = make(map[string][]string)
+
+// signerPinMismatchError is returned when a downloaded file is signed by a
+// certificate that is not pinned for its package.
+type signerPinMismatchError struct {
+	PackageName string
+	Actual      []string
+	Expected    []string
+}
+
+func (e *signerPinMismatchError) Error() string {
+	return fmt.Sprintf("package %s is signed by %s, expected one of %s",
+		e.PackageName, strings.Join(e.Actual, ", "), strings.Join(e.Expected, ", "))
+}
+
+func addPackagePins(packageName string, digests []string) {
+	pins := packagePins[packageName]
+	for _, digest := range digests {
+		if !slices.Contains(pins, digest) {
+			pins = append(pins, digest)
+		}
+	}
+	packagePins[packageName] = pins
+}
+
+// readPackageList reads a package list file. Each line holds a package spec
+// (see parsePackageSpec) followed by any number of pin=<sha256> fields. Empty
+// lines and lines starting with # are ignored.
+func readPackageList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var packages []string
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		packageSpec := fields[0]
+		packageName, _, err := parsePackageSpec(packageSpec)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			rawDigest, isPin := strings.CutPrefix(field, packageListPinPrefix)
+			if !isPin {
+				return nil, fmt.Errorf("%s:%d: unexpected field %q, expected %s<sha256>", path, lineNumber, field, packageListPinPrefix)
+			}
+			digest, err := apksig.NormalizeDigest(rawDigest)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			addPackagePins(packageName, []string{digest})
+		}
+		packages = append(packages, packageSpec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", path, err)
+	}
+	return packages, nil
+}
+
+// expectedSigners returns the pins for the version's package. Configured pins
+// take precedence over the signers published by the source.
+func expectedSigners(version sources.Version) []string {
+	if pins := packagePins[version.PackageName]; len(pins) > 0 {
+		return pins
+	}
+	return version.Signers
+}
+
+// verifySignerPins reads the signer certificates of the downloaded file and
+// checks that every one of them is pinned for its package. It returns the
+// SHA-256 digests of the signers. Packages without pins are not checked, and
+// for them a file without a readable signature is not an error.
+func verifySignerPins(task VersionTask, filePath string) ([]string, error) {
+	expected := expectedSigners(task.Version)
+	var signers apksig.Signers
+	var err error
+	if task.Version.Type == sources.APK {
+		signers, err = apksig.Read(filePath)
+	} else {
+		signers, err = apksig.ReadBundle(filePath, task.Version.PackageName)
+	}
+	if len(expected) == 0 {
+		logger.Logd("No signature pins for package " + task.Version.PackageName)
+		if err != nil {
+			logger.Logd(fmt.Sprintf("Failed to read signers of package %s: %v", task.Version.PackageName, err))
+			return nil, nil
+		}
+		return signers.SHA256, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signers of package %s: %w", task.Version.PackageName, err)
+	}
+	for _, digest := range signers.SHA256 {
+		if !slices.Contains(expected, digest) {
+			return nil, &signerPinMismatchError{
+				PackageName: task.Version.PackageName,
+				Actual:      signers.SHA256,
+				Expected:    expected,
+			}
+		}
+	}
+	logger.Logd(fmt.Sprintf("Package %s is signed by a pinned certificate (v%d)", task.Version.PackageName, signers.Scheme))
+	return signers.SHA256, nil
+}
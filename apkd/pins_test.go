@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+const testPin = "43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab"
+
+func resetPackagePins(t *testing.T) {
+	t.Helper()
+	packagePins = make(map[string][]string)
+	t.Cleanup(func() {
+		packagePins = make(map[string][]string)
+	})
+}
+
+func TestReadPackageListParsesPins(t *testing.T) {
+	resetPackagePins(t)
+	path := filepath.Join(t.TempDir(), "packages.txt")
+	content := "# comment\n\norg.example.app:12 pin=" + testPin + " # trailing\norg.example.other\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write package list: %v", err)
+	}
+
+	packages, err := readPackageList(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packages) != 2 || packages[0] != "org.example.app:12" || packages[1] != "org.example.other" {
+		t.Fatalf("unexpected packages: %v", packages)
+	}
+	if pins := packagePins["org.example.app"]; len(pins) != 1 || pins[0] != testPin {
+		t.Fatalf("unexpected pins: %v", packagePins)
+	}
+}
+
+func TestReadPackageListRejectsUnknownField(t *testing.T) {
+	resetPackagePins(t)
+	path := filepath.Join(t.TempDir(), "packages.txt")
+	if err := os.WriteFile(path, []byte("org.example.app extra\n"), 0o644); err != nil {
+		t.Fatalf("failed to write package list: %v", err)
+	}
+	if _, err := readPackageList(path); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestExpectedSignersPrefersConfiguredPins(t *testing.T) {
+	resetPackagePins(t)
+	version := sources.Version{PackageName: "org.example.app", Signers: []string{"source-pin"}}
+	if signers := expectedSigners(version); len(signers) != 1 || signers[0] != "source-pin" {
+		t.Fatalf("expected source signers as default pin, got %v", signers)
+	}
+	addPackagePins("org.example.app", []string{testPin, testPin})
+	if signers := expectedSigners(version); len(signers) != 1 || signers[0] != testPin {
+		t.Fatalf("expected configured pins to win, got %v", signers)
+	}
+}
+
+func TestVerifySignerPinsFailsForUnsignedApk(t *testing.T) {
+	resetPackagePins(t)
+	path := filepath.Join(t.TempDir(), "app.apk")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create apk: %v", err)
+	}
+	writer := zip.NewWriter(file)
+	if _, err := writer.Create("AndroidManifest.xml"); err != nil {
+		t.Fatalf("failed to add zip entry: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close apk: %v", err)
+	}
+	task := VersionTask{Version: sources.Version{PackageName: "org.example.app", Type: sources.APK}}
+
+	if _, err := verifySignerPins(task, path); err != nil {
+		t.Fatalf("expected unpinned package to pass, got %v", err)
+	}
+	addPackagePins("org.example.app", []string{testPin})
+	_, err = verifySignerPins(task, path)
+	if err == nil {
+		t.Fatal("expected pinned package without signature to fail")
+	}
+	var mismatchErr *signerPinMismatchError
+	if errors.As(err, &mismatchErr) {
+		t.Fatalf("expected read error rather than mismatch, got %v", err)
+	}
+}
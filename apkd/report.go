@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+const (
+	reportFormatJSON   = "json"
+	reportFormatNDJSON = "ndjson"
+)
+
+type reportStatus string
+
+const (
+	reportStatusDownloaded      reportStatus = "downloaded"
+	reportStatusSkippedExists   reportStatus = "skipped-exists"
+	reportStatusUpToDate        reportStatus = "up-to-date"
+	reportStatusSkippedKnown    reportStatus = "skipped-known"
+	reportStatusNotFound        reportStatus = "not-found"
+	reportStatusFilteredOnlyApk reportStatus = "filtered-only-apk"
+	reportStatusError           reportStatus = "error"
+	reportStatusInterrupted     reportStatus = "interrupted"
+)
+
+// reportRecord describes the outcome of one package task.
+type reportRecord struct {
+	Package              string              `json:"package"`
+	RequestedVersion     string              `json:"requested_version"`
+	RequestedVersionCode int                 `json:"requested_version_code"`
+	Source               string              `json:"source,omitempty"`
+	SourceErrors         []reportSourceError `json:"source_errors,omitempty"`
+	Fallbacks            []reportFallback    `json:"fallbacks,omitempty"`
+	Version              *reportVersion      `json:"version,omitempty"`
+	OutputPath           string              `json:"output_path,omitempty"`
+	Bytes                int64               `json:"bytes"`
+	DurationMs           int64               `json:"duration_ms"`
+	SHA256               string              `json:"sha256,omitempty"`
+	Status               reportStatus        `json:"status"`
+	Error                string              `json:"error,omitempty"`
+}
+
+type reportSourceError struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+}
+
+// reportFallback is a failed download attempt that was followed by a fallback
+// to another candidate.
+type reportFallback struct {
+	Source      string `json:"source"`
+	VersionCode int    `json:"version_code"`
+	Error       string `json:"error"`
+}
+
+type reportVersion struct {
+	Name        string   `json:"name"`
+	Code        int      `json:"code"`
+	Size        uint64   `json:"size"`
+	Type        string   `json:"type"`
+	Link        string   `json:"link,omitempty"`
+	DeveloperId string   `json:"developer_id,omitempty"`
+	Checksum    string   `json:"checksum,omitempty"`
+	Signers     []string `json:"signers,omitempty"`
+}
+
+func newReportVersion(version sources.Version) *reportVersion {
+	return &reportVersion{
+		Name:        version.Name,
+		Code:        version.Code,
+		Size:        version.Size,
+		Type:        string(version.Type),
+		Link:        version.Link,
+		DeveloperId: version.DeveloperId,
+		Checksum:    version.Checksum.String(),
+		Signers:     version.Signers,
+	}
+}
+
+func newReportSourceErrors(errs []sources.Error) []reportSourceError {
+	if len(errs) == 0 {
+		return nil
+	}
+	result := make([]reportSourceError, 0, len(errs))
+	for _, err := range errs {
+		result = append(result, reportSourceError{Source: err.SourceName, Error: err.Err.Error()})
+	}
+	return result
+}
+
+// reportWriter writes run report records as soon as they are produced, so an
+// aborted run still leaves a readable report. NDJSON records are appended one
+// per line; the JSON array is rewritten atomically after every record. A nil
+// reportWriter discards records.
+type reportWriter struct {
+	mu      sync.Mutex
+	path    string
+	format  string
+	file    *os.File
+	records []reportRecord
+}
+
+var runReport *reportWriter
+
+func validateReportFormat(format string) error {
+	switch format {
+	case reportFormatJSON, reportFormatNDJSON:
+		return nil
+	default:
+		return fmt.Errorf("unsupported report format %q, expected %s or %s", format, reportFormatJSON, reportFormatNDJSON)
+	}
+}
+
+func newReportWriter(path, format string) (*reportWriter, error) {
+	if err := validateReportFormat(format); err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for report %s: %w", path, err)
+	}
+	w := &reportWriter{path: absPath, format: format, records: []reportRecord{}}
+	if format == reportFormatNDJSON {
+		w.file, err = os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create report %s: %w", absPath, err)
+		}
+		return w, nil
+	}
+	if err := w.writeJSONLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *reportWriter) Write(record reportRecord) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if w.format == reportFormatNDJSON {
+		err = w.appendNDJSONLocked(record)
+	} else {
+		w.records = append(w.records, record)
+		err = w.writeJSONLocked()
+	}
+	if err != nil {
+		logger.Logw(fmt.Sprintf("Failed to write report record for %s: %v", record.Package, err))
+	}
+}
+
+func (w *reportWriter) Close() error {
+	if w == nil || w.file == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close report %s: %w", w.path, err)
+	}
+	w.file = nil
+	return nil
+}
+
+func (w *reportWriter) appendNDJSONLocked(record reportRecord) error {
+	if w.file == nil {
+		return fmt.Errorf("report %s is closed", w.path)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode report record: %w", err)
+	}
+	if _, err := w.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", w.path, err)
+	}
+	return nil
+}
+
+func (w *reportWriter) writeJSONLocked() error {
+	data, err := json.MarshalIndent(w.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	tmpPath := w.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("failed to store report %s: %w", w.path, err)
+	}
+	return nil
+}
+
+func durationMs(started time.Time) int64 {
+	return time.Since(started).Milliseconds()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func TestReportWriterNDJSONAppendsRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.ndjson")
+	w, err := newReportWriter(path, reportFormatNDJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.Write(reportRecord{Package: "com.example.one", Status: reportStatusDownloaded, Version: newReportVersion(sources.Version{Code: 3, Type: sources.APK})})
+	// The first record must be readable before the writer is closed.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var first reportRecord
+	if err := json.Unmarshal(data, &first); err != nil {
+		t.Fatalf("expected a complete record before close, got %q: %v", data, err)
+	}
+	if first.Version == nil || first.Version.Code != 3 || first.Version.Type != "apk" {
+		t.Fatalf("unexpected version in record: %+v", first.Version)
+	}
+	w.Write(reportRecord{Package: "com.example.two", Status: reportStatusNotFound})
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open report: %v", err)
+	}
+	defer file.Close()
+	var statuses []reportStatus
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record reportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", scanner.Text(), err)
+		}
+		statuses = append(statuses, record.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != reportStatusDownloaded || statuses[1] != reportStatusNotFound {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+}
+
+func TestReportWriterJSONIsValidAfterEveryRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	w, err := newReportWriter(path, reportFormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	readRecords := func() []reportRecord {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read report: %v", err)
+		}
+		var records []reportRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			t.Fatalf("invalid json report %q: %v", data, err)
+		}
+		return records
+	}
+	if records := readRecords(); len(records) != 0 {
+		t.Fatalf("expected empty report, got %v", records)
+	}
+	w.Write(reportRecord{
+		Package:      "com.example",
+		Status:       reportStatusError,
+		SourceErrors: newReportSourceErrors([]sources.Error{{SourceName: "fdroid", Err: os.ErrDeadlineExceeded}}),
+	})
+	records := readRecords()
+	if len(records) != 1 || len(records[0].SourceErrors) != 1 || records[0].SourceErrors[0].Source != "fdroid" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+}
+
+func TestReportWriterRejectsUnknownFormat(t *testing.T) {
+	if _, err := newReportWriter(filepath.Join(t.TempDir(), "report"), "xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestNilReportWriterDiscardsRecords(t *testing.T) {
+	var w *reportWriter
+	w.Write(reportRecord{Package: "com.example"})
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+type selectorKind int
+
+const (
+	selectLatest selectorKind = iota
+	selectExact
+	selectRange
+	selectLatestBack
+	selectName
+	selectAll
+)
+
+// versionSelector describes which versions of a package to download. The zero
+// value selects the latest version.
+type versionSelector struct {
+	kind selectorKind
+	// code is the version code for selectExact.
+	code int
+	// min and max bound the version code for selectRange. Both are inclusive
+	// and zero means unbounded.
+	min, max int
+	// back is the number of versions before the latest for selectLatestBack.
+	back int
+	// namePattern is a path.Match pattern for selectName.
+	namePattern string
+	raw         string
+}
+
+var selectorComparisonRe = regexp.MustCompile(`^(>=|<=|>|<|=)(\d+)`)
+
+// parsePackageSpec splits a package spec into the package name and its
+// version selector. Accepted forms are pkg, pkg:<code> and pkg@<selector>
+// where selector is one of latest, latest-N, all, name=<glob>, <code> or a
+// sequence of comparisons such as >=1000<2000.
+func parsePackageSpec(spec string) (string, versionSelector, error) {
+	spec = strings.TrimSpace(spec)
+	if packageName, rawCode, found := strings.Cut(spec, ":"); found {
+		code, err := strconv.Atoi(rawCode)
+		if err != nil || code <= 0 {
+			return "", versionSelector{}, fmt.Errorf("invalid version code %q for package %s", rawCode, packageName)
+		}
+		if packageName == "" {
+			return "", versionSelector{}, fmt.Errorf("empty package name in %q", spec)
+		}
+		return packageName, versionSelector{kind: selectExact, code: code, raw: rawCode}, nil
+	}
+	packageName, rawSelector, found := strings.Cut(spec, "@")
+	if packageName == "" {
+		return "", versionSelector{}, fmt.Errorf("empty package name in %q", spec)
+	}
+	if !found {
+		return packageName, versionSelector{}, nil
+	}
+	selector, err := parseVersionSelector(rawSelector)
+	if err != nil {
+		return "", versionSelector{}, fmt.Errorf("invalid version selector for package %s: %w", packageName, err)
+	}
+	return packageName, selector, nil
+}
+
+func parseVersionSelector(raw string) (versionSelector, error) {
+	selector := versionSelector{raw: raw}
+	switch {
+	case raw == "":
+		return versionSelector{}, errors.New("selector is empty")
+	case raw == "latest":
+		selector.kind = selectLatest
+	case raw == "all":
+		selector.kind = selectAll
+	case strings.HasPrefix(raw, "latest-"):
+		back, err := strconv.Atoi(strings.TrimPrefix(raw, "latest-"))
+		if err != nil || back < 0 {
+			return versionSelector{}, fmt.Errorf("%q: expected latest-<number>", raw)
+		}
+		selector.kind = selectLatestBack
+		selector.back = back
+	case strings.HasPrefix(raw, "name="):
+		pattern := strings.TrimPrefix(raw, "name=")
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return versionSelector{}, fmt.Errorf("%q: invalid version name pattern", raw)
+		}
+		selector.kind = selectName
+		selector.namePattern = pattern
+	default:
+		if code, err := strconv.Atoi(raw); err == nil && code > 0 {
+			selector.kind = selectExact
+			selector.code = code
+			return selector, nil
+		}
+		if err := parseCodeRange(raw, &selector); err != nil {
+			return versionSelector{}, err
+		}
+	}
+	return selector, nil
+}
+
+func parseCodeRange(raw string, selector *versionSelector) error {
+	selector.kind = selectRange
+	for rest := raw; rest != ""; {
+		match := selectorComparisonRe.FindStringSubmatch(rest)
+		if match == nil {
+			return fmt.Errorf("%q: unexpected %q, expected a comparison such as >=100", raw, rest)
+		}
+		rest = rest[len(match[0]):]
+		code, err := strconv.Atoi(match[2])
+		if err != nil {
+			return fmt.Errorf("%q: %w", raw, err)
+		}
+		if code <= 0 || (match[1] == "<" && code == 1) {
+			return fmt.Errorf("%q: range is empty", raw)
+		}
+		switch match[1] {
+		case ">=":
+			selector.min = max(selector.min, code)
+		case ">":
+			selector.min = max(selector.min, code+1)
+		case "<=":
+			selector.max = minBound(selector.max, code)
+		case "<":
+			selector.max = minBound(selector.max, code-1)
+		case "=":
+			selector.min = max(selector.min, code)
+			selector.max = minBound(selector.max, code)
+		}
+	}
+	if selector.max != 0 && selector.max < selector.min {
+		return fmt.Errorf("%q: range is empty", raw)
+	}
+	return nil
+}
+
+// minBound returns the tighter of two upper bounds where zero means unbounded.
+func minBound(current, bound int) int {
+	if current == 0 {
+		return bound
+	}
+	return min(current, bound)
+}
+
+func (s versionSelector) String() string {
+	if s.raw == "" {
+		return "latest"
+	}
+	return s.raw
+}
+
+// exactCode returns the version code a selector pins, or 0 when it does not
+// pin a single code.
+func (s versionSelector) exactCode() int {
+	if s.kind == selectExact {
+		return s.code
+	}
+	return 0
+}
+
+// needsListing reports whether the selector can only be resolved against the
+// full version lists of the sources. Latest and exact selectors are answered
+// by Source.FindByPackage directly.
+func (s versionSelector) needsListing() bool {
+	return s.kind != selectLatest && s.kind != selectExact
+}
+
+// answeredByRecord reports whether any recorded download that satisfies the
+// selector answers it, so --skip-known can skip the package before querying
+// sources. Latest, latest-N and all depend on what the sources offer now.
+func (s versionSelector) answeredByRecord() bool {
+	switch s.kind {
+	case selectExact, selectRange, selectName:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s versionSelector) matches(version sources.Version) bool {
+	switch s.kind {
+	case selectExact:
+		return version.Code == s.code
+	case selectRange:
+		return version.Code >= s.min && (s.max == 0 || version.Code <= s.max)
+	case selectName:
+		matched, _ := path.Match(s.namePattern, version.Name)
+		return matched
+	default:
+		return true
+	}
+}
+
+// sourcedVersion is a version together with the source that offers it.
+type sourcedVersion struct {
+	Version sources.Version
+	Source  sources.Source
+}
+
+// selectVersions picks the versions to download from everything the sources
+// offer. The strategy decides which source each version is taken from and,
+// for selectors that ask for one version, which version wins. Every selected
+// version code appears once; the result of @all and latest-N is ordered
+// newest first.
+func (s versionSelector) selectVersions(candidates []sourcedVersion, strategy selectionStrategy) []sourcedVersion {
+	var matching []sourcedVersion
+	for _, candidate := range candidates {
+		if s.matches(candidate.Version) {
+			matching = append(matching, candidate)
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+	ordered := strategy.order(matching)
+	byCode := make(map[int]sourcedVersion)
+	var codes []int
+	for _, candidate := range ordered {
+		if _, exists := byCode[candidate.Version.Code]; exists {
+			continue
+		}
+		byCode[candidate.Version.Code] = candidate
+		codes = append(codes, candidate.Version.Code)
+	}
+	switch s.kind {
+	case selectAll, selectLatestBack:
+		slices.SortFunc(codes, func(a, b int) int {
+			return cmp.Compare(b, a)
+		})
+		if s.kind == selectLatestBack {
+			if s.back >= len(codes) {
+				return nil
+			}
+			codes = codes[s.back : s.back+1]
+		}
+	default:
+		codes = codes[:1]
+	}
+	selected := make([]sourcedVersion, 0, len(codes))
+	for _, code := range codes {
+		selected = append(selected, byCode[code])
+	}
+	return selected
+}
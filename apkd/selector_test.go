@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		name     string
+		expected versionSelector
+	}{
+		{spec: "com.example", name: "com.example", expected: versionSelector{}},
+		{spec: "com.example:123", name: "com.example", expected: versionSelector{kind: selectExact, code: 123, raw: "123"}},
+		{spec: "com.example@123", name: "com.example", expected: versionSelector{kind: selectExact, code: 123, raw: "123"}},
+		{spec: "com.example@latest", name: "com.example", expected: versionSelector{kind: selectLatest, raw: "latest"}},
+		{spec: "com.example@latest-3", name: "com.example", expected: versionSelector{kind: selectLatestBack, back: 3, raw: "latest-3"}},
+		{spec: "com.example@all", name: "com.example", expected: versionSelector{kind: selectAll, raw: "all"}},
+		{spec: "com.example@name=2.4.*", name: "com.example", expected: versionSelector{kind: selectName, namePattern: "2.4.*", raw: "name=2.4.*"}},
+		{spec: "com.example@>=1000<2000", name: "com.example", expected: versionSelector{kind: selectRange, min: 1000, max: 1999, raw: ">=1000<2000"}},
+		{spec: "com.example@>10<=20", name: "com.example", expected: versionSelector{kind: selectRange, min: 11, max: 20, raw: ">10<=20"}},
+	}
+	for _, tt := range tests {
+		name, selector, err := parsePackageSpec(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.spec, err)
+		}
+		if name != tt.name || selector != tt.expected {
+			t.Fatalf("%s: got %q %+v, expected %q %+v", tt.spec, name, selector, tt.name, tt.expected)
+		}
+	}
+}
+
+func TestParsePackageSpecInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"com.example:abc",
+		"com.example@",
+		"com.example@latest-x",
+		"com.example@name=[",
+		"com.example@>=2000<1000",
+		"com.example@~1.0",
+		"@all",
+	} {
+		if _, _, err := parsePackageSpec(spec); err == nil {
+			t.Fatalf("%s: expected parse error", spec)
+		}
+	}
+}
+
+func TestVersionSelectorSelectVersions(t *testing.T) {
+	first := &findStubSource{name: "first"}
+	second := &findStubSource{name: "second"}
+	candidates := []sourcedVersion{
+		{Version: sources.Version{Name: "2.4.1", Code: 241}, Source: first},
+		{Version: sources.Version{Name: "2.5.0", Code: 250}, Source: first},
+		{Version: sources.Version{Name: "2.4.0", Code: 240}, Source: first},
+		{Version: sources.Version{Name: "2.5.0", Code: 250}, Source: second},
+		{Version: sources.Version{Name: "2.3.0", Code: 230}, Source: second},
+	}
+	codes := func(selected []sourcedVersion) []int {
+		var result []int
+		for _, version := range selected {
+			result = append(result, version.Version.Code)
+		}
+		return result
+	}
+	tests := []struct {
+		spec     string
+		expected []int
+	}{
+		{spec: "pkg", expected: []int{250}},
+		{spec: "pkg@all", expected: []int{250, 241, 240, 230}},
+		{spec: "pkg@latest-2", expected: []int{240}},
+		{spec: "pkg@latest-4", expected: nil},
+		{spec: "pkg@name=2.4.*", expected: []int{241}},
+		{spec: "pkg@>=231<250", expected: []int{241}},
+	}
+	for _, tt := range tests {
+		_, selector, err := parsePackageSpec(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.spec, err)
+		}
+		got := codes(selector.selectVersions(candidates, sourceSelection))
+		if len(got) != len(tt.expected) {
+			t.Fatalf("%s: got %v, expected %v", tt.spec, got, tt.expected)
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Fatalf("%s: got %v, expected %v", tt.spec, got, tt.expected)
+			}
+		}
+	}
+
+	all := versionSelector{kind: selectAll}.selectVersions(candidates, sourceSelection)
+	if all[0].Source != first {
+		t.Fatalf("expected duplicate version to come from the first source, got %s", all[0].Source.Name())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/spf13/cobra"
+)
+
+var sessionCmd = cobra.Command{
+	Use:   "session",
+	Short: "Manage the state sources keep between runs",
+}
+
+var sessionResetCmd = cobra.Command{
+	Use:           "reset [source]",
+	Short:         "Delete the saved session of a source, or of every source",
+	Args:          cobra.MaximumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := sources.DefaultSessionDir()
+		if err != nil {
+			return fmt.Errorf("error resolving session directory: %w", err)
+		}
+		sourceName := ""
+		if len(args) > 0 {
+			sourceName = args[0]
+		}
+		return resetSessions(os.Stdout, dir, sourceName)
+	},
+}
+
+func resetSessions(w io.Writer, dir string, sourceName string) error {
+	removed, err := sources.ResetSessions(dir, sourceName)
+	for _, name := range removed {
+		fmt.Fprintln(w, "Deleted session of "+name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		if sourceName != "" {
+			fmt.Fprintln(w, "No saved session of "+sourceName)
+		} else {
+			fmt.Fprintln(w, "No saved sessions")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResetSessions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rustore.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatalf("failed to write session: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := resetSessions(&out, dir, "rustore"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "Deleted session of rustore" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rustore.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected the session file to be deleted, got %v", err)
+	}
+
+	out.Reset()
+	if err := resetSessions(&out, dir, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "No saved sessions" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -34,41 +35,6 @@ type ApkComboConfig struct {
 	BaseSourceConfig `yaml:",inline"`
 }
 
-func (s *ApkCombo) fetchDocument(url string) (*goquery.Document, *neturl.URL, error) {
-	req, err := s.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, nil, err
-	}
-	res, err := s.Http().Do(req)
-	if err != nil {
-		return nil, nil, fmt.Errorf("request failed: %w", err)
-	}
-	reader, err := unpackResponse(res)
-	if err != nil {
-		_ = res.Body.Close()
-		return nil, nil, err
-	}
-	defer func() {
-		_ = reader.Close()
-		if reader != res.Body {
-			_ = res.Body.Close()
-		}
-	}()
-	if res.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("error: %s", res.Status)
-	}
-	doc, err := goquery.NewDocumentFromReader(reader)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse HTML: %w", err)
-	}
-	var resolvedURL *neturl.URL
-	if res.Request != nil && res.Request.URL != nil {
-		resolvedURL = new(neturl.URL)
-		*resolvedURL = *res.Request.URL
-	}
-	return doc, resolvedURL, nil
-}
-
 func parseVersionCodeText(rawText string) (int, error) {
 	versionCodeText := strings.TrimSpace(rawText)
 	if strings.HasPrefix(versionCodeText, "(") && strings.HasSuffix(versionCodeText, ")") && len(versionCodeText) >= 2 {
@@ -115,13 +81,17 @@ func (s *ApkCombo) Name() string {
 	return "apkcombo"
 }
 
-func (s *ApkCombo) Download(version Version) (*DownloadStream, error) {
-	checkin, err := s.checkin(version.Link)
+func (s *ApkCombo) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *ApkCombo) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	checkin, err := s.checkin(ctx, version.Link)
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform checkin: %w", err)
 	}
 	downloadLink := version.Link + "&" + checkin
-	req, err := s.NewRequest("GET", downloadLink, nil)
+	req, err := s.NewRequest(ctx, "GET", downloadLink, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +100,7 @@ func (s *ApkCombo) Download(version Version) (*DownloadStream, error) {
 		req.Header.Del("Referer")
 		return nil
 	})
-	return createResponseReader(s.Http(), req)
+	return createRangeResponseReader(s.Http(), req, byteRange)
 }
 
 func (s *ApkCombo) parseVersionItem(e *goquery.Selection) (apkComboVersionItem, error) {
@@ -178,8 +148,8 @@ func (s *ApkCombo) parseVersionItem(e *goquery.Selection) (apkComboVersionItem,
 	}, nil
 }
 
-func (s *ApkCombo) resolveVersionCode(versionUrl string) (apkComboVersionItem, error) {
-	doc, _, err := s.fetchDocument(versionUrl)
+func (s *ApkCombo) resolveVersionCode(ctx context.Context, versionUrl string) (apkComboVersionItem, error) {
+	doc, _, err := s.fetchDocument(ctx, versionUrl)
 	if err != nil {
 		return apkComboVersionItem{}, err
 	}
@@ -197,17 +167,27 @@ func (s *ApkCombo) resolveVersionCode(versionUrl string) (apkComboVersionItem, e
 	return versionCandidate, nil
 }
 
-func sleepWithJitter(base time.Duration, maxJitter time.Duration) {
+func sleepWithJitter(ctx context.Context, base time.Duration, maxJitter time.Duration) error {
 	jitter := time.Duration(rand.Int63n(int64(maxJitter)))
-	time.Sleep(base + jitter)
+	timer := time.NewTimer(base + jitter)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
-func (s *ApkCombo) tryToFindOldVersion(link string, versionCode int) (apkComboVersionItem, error) {
-	doc, _, err := s.fetchDocument(link)
+// walkOldVersions resolves the entries of the paginated old-versions listing
+// starting at link and passes each one to visit until visit returns false.
+func (s *ApkCombo) walkOldVersions(ctx context.Context, link string, visit func(apkComboVersionItem) bool) error {
+	doc, _, err := s.fetchDocument(ctx, link)
 	if err != nil {
-		return apkComboVersionItem{}, err
+		return err
 	}
-	var foundVersion apkComboVersionItem
+	var stopped bool
+	var sleepErr error
 	doc.Find(".ver-item").EachWithBreak(func(i int, e *goquery.Selection) bool {
 		versionLink, exists := e.Attr("href")
 		if !exists {
@@ -218,49 +198,73 @@ func (s *ApkCombo) tryToFindOldVersion(link string, versionCode int) (apkComboVe
 			s.Log().Logw("Version item has empty href attribute")
 			return true
 		}
-		versionLink, err = neturl.JoinPath(s.config.BaseURL, versionLink)
+		versionLink, err := neturl.JoinPath(s.config.BaseURL, versionLink)
 		if err != nil {
 			s.Log().Logw(fmt.Sprintf("Failed to join version link path: %v", err))
 			return true
 		}
-		sleepWithJitter(200*time.Millisecond, 200*time.Millisecond)
-		versionItem, err := s.resolveVersionCode(versionLink)
+		if sleepErr = sleepWithJitter(ctx, 200*time.Millisecond, 200*time.Millisecond); sleepErr != nil {
+			return false
+		}
+		versionItem, err := s.resolveVersionCode(ctx, versionLink)
 		if err != nil {
 			s.Log().Logw(fmt.Sprintf("Failed to parse version item: %v", err))
 			return true
 		}
+		if !visit(versionItem) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	if sleepErr != nil {
+		return sleepErr
+	}
+	if stopped {
+		return nil
+	}
+	lastBtn := doc.Find(".pagination .buttons .button:last-child")
+	if _, disabled := lastBtn.Attr("disabled"); disabled || lastBtn.Length() == 0 {
+		return nil
+	}
+	href, exists := lastBtn.Attr("href")
+	if !exists {
+		s.Log().Logw("Last pagination button missing href attribute")
+		return nil
+	}
+	base, _ := neturl.Parse(s.config.BaseURL)
+	ref, err := neturl.Parse(href)
+	if err != nil {
+		s.Log().Logw(fmt.Sprintf("Failed to parse next page URL: %v", err))
+		return nil
+	}
+	if err := sleepWithJitter(ctx, 200*time.Millisecond, 200*time.Millisecond); err != nil {
+		return err
+	}
+	return s.walkOldVersions(ctx, base.ResolveReference(ref).String(), visit)
+}
+
+func (s *ApkCombo) tryToFindOldVersion(ctx context.Context, link string, versionCode int) (apkComboVersionItem, error) {
+	var foundVersion apkComboVersionItem
+	err := s.walkOldVersions(ctx, link, func(versionItem apkComboVersionItem) bool {
 		if versionItem.VersionCode != versionCode {
 			return true
 		}
 		foundVersion = versionItem
 		return false
 	})
+	if err != nil {
+		return apkComboVersionItem{}, err
+	}
 	if foundVersion.VersionCode == 0 {
-		lastBtn := doc.Find(".pagination .buttons .button:last-child")
-		_, disabled := lastBtn.Attr("disabled")
-		if !disabled {
-			href, exists := lastBtn.Attr("href")
-			if !exists {
-				s.Log().Logw("Last pagination button missing href attribute")
-			} else {
-				base, _ := neturl.Parse(s.config.BaseURL)
-				ref, err := neturl.Parse(href)
-				if err != nil {
-					s.Log().Logw(fmt.Sprintf("Failed to parse next page URL: %v", err))
-				} else {
-					sleepWithJitter(200*time.Millisecond, 200*time.Millisecond)
-					return s.tryToFindOldVersion(base.ResolveReference(ref).String(), versionCode)
-				}
-			}
-		}
 		return apkComboVersionItem{}, fmt.Errorf("version code %d not found", versionCode)
 	}
 	return foundVersion, nil
 }
 
-func (s *ApkCombo) checkin(referer string) (string, error) {
+func (s *ApkCombo) checkin(ctx context.Context, referer string) (string, error) {
 	url := fmt.Sprintf("%s/checkin", s.config.BaseURL)
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -290,21 +294,20 @@ func (s *ApkCombo) checkin(referer string) (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (s *ApkCombo) FindByPackage(packageName string, versionCode int) (Version, error) {
-	var version Version
-
+// findPackagePage searches for the package and returns the URL of its app
+// page together with the developer name shown there.
+func (s *ApkCombo) findPackagePage(ctx context.Context, packageName string) (string, string, error) {
 	packageURL := fmt.Sprintf("%s/search/?q=%s", s.config.BaseURL, packageName)
-	doc, resolvedPackageURL, err := s.fetchDocument(packageURL)
+	doc, resolvedPackageURL, err := s.fetchDocument(ctx, packageURL)
 	if err != nil {
-		return version, err
+		return "", "", err
 	}
 	if resolvedPackageURL == nil {
-		return version, errors.New("failed to resolve package URL")
+		return "", "", errors.New("failed to resolve package URL")
 	}
-	packagePageUrl := resolvedPackageURL.String()
 
 	if doc.Find(".app_header").Length() == 0 {
-		return version, &AppNotFoundError{PackageName: packageName}
+		return "", "", &AppNotFoundError{PackageName: packageName}
 	}
 
 	var authorName string
@@ -314,6 +317,16 @@ func (s *ApkCombo) FindByPackage(packageName string, versionCode int) (Version,
 	} else {
 		authorName = strings.TrimSpace(authorBlock.Text())
 	}
+	return resolvedPackageURL.String(), authorName, nil
+}
+
+func (s *ApkCombo) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
+	var version Version
+
+	packagePageUrl, authorName, err := s.findPackagePage(ctx, packageName)
+	if err != nil {
+		return version, err
+	}
 	version.DeveloperId = authorName
 
 	var versionItem apkComboVersionItem
@@ -322,7 +335,7 @@ func (s *ApkCombo) FindByPackage(packageName string, versionCode int) (Version,
 		if err != nil {
 			return version, err
 		}
-		versionItem, err = s.tryToFindOldVersion(oldVersionUrl, versionCode)
+		versionItem, err = s.tryToFindOldVersion(ctx, oldVersionUrl, versionCode)
 		if err != nil {
 			return version, err
 		}
@@ -331,7 +344,7 @@ func (s *ApkCombo) FindByPackage(packageName string, versionCode int) (Version,
 		if err != nil {
 			return version, err
 		}
-		versionItem, err = s.resolveVersionCode(downloadPageUrl)
+		versionItem, err = s.resolveVersionCode(ctx, downloadPageUrl)
 		if err != nil {
 			return version, err
 		}
@@ -352,11 +365,50 @@ func (s *ApkCombo) FindByPackage(packageName string, versionCode int) (Version,
 	return version, nil
 }
 
-func (s *ApkCombo) FindByDeveloper(developerId string) ([]string, error) {
+// ListVersions walks the old-versions pages, resolving every listed release.
+// Each entry costs a request, so this is considerably slower than
+// FindByPackage.
+func (s *ApkCombo) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	packagePageUrl, authorName, err := s.findPackagePage(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	oldVersionUrl, err := neturl.JoinPath(packagePageUrl, "old-versions")
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	seen := make(map[string]struct{})
+	err = s.walkOldVersions(ctx, oldVersionUrl, func(versionItem apkComboVersionItem) bool {
+		key := fmt.Sprintf("%d/%s", versionItem.VersionCode, versionItem.Type)
+		if _, exists := seen[key]; exists {
+			return true
+		}
+		seen[key] = struct{}{}
+		versions = append(versions, Version{
+			Name:        versionItem.VersionName,
+			Code:        versionItem.VersionCode,
+			Link:        versionItem.Link,
+			Type:        versionItem.Type,
+			PackageName: packageName,
+			DeveloperId: authorName,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, &AppNotFoundError{PackageName: packageName}
+	}
+	return versions, nil
+}
+
+func (s *ApkCombo) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
 	var packages []string
 
 	url := fmt.Sprintf("%s/developer/%s", s.config.BaseURL, developerId)
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return packages, err
 	}
@@ -430,7 +482,9 @@ func newApkComboSource() (Source, error) {
 		"priority":                  {"u=0, i"},
 		"te":                        {"trailers"},
 	}, config.Headers)
-	s.Net = network.DefaultClientForSource(s.Name()).WithDefaultHeaders(headers)
+	// The download link is only valid for the session checkin started, so
+	// ApkCombo always keeps cookies.
+	s.Net = network.DefaultClientForSource(s.Name()).WithDefaultHeaders(headers).WithCookieJar(network.NewCookieJar())
 	return s, nil
 }
 
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"os"
@@ -97,7 +98,7 @@ func TestApkComboCheckinUsesBaseURL(t *testing.T) {
 		capturedURL = req.URL.String()
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("token=abc")), Request: req}, nil
 	})
-	if _, err := s.checkin("https://referer.example"); err != nil {
+	if _, err := s.checkin(context.Background(), "https://referer.example"); err != nil {
 		t.Fatalf("unexpected checkin error: %v", err)
 	}
 	if capturedURL != customBase+"/checkin" {
@@ -117,7 +118,7 @@ func TestApkComboIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create source: %v", err)
 	}
-	v, err := src.FindByPackage("org.fdroid.fdroid", 0)
+	v, err := src.FindByPackage(context.Background(), "org.fdroid.fdroid", 0)
 	if err != nil {
 		t.Fatalf("FindByPackage: %v", err)
 	}
@@ -126,7 +127,7 @@ func TestApkComboIntegration(t *testing.T) {
 	}
 	t.Logf("version: %s (%d)", v.Name, v.Code)
 
-	stream, err := src.Download(v)
+	stream, err := src.Download(context.Background(), v)
 	if err != nil {
 		t.Fatalf("Download: %v", err)
 	}
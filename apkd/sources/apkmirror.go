@@ -0,0 +1,586 @@
+package sources
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/devices"
+	"github.com/kiber-io/apkd/apkd/network"
+	fakeUserAgent "github.com/lib4u/fake-useragent"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type ApkMirror struct {
+	BaseSource
+	config ApkMirrorConfig
+	device devices.Device
+}
+
+type ApkMirrorConfig struct {
+	BaseSourceConfig `yaml:",inline"`
+}
+
+// apkMirrorApp is an app page: /apk/<developer>/<app>/.
+type apkMirrorApp struct {
+	URL         string
+	Slug        string
+	DeveloperId string
+	PackageName string
+}
+
+// apkMirrorRelease is an entry of the uploads listing.
+type apkMirrorRelease struct {
+	Title string
+	URL   string
+}
+
+// apkMirrorVariant is a row of the variants table of a release page.
+type apkMirrorVariant struct {
+	VersionName string
+	VersionCode int
+	Link        string
+	Type        FileType
+	Arch        string
+	MinSDK      int
+	DPI         string
+}
+
+// androidVersionSDKs maps the "Android X+" labels of the variants table to
+// API levels.
+var androidVersionSDKs = map[string]int{
+	"4.0": 14, "4.0.3": 15, "4.1": 16, "4.2": 17, "4.3": 18, "4.4": 19, "4.4W": 20,
+	"5.0": 21, "5.1": 22, "6.0": 23, "7.0": 24, "7.1": 25, "8.0": 26, "8.1": 27,
+	"9": 28, "9.0": 28, "10": 29, "11": 30, "12": 31, "12L": 32, "13": 33,
+	"14": 34, "15": 35, "16": 36,
+}
+
+func defaultApkMirrorConfig() ApkMirrorConfig {
+	return ApkMirrorConfig{
+		BaseSourceConfig: BaseSourceConfig{
+			BaseURL: "https://www.apkmirror.com",
+		},
+	}
+}
+
+func (s *ApkMirror) Name() string {
+	return "apkmirror"
+}
+
+func (s *ApkMirror) resolveURL(ref string) (string, error) {
+	base, err := neturl.Parse(s.config.BaseURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	parsed, err := neturl.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid link %q: %w", ref, err)
+	}
+	return base.ResolveReference(parsed).String(), nil
+}
+
+// parseApkMirrorAppPath returns the developer and app slugs of a link to an
+// app page or to any page below it.
+func parseApkMirrorAppPath(link string) (string, string, error) {
+	parsed, err := neturl.Parse(link)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid link %q: %w", link, err)
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) < 3 || segments[0] != "apk" || segments[1] == "" || segments[2] == "" {
+		return "", "", fmt.Errorf("not an app link: %q", link)
+	}
+	return segments[1], segments[2], nil
+}
+
+func parseAndroidVersionSDK(rawText string) (int, error) {
+	text := strings.TrimSpace(rawText)
+	text = strings.TrimSpace(strings.TrimPrefix(text, "Android"))
+	text = strings.TrimSuffix(text, "+")
+	sdk, found := androidVersionSDKs[text]
+	if !found {
+		return 0, fmt.Errorf("unknown Android version %q", rawText)
+	}
+	return sdk, nil
+}
+
+func parseApkMirrorFileType(rawText string) (FileType, error) {
+	switch strings.TrimSpace(rawText) {
+	case "APK":
+		return APK, nil
+	case "BUNDLE":
+		return APKM, nil
+	default:
+		return "", fmt.Errorf("unknown file type: %q", rawText)
+	}
+}
+
+// archRank returns how well the architecture column fits the device: 0 for
+// its primary ABI, higher for the others, and false when the variant cannot
+// be installed.
+func archRank(arch string, abis []string) (int, bool) {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if arch == "universal" || arch == "noarch" {
+		return len(abis), true
+	}
+	for i, abi := range abis {
+		for _, variantAbi := range strings.Split(arch, "+") {
+			if strings.TrimSpace(variantAbi) == abi {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// dpiMatches reports whether the DPI column ("nodpi", "480dpi",
+// "120-640dpi") covers the device density.
+func dpiMatches(rawDPI string, dpi int) bool {
+	text := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rawDPI)), "dpi")
+	if text == "no" {
+		return true
+	}
+	low, high, isRange := strings.Cut(text, "-")
+	if !isRange {
+		high = low
+	}
+	lowValue, err := strconv.Atoi(low)
+	if err != nil {
+		return false
+	}
+	highValue, err := strconv.Atoi(high)
+	if err != nil {
+		return false
+	}
+	return dpi >= lowValue && dpi <= highValue
+}
+
+// selectVariant picks the variant to download for the device among the
+// variants of one release: the variant must fit its ABIs, density and SDK;
+// single APKs are preferred over bundles, then the closest architecture,
+// then the newest build.
+func selectVariant(variants []apkMirrorVariant, device devices.Device) (apkMirrorVariant, bool) {
+	type candidate struct {
+		variant  apkMirrorVariant
+		archRank int
+	}
+	var candidates []candidate
+	for _, variant := range variants {
+		rank, ok := archRank(variant.Arch, device.CPUAbis)
+		if !ok || variant.MinSDK > device.SDKInt || !dpiMatches(variant.DPI, device.DPI) {
+			continue
+		}
+		candidates = append(candidates, candidate{variant: variant, archRank: rank})
+	}
+	if len(candidates) == 0 {
+		return apkMirrorVariant{}, false
+	}
+	best := slices.MinFunc(candidates, func(a, b candidate) int {
+		if c := cmp.Compare(fileTypeRank(a.variant.Type), fileTypeRank(b.variant.Type)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.archRank, b.archRank); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.variant.VersionCode, a.variant.VersionCode)
+	})
+	return best.variant, true
+}
+
+func fileTypeRank(fileType FileType) int {
+	if fileType == APK {
+		return 0
+	}
+	return 1
+}
+
+// isPreRelease reports whether an uploads entry is an alpha or beta build.
+func isPreRelease(title string) bool {
+	title = strings.ToLower(title)
+	return strings.Contains(title, " alpha") || strings.Contains(title, " beta")
+}
+
+// parseVariants reads the variants table of a release page.
+func (s *ApkMirror) parseVariants(doc *goquery.Document) ([]apkMirrorVariant, error) {
+	var variants []apkMirrorVariant
+	var err error
+	doc.Find(".variants-table .table-row").Not(".headerFont").EachWithBreak(func(i int, row *goquery.Selection) bool {
+		cells := row.Find(".table-cell")
+		if cells.Length() < 4 {
+			err = fmt.Errorf("variant row has %d cells, expected 4", cells.Length())
+			return false
+		}
+		var variant apkMirrorVariant
+		link := cells.Eq(0).Find("a.accent_color").First()
+		href, exists := link.Attr("href")
+		if !exists {
+			err = errors.New("variant link not found")
+			return false
+		}
+		if variant.Link, err = s.resolveURL(href); err != nil {
+			return false
+		}
+		variant.VersionName = strings.TrimSpace(link.Text())
+		if variant.Type, err = parseApkMirrorFileType(cells.Eq(0).Find(".apkm-badge").First().Text()); err != nil {
+			return false
+		}
+		if variant.VersionCode, err = parseVersionCodeText(cells.Eq(0).Find("span.colorLightBlack").First().Text()); err != nil {
+			return false
+		}
+		variant.Arch = strings.TrimSpace(cells.Eq(1).Text())
+		minSDK, sdkErr := parseAndroidVersionSDK(cells.Eq(2).Text())
+		if sdkErr != nil {
+			s.Log().Logw(fmt.Sprintf("Skipping variant %s: %v", variant.Link, sdkErr))
+			return true
+		}
+		variant.MinSDK = minSDK
+		variant.DPI = strings.TrimSpace(cells.Eq(3).Text())
+		variants = append(variants, variant)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse variants: %w", err)
+	}
+	return variants, nil
+}
+
+func (s *ApkMirror) getVariants(ctx context.Context, releaseURL string) ([]apkMirrorVariant, error) {
+	doc, _, err := s.fetchDocument(ctx, releaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return s.parseVariants(doc)
+}
+
+// getApp fetches an app page and reads the package name from its Play Store
+// link.
+func (s *ApkMirror) getApp(ctx context.Context, appURL string) (apkMirrorApp, error) {
+	developerId, slug, err := parseApkMirrorAppPath(appURL)
+	if err != nil {
+		return apkMirrorApp{}, err
+	}
+	doc, _, err := s.fetchDocument(ctx, appURL)
+	if err != nil {
+		return apkMirrorApp{}, err
+	}
+	app := apkMirrorApp{URL: appURL, Slug: slug, DeveloperId: developerId}
+	doc.Find(`a[href*="play.google.com/store/apps/details"]`).EachWithBreak(func(i int, e *goquery.Selection) bool {
+		href, _ := e.Attr("href")
+		parsed, err := neturl.Parse(href)
+		if err != nil {
+			return true
+		}
+		app.PackageName = parsed.Query().Get("id")
+		return app.PackageName == ""
+	})
+	if app.PackageName == "" {
+		return apkMirrorApp{}, fmt.Errorf("package name not found on %s", appURL)
+	}
+	return app, nil
+}
+
+// findApp searches for the package and returns the app page whose Play
+// Store link names it.
+func (s *ApkMirror) findApp(ctx context.Context, packageName string) (apkMirrorApp, error) {
+	searchURL := fmt.Sprintf("%s/?post_type=app_release&searchtype=app&s=%s", s.config.BaseURL, neturl.QueryEscape(packageName))
+	doc, _, err := s.fetchDocument(ctx, searchURL)
+	if err != nil {
+		return apkMirrorApp{}, err
+	}
+	appURLs, err := s.appLinks(doc)
+	if err != nil {
+		return apkMirrorApp{}, err
+	}
+	for _, appURL := range appURLs {
+		app, err := s.getApp(ctx, appURL)
+		if err != nil {
+			s.Log().Logw(fmt.Sprintf("Failed to read app page: %v", err))
+			continue
+		}
+		if app.PackageName == packageName {
+			return app, nil
+		}
+	}
+	return apkMirrorApp{}, &AppNotFoundError{PackageName: packageName}
+}
+
+// appLinks returns the distinct app pages linked from a listing.
+func (s *ApkMirror) appLinks(doc *goquery.Document) ([]string, error) {
+	var links []string
+	seen := make(map[string]struct{})
+	var err error
+	doc.Find(".appRow h5.appRowTitle a").EachWithBreak(func(i int, e *goquery.Selection) bool {
+		href, exists := e.Attr("href")
+		if !exists {
+			return true
+		}
+		developerId, slug, pathErr := parseApkMirrorAppPath(href)
+		if pathErr != nil {
+			return true
+		}
+		var appURL string
+		if appURL, err = s.resolveURL("/apk/" + developerId + "/" + slug + "/"); err != nil {
+			return false
+		}
+		if _, exists := seen[appURL]; !exists {
+			seen[appURL] = struct{}{}
+			links = append(links, appURL)
+		}
+		return true
+	})
+	return links, err
+}
+
+// walkReleases passes the releases of the app, newest first, to visit until
+// visit returns false, following the pages of the uploads listing.
+func (s *ApkMirror) walkReleases(ctx context.Context, app apkMirrorApp, visit func(apkMirrorRelease) (bool, error)) error {
+	pageURL := fmt.Sprintf("%s/uploads/?appcategory=%s", s.config.BaseURL, neturl.QueryEscape(app.Slug))
+	for pageURL != "" {
+		doc, _, err := s.fetchDocument(ctx, pageURL)
+		if err != nil {
+			return err
+		}
+		var stopped bool
+		var visitErr error
+		doc.Find(".appRow h5.appRowTitle a").EachWithBreak(func(i int, e *goquery.Selection) bool {
+			href, exists := e.Attr("href")
+			if !exists || href == "" {
+				s.Log().Logw("Release item missing href attribute")
+				return true
+			}
+			releaseURL, err := s.resolveURL(href)
+			if err != nil {
+				s.Log().Logw(err.Error())
+				return true
+			}
+			var more bool
+			more, visitErr = visit(apkMirrorRelease{Title: strings.TrimSpace(e.Text()), URL: releaseURL})
+			stopped = !more
+			return more && visitErr == nil
+		})
+		if visitErr != nil {
+			return visitErr
+		}
+		if stopped {
+			return nil
+		}
+		pageURL = ""
+		if href, exists := doc.Find("a.nextpostslink").First().Attr("href"); exists {
+			if pageURL, err = s.resolveURL(href); err != nil {
+				return err
+			}
+			if err := sleepWithJitter(ctx, 200*time.Millisecond, 200*time.Millisecond); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ApkMirror) versionFromVariant(app apkMirrorApp, variant apkMirrorVariant) Version {
+	return Version{
+		Name:        variant.VersionName,
+		Code:        variant.VersionCode,
+		Link:        variant.Link,
+		PackageName: app.PackageName,
+		DeveloperId: app.DeveloperId,
+		Type:        variant.Type,
+	}
+}
+
+// FindByPackage returns the variant of the newest stable release that fits
+// the device, or the variant with versionCode from any release. The listing
+// is newest first, so the search for versionCode stops at the first release
+// that is older.
+func (s *ApkMirror) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
+	app, err := s.findApp(ctx, packageName)
+	if err != nil {
+		return Version{}, err
+	}
+	var found apkMirrorVariant
+	err = s.walkReleases(ctx, app, func(release apkMirrorRelease) (bool, error) {
+		if versionCode == 0 && isPreRelease(release.Title) {
+			return true, nil
+		}
+		variants, err := s.getVariants(ctx, release.URL)
+		if err != nil {
+			return false, err
+		}
+		if versionCode != 0 {
+			if len(variants) > 0 && !slices.ContainsFunc(variants, func(v apkMirrorVariant) bool {
+				return v.VersionCode >= versionCode
+			}) {
+				return false, nil
+			}
+			variants = slices.DeleteFunc(variants, func(v apkMirrorVariant) bool {
+				return v.VersionCode != versionCode
+			})
+			if len(variants) == 0 {
+				return true, nil
+			}
+		}
+		variant, ok := selectVariant(variants, s.device)
+		if !ok {
+			if versionCode != 0 {
+				s.Log().Logd(fmt.Sprintf("No variant of %s fits the device", release.URL))
+				return false, nil
+			}
+			s.Log().Logi(fmt.Sprintf("No variant of %s fits the device, trying an older release", release.URL))
+			return true, nil
+		}
+		found = variant
+		return false, nil
+	})
+	if err != nil {
+		return Version{}, err
+	}
+	if found.VersionCode == 0 {
+		return Version{}, &AppNotFoundError{PackageName: packageName}
+	}
+	return s.versionFromVariant(app, found), nil
+}
+
+// ListVersions walks the uploads listing and resolves the best variant of
+// every release. Each release costs a request.
+func (s *ApkMirror) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	app, err := s.findApp(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	err = s.walkReleases(ctx, app, func(release apkMirrorRelease) (bool, error) {
+		if err := sleepWithJitter(ctx, 200*time.Millisecond, 200*time.Millisecond); err != nil {
+			return false, err
+		}
+		variants, err := s.getVariants(ctx, release.URL)
+		if err != nil {
+			s.Log().Logw(fmt.Sprintf("Failed to read release %s: %v", release.URL, err))
+			return true, nil
+		}
+		if variant, ok := selectVariant(variants, s.device); ok {
+			versions = append(versions, s.versionFromVariant(app, variant))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, &AppNotFoundError{PackageName: packageName}
+	}
+	return versions, nil
+}
+
+// FindByDeveloper lists the apps on the developer page. developerId is the
+// developer slug of the app URLs, as reported in Version.DeveloperId.
+func (s *ApkMirror) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
+	developerURL := fmt.Sprintf("%s/apk/%s/", s.config.BaseURL, neturl.PathEscape(developerId))
+	doc, _, err := s.fetchDocument(ctx, developerURL)
+	if err != nil {
+		return nil, err
+	}
+	appURLs, err := s.appLinks(doc)
+	if err != nil {
+		return nil, err
+	}
+	var packages []string
+	for _, appURL := range appURLs {
+		app, err := s.getApp(ctx, appURL)
+		if err != nil {
+			s.Log().Logw(fmt.Sprintf("Failed to read app page: %v", err))
+			continue
+		}
+		if app.DeveloperId == developerId {
+			packages = append(packages, app.PackageName)
+		}
+	}
+	return packages, nil
+}
+
+// downloadLink follows the variant page to its download page and returns the
+// file link, which is only valid for a short time.
+func (s *ApkMirror) downloadLink(ctx context.Context, variantURL string) (string, error) {
+	doc, _, err := s.fetchDocument(ctx, variantURL)
+	if err != nil {
+		return "", err
+	}
+	href, exists := doc.Find("a.downloadButton").First().Attr("href")
+	if !exists {
+		return "", fmt.Errorf("download button not found on %s", variantURL)
+	}
+	downloadPageURL, err := s.resolveURL(href)
+	if err != nil {
+		return "", err
+	}
+	doc, _, err = s.fetchDocument(ctx, downloadPageURL)
+	if err != nil {
+		return "", err
+	}
+	href, exists = doc.Find("a#download-link").First().Attr("href")
+	if !exists {
+		return "", fmt.Errorf("download link not found on %s", downloadPageURL)
+	}
+	return s.resolveURL(href)
+}
+
+func (s *ApkMirror) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *ApkMirror) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	link, err := s.downloadLink(ctx, version.Link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve download link: %w", err)
+	}
+	req, err := s.NewRequest(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", version.Link)
+	return createRangeResponseReader(s.Http(), req, byteRange)
+}
+
+func newApkMirrorSource() (Source, error) {
+	s := &ApkMirror{device: currentDevice()}
+	s.Source = s
+	ua, err := fakeUserAgent.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake user agent: %w", err)
+	}
+	config, err := ResolveSourceConfig(s.Name(), defaultApkMirrorConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode apkmirror config: %w", err)
+	}
+	s.config = config
+	randomUA := ua.Filter().Platform(fakeUserAgent.Desktop).Browser(fakeUserAgent.Firefox, fakeUserAgent.Chrome).Get()
+	s.Log().Logd("Using User-Agent: " + randomUA)
+	s.Log().Logd(fmt.Sprintf("Selecting variants for: %s (SDK %d, %d dpi, %s)", s.device.Model, s.device.SDKInt, s.device.DPI, strings.Join(s.device.CPUAbis, ", ")))
+	headers := ApplyConfiguredHeaders(http.Header{
+		"User-Agent":                {randomUA},
+		"upgrade-insecure-requests": {"1"},
+		"sec-fetch-dest":            {"document"},
+		"sec-fetch-mode":            {"navigate"},
+	}, config.Headers)
+	s.Net = network.DefaultClientForSource(s.Name()).WithDefaultHeaders(headers)
+	return s, nil
+}
+
+func init() {
+	RegisterSourceFactoryWithConfig(newApkMirrorSource, "apkmirror", NewConfigDecoderWithDefaults(
+		defaultApkMirrorConfig(),
+		func(c *ApkMirrorConfig) {
+			NormalizeBaseSourceConfig(&c.BaseSourceConfig)
+		},
+		func(c ApkMirrorConfig) error {
+			return ValidateBaseSourceConfig(c.BaseSourceConfig)
+		},
+	))
+}
@@ -0,0 +1,264 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/devices"
+)
+
+const apkMirrorSearchHTML = `<html><body><div class="listWidget">
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/other-dev/other-app/">Other App</a></h5></div>
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/">Example App</a></h5></div>
+</div></body></html>`
+
+const apkMirrorAppHTML = `<html><body>
+<h1>Example App</h1>
+<a href="https://play.google.com/store/apps/details?id=com.example.app&hl=en">Google Play</a>
+</body></html>`
+
+const apkMirrorOtherAppHTML = `<html><body>
+<a href="https://play.google.com/store/apps/details?id=com.other">Google Play</a>
+</body></html>`
+
+const apkMirrorUploadsHTML = `<html><body><div class="listWidget">
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/example-app-2-1-0-beta-release/">Example App 2.1.0 beta</a></h5></div>
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/example-app-2-0-0-release/">Example App 2.0.0</a></h5></div>
+</div>
+<a class="nextpostslink" href="/uploads/page/2/?appcategory=example-app">Next</a>
+</body></html>`
+
+const apkMirrorUploadsPage2HTML = `<html><body><div class="listWidget">
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/example-app-1-0-0-release/">Example App 1.0.0</a></h5></div>
+</div></body></html>`
+
+func apkMirrorVariantRow(name, code, badge, link, arch, minVersion, dpi string) string {
+	return `<div class="table-row">
+<div class="table-cell"><a class="accent_color" href="` + link + `">` + name + `</a>
+<span class="apkm-badge">` + badge + `</span><span class="colorLightBlack">` + code + `</span></div>
+<div class="table-cell">` + arch + `</div>
+<div class="table-cell">` + minVersion + `</div>
+<div class="table-cell">` + dpi + `</div>
+</div>`
+}
+
+func apkMirrorReleaseHTML(rows ...string) string {
+	return `<html><body><div class="variants-table">
+<div class="table-row headerFont"><div class="table-cell">Variant</div><div class="table-cell">Architecture</div><div class="table-cell">Minimum Version</div><div class="table-cell">Screen DPI</div></div>` +
+		strings.Join(rows, "\n") + `</div></body></html>`
+}
+
+func apkMirrorFixtures() map[string]string {
+	return map[string]string{
+		"/":                             apkMirrorSearchHTML,
+		"/apk/example-inc/example-app/": apkMirrorAppHTML,
+		"/apk/other-dev/other-app/":     apkMirrorOtherAppHTML,
+		"/uploads/":                     apkMirrorUploadsHTML,
+		"/uploads/page/2/":              apkMirrorUploadsPage2HTML,
+		"/apk/example-inc/example-app/example-app-2-1-0-beta-release/": apkMirrorReleaseHTML(
+			apkMirrorVariantRow("2.1.0", "210", "APK", "/apk/example-inc/example-app/example-app-2-1-0-beta-release/beta-apk/", "universal", "Android 8.0+", "nodpi"),
+		),
+		"/apk/example-inc/example-app/example-app-2-0-0-release/": apkMirrorReleaseHTML(
+			apkMirrorVariantRow("2.0.0", "201", "BUNDLE", "/apk/example-inc/example-app/example-app-2-0-0-release/bundle/", "arm64-v8a", "Android 8.0+", "nodpi"),
+			apkMirrorVariantRow("2.0.0", "202", "APK", "/apk/example-inc/example-app/example-app-2-0-0-release/x86/", "x86_64", "Android 8.0+", "nodpi"),
+			apkMirrorVariantRow("2.0.0", "203", "APK", "/apk/example-inc/example-app/example-app-2-0-0-release/universal/", "universal", "Android 8.0+", "nodpi"),
+			apkMirrorVariantRow("2.0.0", "204", "APK", "/apk/example-inc/example-app/example-app-2-0-0-release/arm64/", "arm64-v8a + armeabi-v7a", "Android 8.0+", "120-640dpi"),
+			apkMirrorVariantRow("2.0.0", "205", "APK", "/apk/example-inc/example-app/example-app-2-0-0-release/arm64-new/", "arm64-v8a", "Android 16+", "nodpi"),
+		),
+		"/apk/example-inc/example-app/example-app-1-0-0-release/": apkMirrorReleaseHTML(
+			apkMirrorVariantRow("1.0.0", "100", "BUNDLE", "/apk/example-inc/example-app/example-app-1-0-0-release/bundle/", "arm64-v8a + armeabi-v7a", "Android 5.0+", "nodpi"),
+		),
+		"/apk/example-inc/example-app/example-app-2-0-0-release/arm64/": `<html><body>
+<a class="downloadButton" href="/apk/example-inc/example-app/example-app-2-0-0-release/arm64/download/?key=abc">Download APK</a>
+</body></html>`,
+		"/apk/example-inc/example-app/example-app-2-0-0-release/arm64/download/": `<html><body>
+<a id="download-link" href="/wp-content/themes/APKMirror/download.php?id=1&key=def">here</a>
+</body></html>`,
+		"/apk/example-inc/": `<html><body><div class="listWidget">
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/example-app-2-0-0-release/">Example App 2.0.0</a></h5></div>
+<div class="appRow"><h5 class="appRowTitle"><a href="/apk/example-inc/example-app/">Example App</a></h5></div>
+</div></body></html>`,
+	}
+}
+
+func testApkMirrorDevice() devices.Device {
+	return devices.Device{Model: "Pixel 7", SDKInt: 34, DPI: 420, CPUAbis: []string{"arm64-v8a", "armeabi-v7a"}}
+}
+
+func mockApkMirror(pages map[string]string, requests *[]*http.Request) *ApkMirror {
+	s := &ApkMirror{config: defaultApkMirrorConfig(), device: testApkMirrorDevice()}
+	s.Source = s
+	s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+		if requests != nil {
+			*requests = append(*requests, req)
+		}
+		if req.URL.Path == "/wp-content/themes/APKMirror/download.php" {
+			return okResp(req, "PK-file"), nil
+		}
+		page, found := pages[req.URL.Path]
+		if !found {
+			return statusResp(req, http.StatusNotFound), nil
+		}
+		return okResp(req, page), nil
+	})
+	return s
+}
+
+func TestApkMirrorFindByPackageSelectsVariant(t *testing.T) {
+	s := mockApkMirror(apkMirrorFixtures(), nil)
+
+	version, err := s.FindByPackage(context.Background(), "com.example.app", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The beta release is skipped, the bundle loses to single APKs, x86_64
+	// and Android 16 do not fit the device, and arm64 beats universal.
+	if version.Code != 204 || version.Name != "2.0.0" || version.Type != APK {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+	if version.PackageName != "com.example.app" || version.DeveloperId != "example-inc" {
+		t.Fatalf("unexpected package or developer: %+v", version)
+	}
+	if version.Link != "https://www.apkmirror.com/apk/example-inc/example-app/example-app-2-0-0-release/arm64/" {
+		t.Fatalf("unexpected link: %s", version.Link)
+	}
+}
+
+func TestApkMirrorFindByPackageVersionCode(t *testing.T) {
+	s := mockApkMirror(apkMirrorFixtures(), nil)
+
+	version, err := s.FindByPackage(context.Background(), "com.example.app", 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version.Code != 100 || version.Name != "1.0.0" || version.Type != APKM {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+
+	var notFound *AppNotFoundError
+	if _, err := s.FindByPackage(context.Background(), "com.example.app", 205); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError for a variant that does not fit the device, got %v", err)
+	}
+}
+
+func TestApkMirrorFindByPackageVersionCodeStopsAtOlderRelease(t *testing.T) {
+	var requests []*http.Request
+	s := mockApkMirror(apkMirrorFixtures(), &requests)
+
+	_, err := s.FindByPackage(context.Background(), "com.example.app", 209)
+	var notFound *AppNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError, got %v", err)
+	}
+	for _, req := range requests {
+		if req.URL.Path == "/uploads/page/2/" || strings.Contains(req.URL.Path, "1-0-0-release") {
+			t.Fatalf("expected the search to stop at release 2.0.0, but it requested %s", req.URL)
+		}
+	}
+}
+
+func TestApkMirrorFindByPackageNotFound(t *testing.T) {
+	s := mockApkMirror(apkMirrorFixtures(), nil)
+
+	var notFound *AppNotFoundError
+	if _, err := s.FindByPackage(context.Background(), "com.missing", 0); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError, got %v", err)
+	}
+}
+
+func TestApkMirrorListVersions(t *testing.T) {
+	s := mockApkMirror(apkMirrorFixtures(), nil)
+
+	versions, err := s.ListVersions(context.Background(), "com.example.app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var codes []int
+	for _, version := range versions {
+		codes = append(codes, version.Code)
+	}
+	if len(codes) != 3 || codes[0] != 210 || codes[1] != 204 || codes[2] != 100 {
+		t.Fatalf("unexpected versions: %v", codes)
+	}
+}
+
+func TestApkMirrorFindByDeveloper(t *testing.T) {
+	s := mockApkMirror(apkMirrorFixtures(), nil)
+
+	packages, err := s.FindByDeveloper(context.Background(), "example-inc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packages) != 1 || packages[0] != "com.example.app" {
+		t.Fatalf("unexpected packages: %v", packages)
+	}
+}
+
+func TestApkMirrorDownloadFollowsDownloadPage(t *testing.T) {
+	var requests []*http.Request
+	s := mockApkMirror(apkMirrorFixtures(), &requests)
+	version := Version{
+		PackageName: "com.example.app",
+		Link:        "https://www.apkmirror.com/apk/example-inc/example-app/example-app-2-0-0-release/arm64/",
+	}
+
+	stream, err := s.Download(context.Background(), version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	body, _ := io.ReadAll(stream.Body)
+	if string(body) != "PK-file" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	last := requests[len(requests)-1]
+	if last.URL.Query().Get("key") != "def" || last.Header.Get("Referer") != version.Link {
+		t.Fatalf("unexpected file request: %s (Referer %q)", last.URL, last.Header.Get("Referer"))
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	device := testApkMirrorDevice()
+	variants := []apkMirrorVariant{
+		{VersionCode: 1, Type: APK, Arch: "x86", DPI: "nodpi", MinSDK: 21},
+		{VersionCode: 2, Type: APK, Arch: "armeabi-v7a", DPI: "nodpi", MinSDK: 21},
+		{VersionCode: 3, Type: APK, Arch: "arm64-v8a", DPI: "240dpi", MinSDK: 21},
+	}
+	variant, ok := selectVariant(variants, device)
+	if !ok || variant.VersionCode != 2 {
+		t.Fatalf("expected the armeabi-v7a variant, got %+v (%v)", variant, ok)
+	}
+	if _, ok := selectVariant(variants[:1], device); ok {
+		t.Fatalf("expected no variant for x86 only")
+	}
+}
+
+func TestDPIMatches(t *testing.T) {
+	for dpi, want := range map[string]bool{
+		"nodpi":      true,
+		"420dpi":     true,
+		"480dpi":     false,
+		"120-640dpi": true,
+		"120-320dpi": false,
+		"unknown":    false,
+	} {
+		if got := dpiMatches(dpi, 420); got != want {
+			t.Errorf("dpiMatches(%q, 420) = %v, want %v", dpi, got, want)
+		}
+	}
+}
+
+func TestParseAndroidVersionSDK(t *testing.T) {
+	for text, want := range map[string]int{"Android 8.0+": 26, "Android 12L+": 32, "Android 14+": 34} {
+		if got, err := parseAndroidVersionSDK(text); err != nil || got != want {
+			t.Errorf("parseAndroidVersionSDK(%q) = %d, %v, want %d", text, got, err, want)
+		}
+	}
+	if _, err := parseAndroidVersionSDK("Android 99+"); err == nil {
+		t.Fatalf("expected error for unknown version")
+	}
+}
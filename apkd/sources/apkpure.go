@@ -0,0 +1,325 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/network"
+	fakeUserAgent "github.com/lib4u/fake-useragent"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type ApkPure struct {
+	BaseSource
+	config ApkPureConfig
+}
+
+type ApkPureConfig struct {
+	BaseSourceConfig `yaml:",inline"`
+	// DownloadURL is the host serving the files, separate from the site.
+	DownloadURL string `yaml:"download_url"`
+}
+
+type apkPureVersionItem struct {
+	VersionName string
+	VersionCode int
+	Type        FileType
+	Size        uint64
+}
+
+func defaultApkPureConfig() ApkPureConfig {
+	return ApkPureConfig{
+		BaseSourceConfig: BaseSourceConfig{
+			BaseURL: "https://apkpure.com",
+		},
+		DownloadURL: "https://d.apkpure.com",
+	}
+}
+
+func (s *ApkPure) Name() string {
+	return "apkpure"
+}
+
+// parseApkPureSize parses sizes such as "45.2 MB" shown next to versions.
+func parseApkPureSize(rawText string) (uint64, error) {
+	fields := strings.Fields(strings.TrimSpace(rawText))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid size %q", rawText)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", rawText)
+	}
+	var unit float64
+	switch strings.ToUpper(fields[1]) {
+	case "B":
+		unit = 1
+	case "KB":
+		unit = 1 << 10
+	case "MB":
+		unit = 1 << 20
+	case "GB":
+		unit = 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid size unit in %q", rawText)
+	}
+	return uint64(value * unit), nil
+}
+
+func (s *ApkPure) resolveURL(ref string) (string, error) {
+	base, err := neturl.Parse(s.config.BaseURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	parsed, err := neturl.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid link %q: %w", ref, err)
+	}
+	return base.ResolveReference(parsed).String(), nil
+}
+
+// findAppPage searches for the package and returns the URL of its app page.
+func (s *ApkPure) findAppPage(ctx context.Context, packageName string) (string, error) {
+	searchURL := fmt.Sprintf("%s/search?q=%s", s.config.BaseURL, neturl.QueryEscape(packageName))
+	doc, _, err := s.fetchDocument(ctx, searchURL)
+	if err != nil {
+		return "", err
+	}
+	var appURL string
+	doc.Find("a[data-dt-app]").EachWithBreak(func(i int, e *goquery.Selection) bool {
+		if app, _ := e.Attr("data-dt-app"); app != packageName {
+			return true
+		}
+		href, exists := e.Attr("href")
+		if !exists {
+			return true
+		}
+		if appURL, err = s.resolveURL(href); err != nil {
+			return false
+		}
+		return false
+	})
+	if err != nil {
+		return "", err
+	}
+	if appURL == "" {
+		return "", &AppNotFoundError{PackageName: packageName}
+	}
+	return appURL, nil
+}
+
+// parseVersions reads the version list of the versions page, newest first.
+func (s *ApkPure) parseVersions(doc *goquery.Document) ([]apkPureVersionItem, error) {
+	var versions []apkPureVersionItem
+	var err error
+	doc.Find("a.ver_download_link").EachWithBreak(func(i int, e *goquery.Selection) bool {
+		var item apkPureVersionItem
+		item.VersionName = strings.TrimSpace(e.AttrOr("data-dt-version", ""))
+		if item.VersionName == "" {
+			err = errors.New("version name not found")
+			return false
+		}
+		if item.VersionCode, err = parseVersionCodeText(e.AttrOr("data-dt-versioncode", "")); err != nil {
+			return false
+		}
+		if item.Type, err = parseApkComboFileType(e.Find(".ver-item-t").First().Text()); err != nil {
+			return false
+		}
+		if sizeText := e.Find(".ver-item-s").First().Text(); strings.TrimSpace(sizeText) != "" {
+			size, sizeErr := parseApkPureSize(sizeText)
+			if sizeErr != nil {
+				s.Log().Logw(fmt.Sprintf("Ignoring size of version %s: %v", item.VersionName, sizeErr))
+			}
+			item.Size = size
+		}
+		versions = append(versions, item)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse versions: %w", err)
+	}
+	return versions, nil
+}
+
+// getVersions returns the versions of the package and its developer.
+func (s *ApkPure) getVersions(ctx context.Context, packageName string) ([]apkPureVersionItem, string, error) {
+	appURL, err := s.findAppPage(ctx, packageName)
+	if err != nil {
+		return nil, "", err
+	}
+	versionsURL, err := neturl.JoinPath(appURL, "versions")
+	if err != nil {
+		return nil, "", err
+	}
+	doc, _, err := s.fetchDocument(ctx, versionsURL)
+	if err != nil {
+		return nil, "", err
+	}
+	versions, err := s.parseVersions(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(versions) == 0 {
+		return nil, "", &AppNotFoundError{PackageName: packageName}
+	}
+	var developerId string
+	if href, exists := doc.Find(`a[href*="/developer/"]`).First().Attr("href"); exists {
+		developerId = apkPureDeveloperId(href)
+	}
+	if developerId == "" {
+		s.Log().Logw(fmt.Sprintf("Developer not found for package %s", packageName))
+	}
+	return versions, developerId, nil
+}
+
+// apkPureDeveloperId returns the developer name from a /developer/<name>
+// link.
+func apkPureDeveloperId(href string) string {
+	parsed, err := neturl.Parse(href)
+	if err != nil {
+		return ""
+	}
+	developerId, err := neturl.PathUnescape(path.Base(parsed.Path))
+	if err != nil || developerId == "developer" || developerId == "/" || developerId == "." {
+		return ""
+	}
+	return developerId
+}
+
+func (s *ApkPure) downloadLink(packageName string, item apkPureVersionItem) string {
+	fileType := "APK"
+	if item.Type == XAPK {
+		fileType = "XAPK"
+	}
+	return fmt.Sprintf("%s/b/%s/%s?versionCode=%d", s.config.DownloadURL, fileType, neturl.PathEscape(packageName), item.VersionCode)
+}
+
+func (s *ApkPure) version(packageName, developerId string, item apkPureVersionItem) Version {
+	return Version{
+		Name:        item.VersionName,
+		Code:        item.VersionCode,
+		Size:        item.Size,
+		Link:        s.downloadLink(packageName, item),
+		PackageName: packageName,
+		DeveloperId: developerId,
+		Type:        item.Type,
+	}
+}
+
+// FindByPackage returns the newest version, or the version with versionCode.
+// When a version is offered both as APK and as XAPK, the APK is used.
+func (s *ApkPure) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
+	versions, developerId, err := s.getVersions(ctx, packageName)
+	if err != nil {
+		return Version{}, err
+	}
+	if versionCode == 0 {
+		versionCode = versions[0].VersionCode
+	}
+	matching := slices.DeleteFunc(versions, func(item apkPureVersionItem) bool {
+		return item.VersionCode != versionCode
+	})
+	if len(matching) == 0 {
+		return Version{}, &AppNotFoundError{PackageName: packageName}
+	}
+	item := matching[0]
+	if i := slices.IndexFunc(matching, func(item apkPureVersionItem) bool { return item.Type == APK }); i >= 0 {
+		item = matching[i]
+	}
+	return s.version(packageName, developerId, item), nil
+}
+
+// ListVersions returns every version on the versions page.
+func (s *ApkPure) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	items, developerId, err := s.getVersions(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(items))
+	for _, item := range items {
+		versions = append(versions, s.version(packageName, developerId, item))
+	}
+	return versions, nil
+}
+
+func (s *ApkPure) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
+	developerURL := fmt.Sprintf("%s/developer/%s", s.config.BaseURL, neturl.PathEscape(developerId))
+	doc, _, err := s.fetchDocument(ctx, developerURL)
+	if err != nil {
+		return nil, err
+	}
+	var packages []string
+	doc.Find("a[data-dt-app]").Each(func(i int, e *goquery.Selection) {
+		packageName := strings.TrimSpace(e.AttrOr("data-dt-app", ""))
+		if packageName != "" && !slices.Contains(packages, packageName) {
+			packages = append(packages, packageName)
+		}
+	})
+	return packages, nil
+}
+
+func (s *ApkPure) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *ApkPure) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	req, err := s.NewRequest(ctx, "GET", version.Link, nil)
+	if err != nil {
+		return nil, err
+	}
+	return createRangeResponseReader(s.Http(), req, byteRange)
+}
+
+func newApkPureSource() (Source, error) {
+	s := &ApkPure{}
+	s.Source = s
+	ua, err := fakeUserAgent.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake user agent: %w", err)
+	}
+	config, err := ResolveSourceConfig(s.Name(), defaultApkPureConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode apkpure config: %w", err)
+	}
+	s.config = config
+	randomUA := ua.Filter().Platform(fakeUserAgent.Desktop).Browser(fakeUserAgent.Firefox, fakeUserAgent.Chrome).Get()
+	s.Log().Logd("Using User-Agent: " + randomUA)
+	headers := ApplyConfiguredHeaders(http.Header{
+		"User-Agent":                {randomUA},
+		"upgrade-insecure-requests": {"1"},
+	}, config.Headers)
+	s.Net = network.DefaultClientForSource(s.Name()).WithDefaultHeaders(headers)
+	return s, nil
+}
+
+func newApkPureConfigDecoder() ConfigDecoder {
+	return NewConfigDecoderWithDefaults(
+		defaultApkPureConfig(),
+		func(c *ApkPureConfig) {
+			NormalizeBaseSourceConfig(&c.BaseSourceConfig)
+			c.DownloadURL = strings.TrimRight(strings.TrimSpace(c.DownloadURL), "/")
+		},
+		func(c ApkPureConfig) error {
+			if err := ValidateBaseSourceConfig(c.BaseSourceConfig); err != nil {
+				return err
+			}
+			parsed, err := neturl.Parse(c.DownloadURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("download_url %q must be an http(s) URL", c.DownloadURL)
+			}
+			return nil
+		},
+	)
+}
+
+func init() {
+	RegisterSourceFactoryWithConfig(newApkPureSource, "apkpure", newApkPureConfigDecoder())
+}
@@ -0,0 +1,180 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const apkPureSearchHTML = `<html><body><ul class="search-res">
+<li><a class="dd" href="/other-app/com.example.other" data-dt-app="com.example.other">Other</a></li>
+<li><a class="dd" href="/example-app/com.example.app" data-dt-app="com.example.app">Example App</a></li>
+</ul></body></html>`
+
+const apkPureVersionsHTML = `<html><body>
+<div class="info"><a href="/developer/Example%20Inc.">Example Inc.</a></div>
+<ul class="ver-wrap">
+<li><a class="ver_download_link" href="/example-app/com.example.app/download/3.0" data-dt-version="3.0" data-dt-versioncode="30">
+<span class="ver-item-t">XAPK</span><span class="ver-item-s">52.5 MB</span></a></li>
+<li><a class="ver_download_link" href="/example-app/com.example.app/download/2.0" data-dt-version="2.0" data-dt-versioncode="20">
+<span class="ver-item-t">XAPK</span><span class="ver-item-s">40 MB</span></a></li>
+<li><a class="ver_download_link" href="/example-app/com.example.app/download/2.0" data-dt-version="2.0" data-dt-versioncode="20">
+<span class="ver-item-t">APK</span><span class="ver-item-s">38 MB</span></a></li>
+<li><a class="ver_download_link" href="/example-app/com.example.app/download/1.0" data-dt-version="1.0" data-dt-versioncode="10">
+<span class="ver-item-t">APK</span><span class="ver-item-s">20.5 KB</span></a></li>
+</ul></body></html>`
+
+const apkPureDeveloperHTML = `<html><body><ul>
+<li><a href="/example-app/com.example.app" data-dt-app="com.example.app">Example App</a></li>
+<li><a href="/example-app/com.example.app" data-dt-app="com.example.app">Example App</a></li>
+<li><a href="/tool/com.example.tool" data-dt-app="com.example.tool">Example Tool</a></li>
+</ul></body></html>`
+
+func mockApkPure(requests *[]*http.Request) *ApkPure {
+	s := &ApkPure{config: defaultApkPureConfig()}
+	s.Source = s
+	pages := map[string]string{
+		"/search":                               apkPureSearchHTML,
+		"/example-app/com.example.app/versions": apkPureVersionsHTML,
+		"/developer/Example Inc.":               apkPureDeveloperHTML,
+		"/b/APK/com.example.app":                "PK-apk",
+		"/b/XAPK/com.example.app":               "PK-xapk",
+	}
+	s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+		if requests != nil {
+			*requests = append(*requests, req)
+		}
+		page, found := pages[req.URL.Path]
+		if !found {
+			return statusResp(req, http.StatusNotFound), nil
+		}
+		return okResp(req, page), nil
+	})
+	return s
+}
+
+func TestApkPureFindByPackageLatest(t *testing.T) {
+	s := mockApkPure(nil)
+
+	version, err := s.FindByPackage(context.Background(), "com.example.app", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version.Code != 30 || version.Name != "3.0" || version.Type != XAPK {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+	if version.DeveloperId != "Example Inc." {
+		t.Fatalf("unexpected developer: %q", version.DeveloperId)
+	}
+	if version.Size != 55050240 {
+		t.Fatalf("unexpected size: %d", version.Size)
+	}
+	if version.Link != "https://d.apkpure.com/b/XAPK/com.example.app?versionCode=30" {
+		t.Fatalf("unexpected link: %s", version.Link)
+	}
+}
+
+func TestApkPureFindByPackagePinnedPrefersAPK(t *testing.T) {
+	s := mockApkPure(nil)
+
+	version, err := s.FindByPackage(context.Background(), "com.example.app", 20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version.Code != 20 || version.Type != APK {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+
+	var notFound *AppNotFoundError
+	if _, err := s.FindByPackage(context.Background(), "com.example.app", 15); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError for a missing version, got %v", err)
+	}
+	if _, err := s.FindByPackage(context.Background(), "com.missing", 0); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError for a missing package, got %v", err)
+	}
+}
+
+func TestApkPureListVersions(t *testing.T) {
+	s := mockApkPure(nil)
+
+	versions, err := s.ListVersions(context.Background(), "com.example.app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 4 {
+		t.Fatalf("expected 4 versions, got %d", len(versions))
+	}
+	if versions[3].Code != 10 || versions[3].Size != 20992 {
+		t.Fatalf("unexpected oldest version: %+v", versions[3])
+	}
+}
+
+func TestApkPureFindByDeveloper(t *testing.T) {
+	s := mockApkPure(nil)
+
+	packages, err := s.FindByDeveloper(context.Background(), "Example Inc.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packages) != 2 || packages[0] != "com.example.app" || packages[1] != "com.example.tool" {
+		t.Fatalf("unexpected packages: %v", packages)
+	}
+}
+
+func TestApkPureDownload(t *testing.T) {
+	var requests []*http.Request
+	s := mockApkPure(&requests)
+	version, err := s.FindByPackage(context.Background(), "com.example.app", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stream, err := s.Download(context.Background(), version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	body, _ := io.ReadAll(stream.Body)
+	if string(body) != "PK-xapk" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if last := requests[len(requests)-1]; last.URL.Query().Get("versionCode") != "30" {
+		t.Fatalf("unexpected download request: %s", last.URL)
+	}
+}
+
+func TestApkPureConfigDecoder(t *testing.T) {
+	decode := func(text string) (any, error) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(text), &node); err != nil {
+			t.Fatalf("failed to parse yaml: %v", err)
+		}
+		return newApkPureConfigDecoder()(node.Content[0])
+	}
+	decoded, err := decode("base_url: https://mirror.example/\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, ok := decoded.(ApkPureConfig)
+	if !ok || config.BaseURL != "https://mirror.example" || config.DownloadURL != "https://d.apkpure.com" {
+		t.Fatalf("unexpected config: %+v", decoded)
+	}
+	if _, err := decode("download_url: ftp://files.example\n"); err == nil {
+		t.Fatalf("expected error for a non-http download_url")
+	}
+}
+
+func TestParseApkPureSize(t *testing.T) {
+	for text, want := range map[string]uint64{"1 KB": 1024, "1.5 MB": 1572864, "2 GB": 2 << 30} {
+		if got, err := parseApkPureSize(text); err != nil || got != want {
+			t.Errorf("parseApkPureSize(%q) = %d, %v, want %d", text, got, err, want)
+		}
+	}
+	if _, err := parseApkPureSize("12 parsecs"); err == nil {
+		t.Fatalf("expected error for unknown unit")
+	}
+}
@@ -0,0 +1,120 @@
+package sources
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// bundlePart is one APK of a split bundle. SplitID is empty for the base APK.
+// AdvisoryChecksum works like DownloadStream.AdvisoryChecksum.
+type bundlePart struct {
+	SplitID          string
+	URL              string
+	Header           http.Header
+	Checksum         Checksum
+	AdvisoryChecksum Checksum
+}
+
+// Name returns the entry of the part in the .apks file: base.apk or
+// split_<id>.apk.
+func (p bundlePart) Name() string {
+	if p.SplitID == "" {
+		return "base.apk"
+	}
+	return "split_" + p.SplitID + ".apk"
+}
+
+// downloadBundle downloads the parts one after another and streams them as an
+// APKS zip: the APKs and a toc.pb listing them. The size of the result is not
+// known in advance, and it cannot be resumed. Every part with a checksum is
+// verified while it is copied; a mismatch fails the read with
+// *ChecksumMismatchError.
+func (s *BaseSource) downloadBundle(ctx context.Context, packageName string, parts []bundlePart) *DownloadStream {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.writeBundle(ctx, writer, packageName, parts))
+	}()
+	return &DownloadStream{Body: reader, Size: -1}
+}
+
+// bundleTOC encodes the table of contents of the bundle as a bundletool
+// BuildApksResult with a single variant holding the base module.
+func bundleTOC(packageName string, parts []bundlePart) []byte {
+	apkSet := (&protoBuilder{}).message(1, (&protoBuilder{}).string(1, "base"))
+	for _, part := range parts {
+		metadata := (&protoBuilder{}).string(1, part.SplitID).bool(2, part.SplitID == "")
+		apkSet.message(2, (&protoBuilder{}).string(2, part.Name()).message(3, metadata))
+	}
+	variant := (&protoBuilder{}).message(2, apkSet).uint(3, 0)
+	return (&protoBuilder{}).message(1, variant).string(4, packageName).data
+}
+
+func (s *BaseSource) writeBundle(ctx context.Context, w io.Writer, packageName string, parts []bundlePart) error {
+	archive := zip.NewWriter(w)
+	toc, err := archive.Create("toc.pb")
+	if err != nil {
+		return fmt.Errorf("failed to add toc.pb to bundle: %w", err)
+	}
+	if _, err := toc.Write(bundleTOC(packageName, parts)); err != nil {
+		return fmt.Errorf("failed to add toc.pb to bundle: %w", err)
+	}
+	for _, part := range parts {
+		if err := s.writeBundlePart(ctx, archive, packageName, part); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseSource) writeBundlePart(ctx context.Context, archive *zip.Writer, packageName string, part bundlePart) error {
+	req, err := s.NewRequest(ctx, "GET", part.URL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range part.Header {
+		req.Header[key] = values
+	}
+	stream, err := createResponseReader(s.Http(), req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", part.Name(), err)
+	}
+	defer stream.Body.Close()
+
+	// APKs are already compressed, so the parts are stored as they are.
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: part.Name(), Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", part.Name(), err)
+	}
+	checksum, advisory := part.Checksum, false
+	if checksum.IsZero() && !part.AdvisoryChecksum.IsZero() {
+		checksum, advisory = part.AdvisoryChecksum, true
+	}
+	if checksum.IsZero() {
+		if _, err := io.Copy(entry, stream.Body); err != nil {
+			return fmt.Errorf("failed to download %s: %w", part.Name(), err)
+		}
+		return nil
+	}
+	hash, err := checksum.NewHash()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(entry, hash), stream.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", part.Name(), err)
+	}
+	if sum := hash.Sum(nil); !checksum.Matches(sum) {
+		mismatchErr := &ChecksumMismatchError{Path: part.Name(), Expected: checksum, Actual: fmt.Sprintf("%x", sum)}
+		if advisory {
+			s.Log().Logw(fmt.Sprintf("Keeping %s of package %s despite an unconfirmed checksum: %v", part.Name(), packageName, mismatchErr))
+			return nil
+		}
+		return mismatchErr
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package sources
+
+import (
+	"crypto/md5"  //nolint:gosec // G501: some stores only publish MD5 digests
+	"crypto/sha1" //nolint:gosec // G505: some stores only publish SHA-1 digests
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+type ChecksumAlgorithm string
+
+const (
+	MD5    ChecksumAlgorithm = "md5"
+	SHA1   ChecksumAlgorithm = "sha1"
+	SHA256 ChecksumAlgorithm = "sha256"
+)
+
+// Checksum is a file digest published by a source. Digest is lowercase hex.
+// The zero value means the source did not provide one.
+type Checksum struct {
+	Algorithm ChecksumAlgorithm
+	Digest    string
+}
+
+func (c Checksum) IsZero() bool {
+	return c.Algorithm == "" || c.Digest == ""
+}
+
+func (c Checksum) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return string(c.Algorithm) + ":" + c.Digest
+}
+
+// NewHash returns a hash.Hash for the checksum algorithm.
+func (c Checksum) NewHash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case MD5:
+		return md5.New(), nil //nolint:gosec // G401: integrity check against a store-provided MD5
+	case SHA1:
+		return sha1.New(), nil //nolint:gosec // G401: integrity check against a store-provided SHA-1
+	case SHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+}
+
+// Matches reports whether sum, as returned by the hash from NewHash, equals
+// the expected digest.
+func (c Checksum) Matches(sum []byte) bool {
+	return strings.EqualFold(c.Digest, hex.EncodeToString(sum))
+}
+
+// NewChecksum validates a hex digest for the given algorithm.
+func NewChecksum(algorithm ChecksumAlgorithm, digest string) (Checksum, error) {
+	checksum := Checksum{Algorithm: algorithm, Digest: strings.ToLower(strings.TrimSpace(digest))}
+	h, err := checksum.NewHash()
+	if err != nil {
+		return Checksum{}, err
+	}
+	decoded, err := hex.DecodeString(checksum.Digest)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("invalid %s digest %q: %w", algorithm, digest, err)
+	}
+	if len(decoded) != h.Size() {
+		return Checksum{}, fmt.Errorf("invalid %s digest %q: expected %d bytes, got %d", algorithm, digest, h.Size(), len(decoded))
+	}
+	return checksum, nil
+}
+
+// ChecksumFromHex guesses the algorithm of a hex digest from its length. It
+// returns the zero Checksum when the digest is empty or not recognised, so
+// callers can use it on optional API fields.
+func ChecksumFromHex(digest string) Checksum {
+	digest = strings.TrimSpace(digest)
+	var algorithm ChecksumAlgorithm
+	switch len(digest) {
+	case md5.Size * 2:
+		algorithm = MD5
+	case sha1.Size * 2:
+		algorithm = SHA1
+	case sha256.Size * 2:
+		algorithm = SHA256
+	default:
+		return Checksum{}
+	}
+	checksum, err := NewChecksum(algorithm, digest)
+	if err != nil {
+		return Checksum{}
+	}
+	return checksum
+}
@@ -0,0 +1,52 @@
+package sources
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestNewChecksumValidatesDigest(t *testing.T) {
+	checksum, err := NewChecksum(SHA256, "  84D89877F0D4041EFB6BF91A16F0248F2FD573E6AF05C19F96BEDB9F882F7882 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checksum.Digest != "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882" {
+		t.Fatalf("expected normalized digest, got %s", checksum.Digest)
+	}
+	sum := sha256.Sum256([]byte("0123456789"))
+	if !checksum.Matches(sum[:]) {
+		t.Fatal("expected checksum to match")
+	}
+
+	if _, err := NewChecksum(SHA256, "abcd"); err == nil {
+		t.Fatal("expected error for short digest")
+	}
+	if _, err := NewChecksum(MD5, "zz1e5e245d69b566979b86e28d23f2c7"); err == nil {
+		t.Fatal("expected error for non-hex digest")
+	}
+	if _, err := NewChecksum("crc32", "deadbeef"); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
+
+func TestChecksumFromHexGuessesAlgorithm(t *testing.T) {
+	tests := []struct {
+		digest string
+		want   ChecksumAlgorithm
+	}{
+		{"781e5e245d69b566979b86e28d23f2c7", MD5},
+		{"87acec17cd9dcd20a716cc2cf67417b71c8a7016", SHA1},
+		{"84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882", SHA256},
+		{"", ""},
+		{"not-a-digest", ""},
+	}
+	for _, tt := range tests {
+		got := ChecksumFromHex(tt.digest)
+		if got.Algorithm != tt.want {
+			t.Fatalf("ChecksumFromHex(%q) algorithm = %q, want %q", tt.digest, got.Algorithm, tt.want)
+		}
+		if tt.want == "" && !got.IsZero() {
+			t.Fatalf("ChecksumFromHex(%q) expected zero checksum, got %s", tt.digest, got)
+		}
+	}
+}
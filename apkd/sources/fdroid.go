@@ -2,14 +2,15 @@ package sources
 
 import (
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 
-	"github.com/goccy/go-json"
 	"github.com/kiber-io/apkd/apkd/network"
 )
 
@@ -18,13 +19,19 @@ type AppMetadata struct {
 }
 
 type VersionFile struct {
-	Name string `json:"name"`
-	Size uint64 `json:"size"`
+	Name   string `json:"name"`
+	Size   uint64 `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type VersionSigner struct {
+	SHA256 []string `json:"sha256"`
 }
 
 type VersionManifest struct {
-	VersionName string `json:"versionName"`
-	VersionCode int    `json:"versionCode"`
+	VersionName string        `json:"versionName"`
+	VersionCode int           `json:"versionCode"`
+	Signer      VersionSigner `json:"signer"`
 }
 
 type VersionJson struct {
@@ -33,21 +40,28 @@ type VersionJson struct {
 }
 
 type AppInfo struct {
-	PackageName string
+	// PackageName is the key of the package in the index.
+	PackageName string                 `json:"-"`
 	Metadata    AppMetadata            `json:"metadata"`
 	Versions    map[string]VersionJson `json:"versions"`
 }
 
 type FDroid struct {
 	BaseSource
+	// name is the source name of a repository registered with
+	// RegisterFDroidRepo; empty for the main F-Droid repository.
+	name        string
 	config      FDroidConfig
+	indexCache  fdroidIndexCache
 	jsonCacheMu sync.Mutex
-	jsonCache   map[string]any
+	jsonCache   map[string]AppInfo
 }
 
 type FDroidConfig struct {
 	BaseSourceConfig `yaml:",inline"`
 	AppVersion       string `yaml:"appVersion"`
+	// Fingerprint is the sha256 of the repository signing certificate.
+	Fingerprint string `yaml:"fingerprint"`
 }
 
 func defaultFDroidConfig() FDroidConfig {
@@ -55,35 +69,84 @@ func defaultFDroidConfig() FDroidConfig {
 		BaseSourceConfig: BaseSourceConfig{
 			BaseURL: "https://f-droid.org",
 		},
-		AppVersion: "1.23.1",
+		AppVersion:  "1.23.1",
+		Fingerprint: officialFDroidFingerprint,
 	}
 }
 
 func (s *FDroid) Name() string {
+	if s.name != "" {
+		return s.name
+	}
 	return "fdroid"
 }
 
-func (s *FDroid) Download(version Version) (*DownloadStream, error) {
-	req, err := s.NewRequest("GET", s.config.BaseURL+"/repo"+version.Link, nil)
+func (s *FDroid) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *FDroid) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	req, err := s.NewRequest(ctx, "GET", s.config.BaseURL+"/repo"+version.Link, nil)
 	if err != nil {
 		return nil, err
 	}
-	return createResponseReader(s.Http(), req)
+	return createRangeResponseReader(s.Http(), req, byteRange)
 }
 
-func (s *FDroid) getJson() (map[string]any, error) {
+// getJson returns the packages of the repository index. The index is kept on
+// disk and revalidated with a conditional request, so an unchanged index is
+// not downloaded again. When the repository fingerprint is known, the index
+// must match the sha256 in the signed entry.jar.
+func (s *FDroid) getJson(ctx context.Context) (map[string]AppInfo, error) {
 	s.jsonCacheMu.Lock()
 	defer s.jsonCacheMu.Unlock()
 	if s.jsonCache != nil {
 		return s.jsonCache, nil
 	}
 	url := s.config.BaseURL + "/repo/index-v2.json"
+	meta, cached := s.indexCache.meta(url)
+
+	var entry fdroidEntry
+	if s.config.Fingerprint != "" {
+		var err error
+		entry, err = s.getEntry(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cached && entry.Timestamp < meta.EntryTimestamp {
+			return nil, s.verificationError(fmt.Errorf("entry.json is older than the cached index (%d < %d)", entry.Timestamp, meta.EntryTimestamp))
+		}
+		if cached && strings.EqualFold(meta.SHA256, entry.Index.SHA256) {
+			packages, err := s.loadCachedIndex(meta, entry)
+			if err == nil {
+				s.jsonCache = packages
+				return packages, nil
+			}
+			s.Log().Logw(fmt.Sprintf("Ignoring cached fdroid index: %v", err))
+			cached = false
+		} else if cached {
+			// The signed entry points at a newer index, so a stale mirror
+			// must not answer with 304 Not Modified.
+			cached = false
+		}
+	} else {
+		s.Log().Logw(fmt.Sprintf("No fingerprint configured for %s, the index signature is not verified", s.Name()))
+	}
+	expectedSHA256 := strings.ToLower(entry.Index.SHA256)
 
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 
 	if err != nil {
 		return nil, err
 	}
+	if cached {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
 
 	res, err := s.Http().Do(req)
 	if err != nil {
@@ -91,78 +154,102 @@ func (s *FDroid) getJson() (map[string]any, error) {
 	}
 	defer res.Body.Close()
 
-	reader := res.Body
-	if res.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, err := gzip.NewReader(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error creating gzip reader: %w", err)
+	var packages map[string]AppInfo
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached:
+		packages, err = s.loadCachedIndex(meta, entry)
+	case res.StatusCode == http.StatusOK:
+		reader := res.Body
+		if res.Header.Get("Content-Encoding") == "gzip" {
+			gzipReader, err := gzip.NewReader(res.Body)
+			if err != nil {
+				return nil, fmt.Errorf("error creating gzip reader: %w", err)
+			}
+			reader = gzipReader
+			defer reader.Close()
 		}
-		reader = gzipReader
-		defer reader.Close()
-	}
-
-	if res.StatusCode != http.StatusOK {
+		if s.indexCache.enabled() {
+			packages, err = s.indexCache.store(reader, fdroidIndexMeta{
+				URL:            url,
+				ETag:           res.Header.Get("ETag"),
+				LastModified:   res.Header.Get("Last-Modified"),
+				EntryTimestamp: entry.Timestamp,
+			}, expectedSHA256)
+			if err != nil && packages != nil {
+				s.Log().Logw(err.Error())
+				err = nil
+			}
+		} else {
+			packages, _, err = decodeFDroidIndex(reader, expectedSHA256)
+		}
+	default:
 		return nil, fmt.Errorf("error: %s", res.Status)
 	}
-
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+	if errors.Is(err, errFDroidIndexDigest) {
+		return nil, s.verificationError(err)
 	}
-	var jsonData map[string]any
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
-	}
-	packages, ok := jsonData["packages"].(map[string]any)
-	if !ok {
-		return nil, errors.New("invalid JSON format, expected 'packages' object")
+	if err != nil {
+		return nil, err
 	}
 	s.jsonCache = packages
 
 	return packages, nil
 }
 
-func (s *FDroid) getAppInfo(data map[string]any, packageName string) (AppInfo, error) {
-	var appInfo AppInfo
-	for pkgName := range data {
-		if !strings.EqualFold(pkgName, packageName) {
-			continue
-		}
-		jsonBytes, err := json.Marshal(data[pkgName])
-		if err != nil {
-			return appInfo, fmt.Errorf("error encoding package JSON: %w", err)
-		}
-		if err := json.Unmarshal(jsonBytes, &appInfo); err != nil {
-			return appInfo, fmt.Errorf("error decoding package JSON: %w", err)
+// loadCachedIndex decodes the cached index, checking it against the entry
+// when one was verified, and records the entry timestamp.
+func (s *FDroid) loadCachedIndex(meta fdroidIndexMeta, entry fdroidEntry) (map[string]AppInfo, error) {
+	s.Log().Logd("Using cached fdroid index " + s.indexCache.indexPath())
+	packages, err := s.indexCache.load(strings.ToLower(entry.Index.SHA256))
+	if err != nil {
+		return nil, err
+	}
+	if entry.Timestamp > meta.EntryTimestamp {
+		meta.EntryTimestamp = entry.Timestamp
+		if err := s.indexCache.saveMeta(meta); err != nil {
+			s.Log().Logw(err.Error())
 		}
-		appInfo.PackageName = pkgName
+	}
+	return packages, nil
+}
+
+func (s *FDroid) getAppInfo(data map[string]AppInfo, packageName string) (AppInfo, error) {
+	if appInfo, ok := data[packageName]; ok {
 		return appInfo, nil
 	}
+	for pkgName, appInfo := range data {
+		if strings.EqualFold(pkgName, packageName) {
+			return appInfo, nil
+		}
+	}
 
-	return appInfo, &AppNotFoundError{PackageName: packageName}
+	return AppInfo{}, &AppNotFoundError{PackageName: packageName}
 }
 
-func (s *FDroid) findAllPackagesByAuthor(data map[string]any, authorName string) ([]AppInfo, error) {
+func (s *FDroid) findAllPackagesByAuthor(data map[string]AppInfo, authorName string) []AppInfo {
 	var appsInfo []AppInfo
 
-	for pkgName := range data {
-		if metadata, ok := data[pkgName].(map[string]any)["metadata"].(map[string]any); ok {
-			if author, ok := metadata["authorName"].(string); ok && author == authorName {
-				jsonBytes, err := json.Marshal(data[pkgName])
-				if err != nil {
-					return nil, fmt.Errorf("error encoding package JSON: %w", err)
-				}
-				var appInfo AppInfo
-				if err := json.Unmarshal(jsonBytes, &appInfo); err != nil {
-					return nil, fmt.Errorf("error decoding package JSON: %w", err)
-				}
-				appInfo.PackageName = pkgName
-				appsInfo = append(appsInfo, appInfo)
-			}
+	for _, appInfo := range data {
+		if appInfo.Metadata.AuthorName == authorName {
+			appsInfo = append(appsInfo, appInfo)
 		}
 	}
 
-	return appsInfo, nil
+	return appsInfo
+}
+
+func (s *FDroid) newVersion(appInfo AppInfo, remoteVersion VersionJson) Version {
+	return Version{
+		Name:        remoteVersion.Manifest.VersionName,
+		Code:        remoteVersion.Manifest.VersionCode,
+		Size:        remoteVersion.File.Size,
+		Link:        remoteVersion.File.Name,
+		PackageName: appInfo.PackageName,
+		DeveloperId: appInfo.Metadata.AuthorName,
+		Type:        APK,
+		Checksum:    s.fileChecksum(remoteVersion.File),
+		Signers:     remoteVersion.Manifest.Signer.SHA256,
+	}
 }
 
 func (s *FDroid) findNeededVersion(appInfo AppInfo, versionCode int) (Version, error) {
@@ -177,12 +264,7 @@ func (s *FDroid) findNeededVersion(appInfo AppInfo, versionCode int) (Version, e
 			if remoteVersion.Manifest.VersionCode != versionCode {
 				continue
 			}
-			version.Name = remoteVersion.Manifest.VersionName
-			version.Code = remoteVersion.Manifest.VersionCode
-			version.Size = remoteVersion.File.Size
-			version.Link = remoteVersion.File.Name
-			version.PackageName = appInfo.PackageName
-			version.DeveloperId = appInfo.Metadata.AuthorName
+			version = s.newVersion(appInfo, remoteVersion)
 			foundVersion = true
 			break
 		}
@@ -200,22 +282,29 @@ func (s *FDroid) findNeededVersion(appInfo AppInfo, versionCode int) (Version, e
 			if remoteVersion.Manifest.VersionCode != maxVersionCode {
 				continue
 			}
-			version.Name = remoteVersion.Manifest.VersionName
-			version.Code = remoteVersion.Manifest.VersionCode
-			version.Size = remoteVersion.File.Size
-			version.Link = remoteVersion.File.Name
-			version.PackageName = appInfo.PackageName
-			version.DeveloperId = appInfo.Metadata.AuthorName
+			version = s.newVersion(appInfo, remoteVersion)
 			break
 		}
 	}
 	return version, err
 }
 
-func (s *FDroid) FindByPackage(packageName string, versionCode int) (Version, error) {
+func (s *FDroid) fileChecksum(file VersionFile) Checksum {
+	if file.SHA256 == "" {
+		return Checksum{}
+	}
+	checksum, err := NewChecksum(SHA256, file.SHA256)
+	if err != nil {
+		s.Log().Logw(fmt.Sprintf("Ignoring invalid checksum for %s: %v", file.Name, err))
+		return Checksum{}
+	}
+	return checksum
+}
+
+func (s *FDroid) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
 	var version Version
 
-	data, err := s.getJson()
+	data, err := s.getJson(ctx)
 	if err != nil {
 		return version, err
 	}
@@ -226,17 +315,30 @@ func (s *FDroid) FindByPackage(packageName string, versionCode int) (Version, er
 	return s.findNeededVersion(appInfo, versionCode)
 }
 
-func (s *FDroid) FindByDeveloper(developerId string) ([]string, error) {
-	var packages []string
-	var err error
-	data, err := s.getJson()
+func (s *FDroid) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	data, err := s.getJson(ctx)
 	if err != nil {
-		return packages, err
+		return nil, err
 	}
-	appsInfo, err := s.findAllPackagesByAuthor(data, developerId)
+	appInfo, err := s.getAppInfo(data, packageName)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(appInfo.Versions))
+	for _, remoteVersion := range appInfo.Versions {
+		versions = append(versions, s.newVersion(appInfo, remoteVersion))
+	}
+	return versions, nil
+}
+
+func (s *FDroid) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
+	var packages []string
+	var err error
+	data, err := s.getJson(ctx)
 	if err != nil {
 		return packages, err
 	}
+	appsInfo := s.findAllPackagesByAuthor(data, developerId)
 	for _, appInfo := range appsInfo {
 		packages = append(packages, appInfo.PackageName)
 	}
@@ -244,14 +346,23 @@ func (s *FDroid) FindByDeveloper(developerId string) ([]string, error) {
 }
 
 func newFDroidSource() (Source, error) {
-	s := &FDroid{}
+	return newNamedFDroidSource("", defaultFDroidConfig())
+}
+
+func newNamedFDroidSource(name string, defaultConfig FDroidConfig) (Source, error) {
+	s := &FDroid{name: name}
 	s.Source = s
-	config, err := ResolveSourceConfig(s.Name(), defaultFDroidConfig())
+	config, err := ResolveSourceConfig(s.Name(), defaultConfig)
 	if err != nil {
 		return nil, err
 	}
 	s.config = config
 	s.Log().Logd(fmt.Sprintf("Using config: %+v", config))
+	if indexCache, err := defaultFDroidIndexCache(config.BaseURL); err != nil {
+		s.Log().Logw(fmt.Sprintf("Not caching the index on disk: %v", err))
+	} else {
+		s.indexCache = indexCache
+	}
 	headers := ApplyConfiguredHeaders(http.Header{
 		"User-Agent": {"F-Droid " + config.AppVersion},
 	}, config.Headers)
@@ -259,26 +370,97 @@ func newFDroidSource() (Source, error) {
 	return s, nil
 }
 
-func init() {
-	RegisterSourceFactoryWithConfig(newFDroidSource, "fdroid",
-		NewConfigDecoderWithDefaults(
-			defaultFDroidConfig(),
-			func(c *FDroidConfig) {
-				NormalizeBaseSourceConfig(&c.BaseSourceConfig)
-				c.AppVersion = strings.TrimSpace(c.AppVersion)
-			},
-			func(c FDroidConfig) error {
-				if err := ValidateBaseSourceConfig(c.BaseSourceConfig); err != nil {
-					return err
-				}
-				if strings.TrimSpace(c.AppVersion) == "" {
-					return errors.New("appVersion cannot be empty")
-				}
-				if !appVersionRegexp.MatchString(c.AppVersion) {
-					return fmt.Errorf("appVersion %q is invalid", c.AppVersion)
-				}
-				return nil
-			},
-		),
+func newFDroidConfigDecoder(defaultConfig FDroidConfig) ConfigDecoder {
+	return NewConfigDecoderWithDefaults(
+		defaultConfig,
+		func(c *FDroidConfig) {
+			NormalizeBaseSourceConfig(&c.BaseSourceConfig)
+			c.AppVersion = strings.TrimSpace(c.AppVersion)
+			c.Fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(c.Fingerprint), ":", ""))
+		},
+		func(c FDroidConfig) error {
+			if err := ValidateBaseSourceConfig(c.BaseSourceConfig); err != nil {
+				return err
+			}
+			if strings.TrimSpace(c.AppVersion) == "" {
+				return errors.New("appVersion cannot be empty")
+			}
+			if !appVersionRegexp.MatchString(c.AppVersion) {
+				return fmt.Errorf("appVersion %q is invalid", c.AppVersion)
+			}
+			return validateFDroidFingerprint(c.Fingerprint)
+		},
 	)
 }
+
+func validateFDroidFingerprint(fingerprint string) error {
+	if fingerprint == "" {
+		return nil
+	}
+	if !fdroidFingerprintRegexp.MatchString(fingerprint) {
+		return fmt.Errorf("fingerprint %q is not a sha256 hex digest", fingerprint)
+	}
+	return nil
+}
+
+var fdroidFingerprintRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var fdroidRepoNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// FDroidRepo is an F-Droid-compatible repository, such as IzzyOnDroid, that
+// publishes index-v2.json.
+type FDroidRepo struct {
+	BaseURL string
+	// Fingerprint is the sha256 of the repository signing certificate. It
+	// may be empty.
+	Fingerprint string
+}
+
+var fdroidReposMu sync.Mutex
+var fdroidRepos = make(map[string]FDroidRepo)
+
+// RegisterFDroidRepo registers the repository as a source with the given
+// name. It must be called before InitializeRegisteredSources. Registering the
+// same repository under the same name again does nothing.
+func RegisterFDroidRepo(name string, repo FDroidRepo) error {
+	name = normalizeSourceName(name)
+	if !fdroidRepoNameRegexp.MatchString(name) {
+		return fmt.Errorf("repository name %q is invalid, use lowercase letters, digits, '-' and '_'", name)
+	}
+	repo.BaseURL = strings.TrimRight(strings.TrimSpace(repo.BaseURL), "/")
+	baseURL, err := url.Parse(repo.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return fmt.Errorf("base_url %q must be an http(s) URL", repo.BaseURL)
+	}
+	repo.Fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(repo.Fingerprint), ":", ""))
+	if err := validateFDroidFingerprint(repo.Fingerprint); err != nil {
+		return err
+	}
+
+	fdroidReposMu.Lock()
+	defer fdroidReposMu.Unlock()
+	if registered, exists := fdroidRepos[name]; exists {
+		if registered == repo {
+			return nil
+		}
+		return fmt.Errorf("repository %s is already registered with base_url %s", name, registered.BaseURL)
+	}
+	if len(sources) > 0 {
+		return errors.New("sources are already initialized")
+	}
+	defaultConfig := defaultFDroidConfig()
+	defaultConfig.BaseURL = repo.BaseURL
+	defaultConfig.Fingerprint = repo.Fingerprint
+	if err := RegisterSourceConfigDecoder(name, newFDroidConfigDecoder(defaultConfig)); err != nil {
+		return fmt.Errorf("source %s is already registered", name)
+	}
+	RegisterSourceFactory(func() (Source, error) {
+		return newNamedFDroidSource(name, defaultConfig)
+	})
+	fdroidRepos[name] = repo
+	return nil
+}
+
+func init() {
+	RegisterSourceFactoryWithConfig(newFDroidSource, "fdroid", newFDroidConfigDecoder(defaultFDroidConfig()))
+}
@@ -0,0 +1,260 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goccy/go-json"
+	"github.com/kiber-io/apkd/apkd/apksig"
+)
+
+const (
+	fdroidIndexFileName     = "index-v2.json"
+	fdroidIndexMetaFileName = "index-v2.meta.json"
+)
+
+// officialFDroidFingerprint is the sha256 of the f-droid.org repository
+// signing certificate.
+const officialFDroidFingerprint = "43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab"
+
+// maxFDroidEntrySize bounds entry.jar, which only holds entry.json and its
+// signature.
+const maxFDroidEntrySize = 16 << 20
+
+var errFDroidIndexDigest = errors.New("index sha256 does not match entry.json")
+
+// fdroidIndex is the part of index-v2.json apkd uses.
+type fdroidIndex struct {
+	Packages map[string]AppInfo `json:"packages"`
+}
+
+// fdroidEntry is entry.json, the signed pointer to the current index.
+type fdroidEntry struct {
+	Timestamp int64 `json:"timestamp"`
+	Index     struct {
+		Name   string `json:"name"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	} `json:"index"`
+}
+
+// IndexVerificationError is returned when the repository index is not signed
+// by the pinned certificate or does not match the signed entry.
+type IndexVerificationError struct {
+	Source string
+	Err    error
+}
+
+func (e *IndexVerificationError) Error() string {
+	return fmt.Sprintf("%s index verification failed: %v", e.Source, e.Err)
+}
+
+func (e *IndexVerificationError) Unwrap() error {
+	return e.Err
+}
+
+// fdroidIndexMeta describes the cached index: the validators sent back as
+// If-None-Match and If-Modified-Since, its sha256 and the timestamp of the
+// entry it was verified against.
+type fdroidIndexMeta struct {
+	URL            string `json:"url"`
+	ETag           string `json:"etag,omitempty"`
+	LastModified   string `json:"last_modified,omitempty"`
+	SHA256         string `json:"sha256,omitempty"`
+	EntryTimestamp int64  `json:"entry_timestamp,omitempty"`
+}
+
+// fdroidIndexCache is the on-disk copy of one repository's index. The zero
+// value disables caching.
+type fdroidIndexCache struct {
+	dir string
+}
+
+// defaultFDroidIndexCache returns the cache of the repository at baseURL,
+// kept under the user cache directory.
+func defaultFDroidIndexCache(baseURL string) (fdroidIndexCache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return fdroidIndexCache{}, err
+	}
+	sum := sha256.Sum256([]byte(baseURL))
+	dir := filepath.Join(cacheDir, "apkd", "fdroid", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fdroidIndexCache{}, err
+	}
+	return fdroidIndexCache{dir: dir}, nil
+}
+
+func (c fdroidIndexCache) enabled() bool {
+	return c.dir != ""
+}
+
+func (c fdroidIndexCache) indexPath() string {
+	return filepath.Join(c.dir, fdroidIndexFileName)
+}
+
+func (c fdroidIndexCache) metaPath() string {
+	return filepath.Join(c.dir, fdroidIndexMetaFileName)
+}
+
+// meta returns the description of the cached index of url. ok is false when
+// there is no cached copy.
+func (c fdroidIndexCache) meta(url string) (meta fdroidIndexMeta, ok bool) {
+	if !c.enabled() {
+		return meta, false
+	}
+	data, err := os.ReadFile(c.metaPath())
+	if err != nil {
+		return meta, false
+	}
+	if err := json.Unmarshal(data, &meta); err != nil || meta.URL != url {
+		return fdroidIndexMeta{}, false
+	}
+	if _, err := os.Stat(c.indexPath()); err != nil {
+		return fdroidIndexMeta{}, false
+	}
+	return meta, true
+}
+
+// load decodes the cached index. When expectedSHA256 is set, the cached copy
+// must match it.
+func (c fdroidIndexCache) load(expectedSHA256 string) (map[string]AppInfo, error) {
+	file, err := os.Open(c.indexPath())
+	if err != nil {
+		return nil, fmt.Errorf("error opening cached fdroid index: %w", err)
+	}
+	defer file.Close()
+	packages, _, err := decodeFDroidIndex(file, expectedSHA256)
+	return packages, err
+}
+
+// store decodes the index from r while writing it to the cache. The cached
+// copy only replaces the previous one once the whole index has been decoded
+// and, when expectedSHA256 is set, verified. When only saving the copy
+// fails, the decoded packages are returned along with the error.
+func (c fdroidIndexCache) store(r io.Reader, meta fdroidIndexMeta, expectedSHA256 string) (map[string]AppInfo, error) {
+	tmp, err := os.CreateTemp(c.dir, fdroidIndexFileName+".*.tmp")
+	if err != nil {
+		packages, _, decodeErr := decodeFDroidIndex(r, expectedSHA256)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		return packages, fmt.Errorf("error creating cached fdroid index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	packages, digest, err := decodeFDroidIndex(io.TeeReader(r, tmp), expectedSHA256)
+	closeErr := tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return packages, fmt.Errorf("error saving cached fdroid index: %w", closeErr)
+	}
+	// A stale meta file must not describe the new index if the rename below
+	// succeeds but writing the new meta fails.
+	_ = os.Remove(c.metaPath())
+	if err := os.Rename(tmp.Name(), c.indexPath()); err != nil {
+		return packages, fmt.Errorf("error saving cached fdroid index: %w", err)
+	}
+	meta.SHA256 = digest
+	if err := c.saveMeta(meta); err != nil {
+		return packages, err
+	}
+	return packages, nil
+}
+
+func (c fdroidIndexCache) saveMeta(meta fdroidIndexMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("error encoding fdroid cache metadata: %w", err)
+	}
+	if err := os.WriteFile(c.metaPath(), data, 0o644); err != nil {
+		return fmt.Errorf("error saving fdroid cache metadata: %w", err)
+	}
+	return nil
+}
+
+// decodeFDroidIndex decodes the index and returns it with the sha256 of the
+// data read. When expectedSHA256 is set, a different digest is an
+// errFDroidIndexDigest error.
+func decodeFDroidIndex(r io.Reader, expectedSHA256 string) (map[string]AppInfo, string, error) {
+	hasher := sha256.New()
+	reader := io.TeeReader(r, hasher)
+	var index fdroidIndex
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return nil, "", fmt.Errorf("error decoding fdroid index: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, "", fmt.Errorf("error reading fdroid index: %w", err)
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if expectedSHA256 != "" && digest != expectedSHA256 {
+		return nil, "", fmt.Errorf("%w: got %s, expected %s", errFDroidIndexDigest, digest, expectedSHA256)
+	}
+	if index.Packages == nil {
+		return nil, "", errors.New("invalid JSON format, expected 'packages' object")
+	}
+	for packageName, appInfo := range index.Packages {
+		appInfo.PackageName = packageName
+		index.Packages[packageName] = appInfo
+	}
+	return index.Packages, digest, nil
+}
+
+// getEntry downloads entry.jar and returns entry.json after checking that the
+// jar is signed by the configured fingerprint.
+func (s *FDroid) getEntry(ctx context.Context) (fdroidEntry, error) {
+	var entry fdroidEntry
+	req, err := s.NewRequest(ctx, "GET", s.config.BaseURL+"/repo/entry.jar", nil)
+	if err != nil {
+		return entry, err
+	}
+	res, err := s.Http().Do(req)
+	if err != nil {
+		return entry, fmt.Errorf("failed to fetch fdroid entry: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return entry, fmt.Errorf("failed to fetch fdroid entry: %s", res.Status)
+	}
+	reader, err := unpackResponse(res)
+	if err != nil {
+		return entry, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxFDroidEntrySize+1))
+	if err != nil {
+		return entry, fmt.Errorf("failed to read fdroid entry: %w", err)
+	}
+	if len(data) > maxFDroidEntrySize {
+		return entry, s.verificationError(errors.New("entry.jar is too large"))
+	}
+
+	entryJSON, signer, err := apksig.VerifyJarEntry(bytes.NewReader(data), int64(len(data)), "entry.json")
+	if err != nil {
+		return entry, s.verificationError(err)
+	}
+	if signer != s.config.Fingerprint {
+		return entry, s.verificationError(fmt.Errorf("entry.jar is signed by %s, expected %s", signer, s.config.Fingerprint))
+	}
+	if err := json.Unmarshal(entryJSON, &entry); err != nil {
+		return entry, s.verificationError(fmt.Errorf("invalid entry.json: %w", err))
+	}
+	if entry.Index.SHA256 == "" {
+		return entry, s.verificationError(errors.New("entry.json has no index sha256"))
+	}
+	return entry, nil
+}
+
+func (s *FDroid) verificationError(err error) error {
+	return &IndexVerificationError{Source: s.Name(), Err: err}
+}
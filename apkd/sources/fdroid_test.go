@@ -1,38 +1,46 @@
 package sources
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"strings"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
-func testFDroidData() map[string]any {
-	return map[string]any{
-		"Com.Example.App": map[string]any{
-			"metadata": map[string]any{
-				"authorName": "Acme",
-			},
-			"versions": map[string]any{
-				"stable": map[string]any{
-					"file": map[string]any{
-						"name": "example-v1.apk",
-						"size": 10,
-					},
-					"manifest": map[string]any{
-						"versionName": "1.0.0",
-						"versionCode": 1,
-					},
+func testFDroidData() map[string]AppInfo {
+	return map[string]AppInfo{
+		"Com.Example.App": {
+			PackageName: "Com.Example.App",
+			Metadata:    AppMetadata{AuthorName: "Acme"},
+			Versions: map[string]VersionJson{
+				"stable": {
+					File:     VersionFile{Name: "example-v1.apk", Size: 10},
+					Manifest: VersionManifest{VersionName: "1.0.0", VersionCode: 1},
 				},
 			},
 		},
-		"com.other.app": map[string]any{
-			"metadata": map[string]any{
-				"authorName": "Other",
-			},
-			"versions": map[string]any{},
+		"com.other.app": {
+			PackageName: "com.other.app",
+			Metadata:    AppMetadata{AuthorName: "Other"},
+			Versions:    map[string]VersionJson{},
 		},
 	}
 }
@@ -65,10 +73,7 @@ func TestFDroidGetAppInfoNotFound(t *testing.T) {
 
 func TestFDroidFindAllPackagesByAuthor(t *testing.T) {
 	s := &FDroid{}
-	apps, err := s.findAllPackagesByAuthor(testFDroidData(), "Acme")
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
+	apps := s.findAllPackagesByAuthor(testFDroidData(), "Acme")
 	if len(apps) != 1 {
 		t.Fatalf("expected exactly 1 app, got %d", len(apps))
 	}
@@ -91,10 +96,11 @@ func TestFDroidFindNeededVersion(t *testing.T) {
 				},
 			},
 			"v2": {
-				File: VersionFile{Name: "example-v2.apk", Size: 20},
+				File: VersionFile{Name: "example-v2.apk", Size: 20, SHA256: "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"},
 				Manifest: VersionManifest{
 					VersionName: "2.0.0",
 					VersionCode: 2,
+					Signer:      VersionSigner{SHA256: []string{"43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab"}},
 				},
 			},
 		},
@@ -110,6 +116,12 @@ func TestFDroidFindNeededVersion(t *testing.T) {
 	if latest.Type != APK {
 		t.Fatalf("expected file type APK, got %q", latest.Type)
 	}
+	if latest.Checksum.String() != "sha256:84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882" {
+		t.Fatalf("unexpected checksum: %q", latest.Checksum)
+	}
+	if len(latest.Signers) != 1 || latest.Signers[0] != "43238d512c1e5eb2d6569f4a3afbf5523418b82e0a3ed1552770abb9a9c9ccab" {
+		t.Fatalf("unexpected signers: %v", latest.Signers)
+	}
 
 	v1, err := s.findNeededVersion(appInfo, 1)
 	if err != nil {
@@ -139,6 +151,30 @@ func TestFDroidFindNeededVersionNotFound(t *testing.T) {
 	}
 }
 
+func TestFDroidListVersions(t *testing.T) {
+	s := &FDroid{}
+	data := testFDroidData()
+	data["Com.Example.App"].Versions["beta"] = VersionJson{
+		File:     VersionFile{Name: "example-v2.apk", Size: 20},
+		Manifest: VersionManifest{VersionName: "2.0.0-beta", VersionCode: 2},
+	}
+	s.jsonCache = data
+
+	versions, err := ListVersions(context.Background(), s, "com.example.app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %+v", versions)
+	}
+	if versions[0].Code != 2 || versions[0].Name != "2.0.0-beta" || versions[1].Code != 1 {
+		t.Fatalf("expected versions sorted newest first, got %+v", versions)
+	}
+	if versions[1].DeveloperId != "Acme" || versions[1].Link != "example-v1.apk" || versions[1].Type != APK {
+		t.Fatalf("unexpected version metadata: %+v", versions[1])
+	}
+}
+
 func TestFDroidGetJsonClosesBodyOnSuccess(t *testing.T) {
 	body := &trackingReadCloser{
 		Reader: strings.NewReader(`{"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{}}}}`),
@@ -155,7 +191,7 @@ func TestFDroidGetJsonClosesBodyOnSuccess(t *testing.T) {
 		}, nil
 	})
 
-	_, err := s.getJson()
+	_, err := s.getJson(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected getJson error: %v", err)
 	}
@@ -180,7 +216,7 @@ func TestFDroidGetJsonClosesBodyOnNon200(t *testing.T) {
 		}, nil
 	})
 
-	_, err := s.getJson()
+	_, err := s.getJson(context.Background())
 	if err == nil {
 		t.Fatalf("expected getJson error for non-200 response")
 	}
@@ -195,15 +231,16 @@ func TestFDroidGetJsonCachesResult(t *testing.T) {
 	s := &FDroid{}
 	s.Source = s
 	s.config = defaultFDroidConfig()
+	s.config.Fingerprint = ""
 	s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
 		calls++
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
 	})
 
-	if _, err := s.getJson(); err != nil {
+	if _, err := s.getJson(context.Background()); err != nil {
 		t.Fatalf("first getJson error: %v", err)
 	}
-	if _, err := s.getJson(); err != nil {
+	if _, err := s.getJson(context.Background()); err != nil {
 		t.Fatalf("second getJson error: %v", err)
 	}
 	if calls != 1 {
@@ -211,6 +248,98 @@ func TestFDroidGetJsonCachesResult(t *testing.T) {
 	}
 }
 
+func TestFDroidGetJsonDecodesPackages(t *testing.T) {
+	const body = `{"repo":{},"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{"abc":{"file":{"name":"/com.example.app_3.apk","size":30},"manifest":{"versionName":"3.0","versionCode":3}}}}}}`
+	s := &FDroid{}
+	s.Source = s
+	s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+
+	data, err := s.getJson(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected getJson error: %v", err)
+	}
+	appInfo, ok := data["com.example.app"]
+	if !ok {
+		t.Fatalf("expected com.example.app in index, got %+v", data)
+	}
+	if appInfo.PackageName != "com.example.app" || appInfo.Metadata.AuthorName != "Acme" {
+		t.Fatalf("unexpected app info: %+v", appInfo)
+	}
+	if version := appInfo.Versions["abc"]; version.Manifest.VersionCode != 3 || version.File.Size != 30 {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+}
+
+func TestFDroidGetJsonRevalidatesDiskCache(t *testing.T) {
+	const body = `{"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{}}}}`
+	cache := fdroidIndexCache{dir: t.TempDir()}
+	newSource := func(doer doerFunc) *FDroid {
+		s := &FDroid{indexCache: cache}
+		s.Source = s
+		s.config = defaultFDroidConfig()
+		s.config.Fingerprint = ""
+		s.Net = doer
+		return s
+	}
+
+	first := newSource(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") != "" {
+			t.Fatalf("unexpected conditional request without a cached index")
+		}
+		header := http.Header{"Etag": {`"v1"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	if _, err := first.getJson(context.Background()); err != nil {
+		t.Fatalf("first getJson error: %v", err)
+	}
+
+	var ifNoneMatch, ifModifiedSince string
+	second := newSource(func(req *http.Request) (*http.Response, error) {
+		ifNoneMatch = req.Header.Get("If-None-Match")
+		ifModifiedSince = req.Header.Get("If-Modified-Since")
+		return &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	data, err := second.getJson(context.Background())
+	if err != nil {
+		t.Fatalf("second getJson error: %v", err)
+	}
+	if ifNoneMatch != `"v1"` || ifModifiedSince != "Mon, 02 Jan 2006 15:04:05 GMT" {
+		t.Fatalf("unexpected validators: If-None-Match=%q If-Modified-Since=%q", ifNoneMatch, ifModifiedSince)
+	}
+	if data["com.example.app"].Metadata.AuthorName != "Acme" {
+		t.Fatalf("expected index loaded from disk cache, got %+v", data)
+	}
+}
+
+func TestFDroidGetJsonReplacesStaleDiskCache(t *testing.T) {
+	cache := fdroidIndexCache{dir: t.TempDir()}
+	for i, author := range []string{"Old", "New"} {
+		body := `{"packages":{"com.example.app":{"metadata":{"authorName":"` + author + `"},"versions":{}}}}`
+		s := &FDroid{indexCache: cache}
+		s.Source = s
+		s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+			header := http.Header{"Etag": {fmt.Sprintf(`"v%d"`, i)}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		})
+		if _, err := s.getJson(context.Background()); err != nil {
+			t.Fatalf("getJson error: %v", err)
+		}
+	}
+
+	data, err := cache.load("")
+	if err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+	if data["com.example.app"].Metadata.AuthorName != "New" {
+		t.Fatalf("expected cached index to be replaced, got %+v", data)
+	}
+	if meta, ok := cache.meta("/repo/index-v2.json"); !ok || meta.ETag != `"v1"` {
+		t.Fatalf("unexpected cache metadata: %+v (ok=%v)", meta, ok)
+	}
+}
+
 func TestFDroidDownloadConstructsURL(t *testing.T) {
 	const customBase = "https://custom.fdroid.example"
 	const link = "/com.example_10.apk"
@@ -223,7 +352,7 @@ func TestFDroidDownloadConstructsURL(t *testing.T) {
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("data")), ContentLength: 4, Request: req}, nil
 	})
 
-	stream, err := s.Download(Version{Link: link})
+	stream, err := s.Download(context.Background(), Version{Link: link})
 	if err != nil {
 		t.Fatalf("unexpected download error: %v", err)
 	}
@@ -233,6 +362,319 @@ func TestFDroidDownloadConstructsURL(t *testing.T) {
 	}
 }
 
+type testRepoSigner struct {
+	key         *rsa.PrivateKey
+	certificate []byte
+	fingerprint string
+}
+
+func newTestRepoSigner(t *testing.T) testRepoSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "repo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	sum := sha256.Sum256(der)
+	return testRepoSigner{key: key, certificate: der, fingerprint: hex.EncodeToString(sum[:])}
+}
+
+// entryJar returns an entry.jar pointing at index, signed with SHA256withRSA
+// the way apksigner signs F-Droid repositories.
+func (s testRepoSigner) entryJar(t *testing.T, timestamp int64, index string) []byte {
+	t.Helper()
+	b64 := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	marshal := func(value any) []byte {
+		data, err := asn1.Marshal(value)
+		if err != nil {
+			t.Fatalf("failed to marshal asn1: %v", err)
+		}
+		return data
+	}
+	indexSum := sha256.Sum256([]byte(index))
+	entry := []byte(fmt.Sprintf(`{"timestamp":%d,"version":20001,"index":{"name":"/index-v2.json","sha256":"%x","size":%d}}`, timestamp, indexSum, len(index)))
+	manifest := []byte("Manifest-Version: 1.0\r\n\r\nName: entry.json\r\nSHA-256-Digest: " + b64(entry) + "\r\n\r\n")
+	signatureFile := []byte("Signature-Version: 1.0\r\nSHA-256-Digest-Manifest: " + b64(manifest) + "\r\n\r\n")
+	signatureSum := sha256.Sum256(signatureFile)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, signatureSum[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(s.certificate)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	sha256Algorithm := marshal(pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, Parameters: asn1.NullRawValue})
+	rsaAlgorithm := marshal(pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, Parameters: asn1.NullRawValue})
+	signerInfo := marshal(struct {
+		Version              int
+		IssuerAndSerial      struct{ Issuer, SerialNumber asn1.RawValue }
+		DigestAlgorithm      asn1.RawValue
+		DigestEncryptionAlgo asn1.RawValue
+		EncryptedDigest      []byte
+	}{
+		Version: 1,
+		IssuerAndSerial: struct{ Issuer, SerialNumber asn1.RawValue }{
+			Issuer:       asn1.RawValue{FullBytes: certificate.RawIssuer},
+			SerialNumber: asn1.RawValue{FullBytes: marshal(certificate.SerialNumber)},
+		},
+		DigestAlgorithm:      asn1.RawValue{FullBytes: sha256Algorithm},
+		DigestEncryptionAlgo: asn1.RawValue{FullBytes: rsaAlgorithm},
+		EncryptedDigest:      signature,
+	})
+	set := func(content []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: content}
+	}
+	signedData := marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ Type asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: set(sha256Algorithm),
+		ContentInfo:      struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.certificate},
+		SignerInfos:      set(signerInfo),
+	})
+	block := marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/MANIFEST.MF", manifest},
+		{"META-INF/REPO.SF", signatureFile},
+		{"META-INF/REPO.RSA", block},
+		{"entry.json", entry},
+	} {
+		w, err := writer.Create(file.name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write(file.data); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newVerifiedFDroid returns a source pinned to signer that serves entryJar
+// and index, counting the index requests.
+func newVerifiedFDroid(signer testRepoSigner, cache fdroidIndexCache, entryJar []byte, index string, indexRequests *int) *FDroid {
+	s := &FDroid{indexCache: cache}
+	s.Source = s
+	s.config = defaultFDroidConfig()
+	s.config.Fingerprint = signer.fingerprint
+	s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+		body := index
+		if strings.HasSuffix(req.URL.Path, "/entry.jar") {
+			body = string(entryJar)
+		} else {
+			*indexRequests++
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	return s
+}
+
+func TestFDroidGetJsonVerifiesSignedEntry(t *testing.T) {
+	const index = `{"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{}}}}`
+	signer := newTestRepoSigner(t)
+	cache := fdroidIndexCache{dir: t.TempDir()}
+	entryJar := signer.entryJar(t, 100, index)
+
+	indexRequests := 0
+	data, err := newVerifiedFDroid(signer, cache, entryJar, index, &indexRequests).getJson(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected getJson error: %v", err)
+	}
+	if data["com.example.app"].Metadata.AuthorName != "Acme" || indexRequests != 1 {
+		t.Fatalf("unexpected index %+v after %d requests", data, indexRequests)
+	}
+
+	// The cached index matches the signed entry, so it is not requested again.
+	if _, err := newVerifiedFDroid(signer, cache, entryJar, index, &indexRequests).getJson(context.Background()); err != nil {
+		t.Fatalf("unexpected getJson error with cached index: %v", err)
+	}
+	if indexRequests != 1 {
+		t.Fatalf("expected the cached index to be used, got %d index requests", indexRequests)
+	}
+}
+
+func TestFDroidGetJsonRequestsNewSignedIndexUnconditionally(t *testing.T) {
+	const oldIndex = `{"packages":{"com.example.app":{"metadata":{"authorName":"Old"},"versions":{}}}}`
+	const newIndex = `{"packages":{"com.example.app":{"metadata":{"authorName":"New"},"versions":{}}}}`
+	signer := newTestRepoSigner(t)
+	cache := fdroidIndexCache{dir: t.TempDir()}
+	indexRequests := 0
+	// staleMirror serves the index with a fixed ETag and answers every
+	// conditional request with 304 Not Modified.
+	staleMirror := func(s *FDroid) *FDroid {
+		fetch := s.Net
+		s.Net = doerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+				return &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+			}
+			res, err := fetch.Do(req)
+			if err == nil {
+				res.Header.Set("ETag", `"v1"`)
+			}
+			return res, err
+		})
+		return s
+	}
+	if _, err := staleMirror(newVerifiedFDroid(signer, cache, signer.entryJar(t, 100, oldIndex), oldIndex, &indexRequests)).getJson(context.Background()); err != nil {
+		t.Fatalf("unexpected getJson error: %v", err)
+	}
+
+	s := staleMirror(newVerifiedFDroid(signer, cache, signer.entryJar(t, 200, newIndex), newIndex, &indexRequests))
+	data, err := s.getJson(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected getJson error: %v", err)
+	}
+	if data["com.example.app"].Metadata.AuthorName != "New" || indexRequests != 2 {
+		t.Fatalf("expected the new index to be downloaded, got %+v after %d requests", data, indexRequests)
+	}
+}
+
+func TestFDroidGetJsonRefusesUnverifiedIndex(t *testing.T) {
+	const index = `{"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{}}}}`
+	signer := newTestRepoSigner(t)
+	for name, tc := range map[string]struct {
+		fingerprint string
+		entryJar    []byte
+		index       string
+	}{
+		"wrong fingerprint": {fingerprint: newTestRepoSigner(t).fingerprint, entryJar: signer.entryJar(t, 100, index), index: index},
+		"tampered index":    {fingerprint: signer.fingerprint, entryJar: signer.entryJar(t, 100, index), index: strings.Replace(index, "Acme", "Evil", 1)},
+		"unsigned entry":    {fingerprint: signer.fingerprint, entryJar: []byte("not a jar"), index: index},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache := fdroidIndexCache{dir: t.TempDir()}
+			indexRequests := 0
+			s := newVerifiedFDroid(signer, cache, tc.entryJar, tc.index, &indexRequests)
+			s.config.Fingerprint = tc.fingerprint
+			_, err := s.getJson(context.Background())
+			var verificationErr *IndexVerificationError
+			if !errors.As(err, &verificationErr) {
+				t.Fatalf("expected IndexVerificationError, got %v", err)
+			}
+			if _, cached := cache.meta(s.config.BaseURL + "/repo/index-v2.json"); cached {
+				t.Fatalf("expected an unverified index not to be cached")
+			}
+		})
+	}
+}
+
+func TestFDroidGetJsonRefusesOlderEntry(t *testing.T) {
+	const index = `{"packages":{"com.example.app":{"metadata":{"authorName":"Acme"},"versions":{}}}}`
+	signer := newTestRepoSigner(t)
+	cache := fdroidIndexCache{dir: t.TempDir()}
+	indexRequests := 0
+	if _, err := newVerifiedFDroid(signer, cache, signer.entryJar(t, 200, index), index, &indexRequests).getJson(context.Background()); err != nil {
+		t.Fatalf("unexpected getJson error: %v", err)
+	}
+
+	_, err := newVerifiedFDroid(signer, cache, signer.entryJar(t, 100, index), index, &indexRequests).getJson(context.Background())
+	var verificationErr *IndexVerificationError
+	if !errors.As(err, &verificationErr) || !strings.Contains(err.Error(), "older") {
+		t.Fatalf("expected rollback to be refused, got %v", err)
+	}
+}
+
+func TestRegisterFDroidRepo(t *testing.T) {
+	oldFactories := sourceFactories
+	t.Cleanup(func() {
+		sourceFactories = oldFactories
+	})
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	const fingerprint = "3d:c8:98:0f:a5:b7:2e:5e:d3:a8:3d:54:2b:ad:8f:6b:9d:c4:7e:d4:1e:05:0c:a2:5e:95:5b:85:b2:14:9d:11"
+	repo := FDroidRepo{BaseURL: "https://apt.izzysoft.de/fdroid/", Fingerprint: fingerprint}
+	if err := RegisterFDroidRepo("TestIzzy", repo); err != nil {
+		t.Fatalf("unexpected register error: %v", err)
+	}
+	if err := RegisterFDroidRepo("testizzy", repo); err != nil {
+		t.Fatalf("expected registering the same repository again to succeed, got %v", err)
+	}
+	if err := RegisterFDroidRepo("testizzy", FDroidRepo{BaseURL: "https://example.com/fdroid"}); err == nil {
+		t.Fatalf("expected error for a different repository under the same name")
+	}
+	if len(sourceFactories) != len(oldFactories)+1 {
+		t.Fatalf("expected exactly one source factory to be registered")
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(`{headers: {X-Test: "1"}}`), &node); err != nil {
+		t.Fatalf("failed to unmarshal yaml node: %v", err)
+	}
+	configAny, err := DecodeSourceConfig("testizzy", node.Content[0])
+	if err != nil {
+		t.Fatalf("unexpected config decode error: %v", err)
+	}
+	config, ok := configAny.(FDroidConfig)
+	if !ok {
+		t.Fatalf("unexpected config type: %T", configAny)
+	}
+	if config.BaseURL != "https://apt.izzysoft.de/fdroid" || config.Headers["X-Test"] != "1" {
+		t.Fatalf("expected repository defaults with configured headers, got %+v", config)
+	}
+	if config.Fingerprint != strings.ReplaceAll(fingerprint, ":", "") {
+		t.Fatalf("unexpected fingerprint: %q", config.Fingerprint)
+	}
+
+	src, err := sourceFactories[len(sourceFactories)-1]()
+	if err != nil {
+		t.Fatalf("unexpected factory error: %v", err)
+	}
+	if src.Name() != "testizzy" {
+		t.Fatalf("expected source name %q, got %q", "testizzy", src.Name())
+	}
+	if src.(*FDroid).config.BaseURL != "https://apt.izzysoft.de/fdroid" {
+		t.Fatalf("unexpected source config: %+v", src.(*FDroid).config)
+	}
+}
+
+func TestRegisterFDroidRepoRejectsInvalidRepos(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		repo FDroidRepo
+	}{
+		{name: "fdroid", repo: FDroidRepo{BaseURL: "https://f-droid.org"}},
+		{name: "bad name", repo: FDroidRepo{BaseURL: "https://example.com/fdroid"}},
+		{name: "testnoscheme", repo: FDroidRepo{BaseURL: "example.com/fdroid"}},
+		{name: "testbadfingerprint", repo: FDroidRepo{BaseURL: "https://example.com/fdroid", Fingerprint: "abcd"}},
+	} {
+		if err := RegisterFDroidRepo(tc.name, tc.repo); err == nil {
+			t.Fatalf("expected error registering %q with %+v", tc.name, tc.repo)
+		}
+	}
+}
+
 func TestFDroidIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping network integration test")
@@ -243,7 +685,7 @@ func TestFDroidIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create source: %v", err)
 	}
-	v, err := src.FindByPackage("org.fdroid.fdroid", 0)
+	v, err := src.FindByPackage(context.Background(), "org.fdroid.fdroid", 0)
 	if err != nil {
 		t.Fatalf("FindByPackage: %v", err)
 	}
@@ -252,7 +694,7 @@ func TestFDroidIntegration(t *testing.T) {
 	}
 	t.Logf("version: %s (%d), size: %d", v.Name, v.Code, v.Size)
 
-	stream, err := src.Download(v)
+	stream, err := src.Download(context.Background(), v)
 	if err != nil {
 		t.Fatalf("Download: %v", err)
 	}
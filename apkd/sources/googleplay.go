@@ -0,0 +1,500 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/devices"
+	"github.com/kiber-io/apkd/apkd/network"
+)
+
+const googlePlayFinskyVersion = "41.2.29-23"
+const googlePlayFinskyVersionCode = 84122900
+
+var gsfIDRegexp = regexp.MustCompile(`^[0-9a-f]{1,16}$`)
+
+// Delivery status codes of the Play API.
+const (
+	playDeliveryUnset        = 0
+	playDeliveryOK           = 1
+	playDeliveryNotSupported = 2
+	playDeliveryNotPurchased = 3
+	playDeliveryAppRemoved   = 7
+	playDeliveryIncompatible = 9
+)
+
+// GooglePlay downloads from the Play store through the protobuf API the Play
+// Store app uses. It needs the OAuth token of a Google account; the device
+// is registered with a checkin unless a GSF id is configured.
+type GooglePlay struct {
+	BaseSource
+	config GooglePlayConfig
+	device devices.Device
+
+	checkinMu sync.Mutex
+	gsfID     string
+
+	deliveriesMu sync.Mutex
+	deliveries   map[string]playDelivery
+}
+
+type GooglePlayConfig struct {
+	BaseSourceConfig `yaml:",inline"`
+	// AuthToken is the OAuth token of the Google account, sent as a bearer
+	// token.
+	AuthToken string `yaml:"auth_token"`
+	// GSFID is the hex Google Services Framework id of a device already
+	// checked in with the account. When empty, apkd checks in on first use.
+	GSFID string `yaml:"gsf_id"`
+}
+
+func defaultGooglePlayConfig() GooglePlayConfig {
+	return GooglePlayConfig{
+		BaseSourceConfig: BaseSourceConfig{
+			BaseURL: "https://android.clients.google.com",
+		},
+	}
+}
+
+// playDetails is the part of DetailsResponse apkd uses.
+type playDetails struct {
+	PackageName   string
+	VersionCode   int
+	VersionName   string
+	DeveloperName string
+	Size          uint64
+}
+
+// playDelivery is the part of AppDeliveryData apkd uses: the base APK and its
+// splits.
+type playDelivery struct {
+	Size     uint64
+	URL      string
+	Checksum Checksum
+	Cookie   string
+	Splits   []playSplit
+}
+
+type playSplit struct {
+	Name     string
+	Size     uint64
+	URL      string
+	Checksum Checksum
+}
+
+func (d playDelivery) totalSize() uint64 {
+	size := d.Size
+	for _, split := range d.Splits {
+		size += split.Size
+	}
+	return size
+}
+
+func (s *GooglePlay) Name() string {
+	return "googleplay"
+}
+
+func (s *GooglePlay) MaxParallelsDownloads() int {
+	return 2
+}
+
+// CheckConfig reports a missing account token: the source cannot be used
+// without one.
+func (s *GooglePlay) CheckConfig() error {
+	if s.config.AuthToken == "" {
+		return errors.New("sources.googleplay.auth_token is not set")
+	}
+	return nil
+}
+
+// deviceID returns the GSF id, checking the device in when none is known yet.
+func (s *GooglePlay) deviceID(ctx context.Context) (string, error) {
+	s.checkinMu.Lock()
+	defer s.checkinMu.Unlock()
+	if s.gsfID != "" {
+		return s.gsfID, nil
+	}
+	// The GSF id belongs to the checked-in build, so a previous checkin is
+	// reused only for the same fingerprint.
+	sessionKey := "gsf_id/" + s.device.Fingerprint
+	if gsfID, ok := s.Session().Get(sessionKey); ok {
+		s.Log().Logd("Reusing device " + gsfID + " from the session")
+		s.gsfID = gsfID
+		return gsfID, nil
+	}
+	gsfID, err := s.checkin(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.Log().Logd("Checked in as device " + gsfID)
+	s.gsfID = gsfID
+	s.Session().Set(sessionKey, gsfID, 0)
+	return gsfID, nil
+}
+
+// checkin registers the device profile and returns the GSF id assigned to it.
+func (s *GooglePlay) checkin(ctx context.Context) (string, error) {
+	build := (&protoBuilder{}).
+		string(1, s.device.Fingerprint).
+		string(2, s.device.Product).
+		string(6, "android-google").
+		int(7, time.Now().Unix()).
+		int(8, googlePlayFinskyVersionCode).
+		string(9, s.device.Device).
+		int(10, int64(s.device.SDKInt)).
+		string(11, s.device.Model).
+		string(12, s.device.Manufacturer).
+		string(13, s.device.Product).
+		bool(14, false)
+	checkin := (&protoBuilder{}).
+		message(1, build).
+		int(2, 0)
+	deviceConfiguration := (&protoBuilder{}).
+		int(1, 3).
+		int(2, 1).
+		int(3, 1).
+		int(4, 2).
+		bool(5, false).
+		bool(6, false).
+		int(7, int64(s.device.DPI)).
+		int(8, 196610).
+		int(12, int64(s.device.Width)).
+		int(13, int64(s.device.Height))
+	for _, abi := range s.device.CPUAbis {
+		deviceConfiguration.string(11, abi)
+	}
+	request := (&protoBuilder{}).
+		int(2, 0).
+		message(4, checkin).
+		string(6, "en_US").
+		string(12, "UTC").
+		int(14, 3).
+		message(18, deviceConfiguration).
+		int(20, 0)
+
+	req, err := s.NewRequest(ctx, "POST", s.config.BaseURL+"/checkin", bytes.NewReader(request.data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuffer")
+	res, err := s.Http().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to check in: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := readBody(res)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to check in (%s)", res.Status)
+	}
+	response, err := decodeProto(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse checkin response: %w", err)
+	}
+	androidID := response.uint(7)
+	if androidID == 0 {
+		return "", errors.New("checkin response has no android id")
+	}
+	return strconv.FormatUint(androidID, 16), nil
+}
+
+// fdfe sends a request to the Play API and returns the payload of the
+// response wrapper. A 404 is an *AppNotFoundError.
+func (s *GooglePlay) fdfe(ctx context.Context, path string, query url.Values, packageName string) (protoMessage, error) {
+	gsfID, err := s.deviceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.NewRequest(ctx, "GET", s.config.BaseURL+"/fdfe/"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.AuthToken)
+	req.Header.Set("X-DFE-Device-Id", gsfID)
+	res, err := s.Http().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer res.Body.Close()
+	body, err := readBody(res)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, &AppNotFoundError{PackageName: packageName}
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("failed to fetch %s (%s): check sources.googleplay.auth_token", path, res.Status)
+	default:
+		return nil, fmt.Errorf("failed to fetch %s (%s)", path, res.Status)
+	}
+	response, err := decodeProto(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", path, err)
+	}
+	payload, err := response.message(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", path, err)
+	}
+	return payload, nil
+}
+
+func (s *GooglePlay) getDetails(ctx context.Context, packageName string) (playDetails, error) {
+	var details playDetails
+	payload, err := s.fdfe(ctx, "details", url.Values{"doc": {packageName}}, packageName)
+	if err != nil {
+		return details, err
+	}
+	// Payload.detailsResponse.docV2.details.appDetails
+	appDetails, err := payload.path(2, 4, 13, 1)
+	if err != nil {
+		return details, fmt.Errorf("failed to parse details response: %w", err)
+	}
+	details.PackageName = appDetails.string(14)
+	if details.PackageName == "" {
+		return details, &AppNotFoundError{PackageName: packageName}
+	}
+	details.DeveloperName = appDetails.string(1)
+	details.VersionCode = int(appDetails.uint(3)) //nolint:gosec // G115: version codes fit in int
+	details.VersionName = appDetails.string(4)
+	details.Size = appDetails.uint(9)
+	return details, nil
+}
+
+// delivery returns the download links of a version. They are kept for the
+// run so that Download reuses the links FindByPackage saw.
+func (s *GooglePlay) delivery(ctx context.Context, packageName string, versionCode int) (playDelivery, error) {
+	key := packageName + "/" + strconv.Itoa(versionCode)
+	s.deliveriesMu.Lock()
+	delivery, found := s.deliveries[key]
+	s.deliveriesMu.Unlock()
+	if found {
+		return delivery, nil
+	}
+	delivery, err := s.getDelivery(ctx, packageName, versionCode)
+	if err != nil {
+		return delivery, err
+	}
+	s.deliveriesMu.Lock()
+	s.deliveries[key] = delivery
+	s.deliveriesMu.Unlock()
+	return delivery, nil
+}
+
+func (s *GooglePlay) getDelivery(ctx context.Context, packageName string, versionCode int) (playDelivery, error) {
+	var delivery playDelivery
+	payload, err := s.fdfe(ctx, "delivery", url.Values{
+		"doc": {packageName},
+		"ot":  {"1"},
+		"vc":  {strconv.Itoa(versionCode)},
+	}, packageName)
+	if err != nil {
+		return delivery, err
+	}
+	response, err := payload.message(21)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to parse delivery response: %w", err)
+	}
+	switch status := response.uint(1); status {
+	case playDeliveryOK, playDeliveryUnset:
+	case playDeliveryNotSupported, playDeliveryIncompatible:
+		return delivery, fmt.Errorf("%s is not available for this device", packageName)
+	case playDeliveryNotPurchased:
+		return delivery, fmt.Errorf("%s has not been purchased by the account", packageName)
+	case playDeliveryAppRemoved:
+		return delivery, &AppNotFoundError{PackageName: packageName}
+	default:
+		return delivery, fmt.Errorf("delivery of %s failed with status %d", packageName, status)
+	}
+	data, err := response.message(2)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to parse delivery response: %w", err)
+	}
+	delivery.URL = data.string(3)
+	if delivery.URL == "" {
+		return delivery, fmt.Errorf("delivery response of %s has no download url", packageName)
+	}
+	delivery.Size = data.uint(1)
+	delivery.Checksum = playChecksum(data.string(2))
+	cookie, err := data.message(5)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to parse delivery response: %w", err)
+	}
+	if name := cookie.string(1); name != "" {
+		delivery.Cookie = name + "=" + cookie.string(2)
+	}
+	splits, err := data.messages(15)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to parse delivery response: %w", err)
+	}
+	for _, split := range splits {
+		part := playSplit{
+			Name:     split.string(1),
+			Size:     split.uint(2),
+			Checksum: playChecksum(split.string(4)),
+			URL:      split.string(5),
+		}
+		if part.Name == "" || part.URL == "" {
+			return delivery, fmt.Errorf("delivery response of %s has an incomplete split", packageName)
+		}
+		delivery.Splits = append(delivery.Splits, part)
+	}
+	return delivery, nil
+}
+
+// playChecksum decodes the url-safe base64 SHA-1 digest Play publishes for
+// each file. It returns the zero Checksum when the digest is not usable.
+func playChecksum(signature string) Checksum {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(signature, "="))
+	if err != nil {
+		return Checksum{}
+	}
+	checksum, err := NewChecksum(SHA1, hex.EncodeToString(decoded))
+	if err != nil {
+		return Checksum{}
+	}
+	return checksum
+}
+
+// FindByPackage returns the version Play offers for this device. Play only
+// serves the current version, so any other version code is not found.
+func (s *GooglePlay) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
+	details, err := s.getDetails(ctx, packageName)
+	if err != nil {
+		return Version{}, err
+	}
+	if versionCode != 0 && versionCode != details.VersionCode {
+		return Version{}, &AppNotFoundError{PackageName: packageName}
+	}
+	delivery, err := s.delivery(ctx, details.PackageName, details.VersionCode)
+	if err != nil {
+		return Version{}, err
+	}
+	version := Version{
+		Name:        details.VersionName,
+		Code:        details.VersionCode,
+		Size:        delivery.totalSize(),
+		Link:        delivery.URL,
+		PackageName: details.PackageName,
+		DeveloperId: details.DeveloperName,
+		Type:        APK,
+		Checksum:    delivery.Checksum,
+	}
+	if len(delivery.Splits) > 0 {
+		version.Type = APKS
+		version.Checksum = Checksum{}
+	}
+	return version, nil
+}
+
+// Download returns the base APK, or an APKS bundle of the base APK and its
+// splits when Play delivers the app as split APKs.
+func (s *GooglePlay) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+// DownloadRange resumes single APK downloads. Bundles are assembled on the
+// fly and always start over.
+func (s *GooglePlay) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	delivery, err := s.delivery(ctx, version.PackageName, version.Code)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if delivery.Cookie != "" {
+		header.Set("Cookie", delivery.Cookie)
+	}
+	if len(delivery.Splits) == 0 {
+		req, err := s.NewRequest(ctx, "GET", delivery.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return createRangeResponseReader(s.Http(), req, byteRange)
+	}
+	parts := []bundlePart{{URL: delivery.URL, Header: header, Checksum: delivery.Checksum}}
+	for _, split := range delivery.Splits {
+		parts = append(parts, bundlePart{
+			SplitID:  split.Name,
+			URL:      split.URL,
+			Header:   header,
+			Checksum: split.Checksum,
+		})
+	}
+	return s.downloadBundle(ctx, version.PackageName, parts), nil
+}
+
+func userAgentGooglePlay(device devices.Device) string {
+	return fmt.Sprintf(
+		"Android-Finsky/%s [0] [PR] 639844241 (api=3,versionCode=%d,sdk=%d,device=%s,hardware=%s,product=%s,platformVersionRelease=%s,model=%s,buildId=%s,isWideScreen=0,supportedAbis=%s)",
+		googlePlayFinskyVersion,
+		googlePlayFinskyVersionCode,
+		device.SDKInt,
+		device.Device,
+		device.Device,
+		device.Product,
+		device.AndroidVersion,
+		strings.ReplaceAll(device.Model, " ", "_"),
+		device.BuildID,
+		strings.Join(device.CPUAbis, ";"),
+	)
+}
+
+func newGooglePlaySource() (Source, error) {
+	s := &GooglePlay{
+		device:     currentDevice(),
+		deliveries: make(map[string]playDelivery),
+	}
+	s.Source = s
+	config, err := ResolveSourceConfig(s.Name(), defaultGooglePlayConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode googleplay config: %w", err)
+	}
+	s.config = config
+	s.gsfID = config.GSFID
+	s.device = s.stableDevice(s.device)
+	s.Log().Logd(fmt.Sprintf("Initialized with device: %s %s (Android %s, SDK %d)", s.device.Brand, s.device.Model, s.device.AndroidVersion, s.device.SDKInt))
+	headers := ApplyConfiguredHeaders(http.Header{
+		"User-Agent":      {userAgentGooglePlay(s.device)},
+		"Accept-Language": {"en-US"},
+		"X-DFE-Client-Id": {"am-android-google"},
+	}, config.Headers)
+	s.Net = network.DefaultClientForSource(s.Name()).WithDefaultHeaders(headers)
+	return s, nil
+}
+
+func init() {
+	RegisterSourceFactoryWithConfig(newGooglePlaySource, "googleplay", NewConfigDecoderWithDefaults(
+		defaultGooglePlayConfig(),
+		func(c *GooglePlayConfig) {
+			NormalizeBaseSourceConfig(&c.BaseSourceConfig)
+			c.AuthToken = strings.TrimSpace(c.AuthToken)
+			c.GSFID = strings.ToLower(strings.TrimSpace(c.GSFID))
+		},
+		func(c GooglePlayConfig) error {
+			if err := ValidateBaseSourceConfig(c.BaseSourceConfig); err != nil {
+				return err
+			}
+			if c.GSFID != "" && !gsfIDRegexp.MatchString(c.GSFID) {
+				return fmt.Errorf("gsf_id %q is invalid, expected up to 16 hex digits", c.GSFID)
+			}
+			return nil
+		},
+	))
+}
@@ -0,0 +1,181 @@
+package sources
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The Play API speaks protocol buffers. apkd only needs a handful of fields,
+// so instead of generated code it uses this minimal wire format codec.
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// protoMessage is a decoded message: the values of every field by number, in
+// the order they appear on the wire. Varint and fixed fields hold uint64s,
+// length-delimited fields hold []byte.
+type protoMessage map[int][]any
+
+func decodeProto(data []byte) (protoMessage, error) {
+	message := make(protoMessage)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid protobuf field key")
+		}
+		data = data[n:]
+		if key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid protobuf field number %d", key>>3)
+		}
+		field := int(key >> 3)
+		switch key & 7 {
+		case protoVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in protobuf field %d", field)
+			}
+			message[field] = append(message[field], value)
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			message[field] = append(message[field], binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			message[field] = append(message[field], data[n:n+int(length)])
+			data = data[n+int(length):]
+		case protoFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			message[field] = append(message[field], uint64(binary.LittleEndian.Uint32(data)))
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d in field %d", key&7, field)
+		}
+	}
+	return message, nil
+}
+
+// uint returns the last value of a numeric field, or 0 when it is missing.
+func (m protoMessage) uint(field int) uint64 {
+	values := m[field]
+	if len(values) == 0 {
+		return 0
+	}
+	value, _ := values[len(values)-1].(uint64)
+	return value
+}
+
+// bytes returns the last value of a length-delimited field, or nil when it is
+// missing.
+func (m protoMessage) bytes(field int) []byte {
+	values := m[field]
+	if len(values) == 0 {
+		return nil
+	}
+	value, _ := values[len(values)-1].([]byte)
+	return value
+}
+
+func (m protoMessage) string(field int) string {
+	return string(m.bytes(field))
+}
+
+// message decodes an embedded message. A missing field yields an empty
+// message.
+func (m protoMessage) message(field int) (protoMessage, error) {
+	message, err := decodeProto(m.bytes(field))
+	if err != nil {
+		return nil, fmt.Errorf("field %d: %w", field, err)
+	}
+	return message, nil
+}
+
+// messages decodes every value of a repeated embedded message.
+func (m protoMessage) messages(field int) ([]protoMessage, error) {
+	var messages []protoMessage
+	for _, value := range m[field] {
+		data, ok := value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("field %d is not a message", field)
+		}
+		message, err := decodeProto(data)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// path decodes nested messages following the field numbers.
+func (m protoMessage) path(fields ...int) (protoMessage, error) {
+	message := m
+	for _, field := range fields {
+		var err error
+		if message, err = message.message(field); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// protoBuilder encodes a message. Fields are written in the order they are
+// added.
+type protoBuilder struct {
+	data []byte
+}
+
+func (b *protoBuilder) key(field int, wireType int) {
+	b.data = binary.AppendUvarint(b.data, uint64(field)<<3|uint64(wireType)) //nolint:gosec // G115: field numbers are small constants
+}
+
+func (b *protoBuilder) uint(field int, value uint64) *protoBuilder {
+	b.key(field, protoVarint)
+	b.data = binary.AppendUvarint(b.data, value)
+	return b
+}
+
+func (b *protoBuilder) int(field int, value int64) *protoBuilder {
+	return b.uint(field, uint64(value)) //nolint:gosec // G115: int64 fields are encoded as two's complement varints
+}
+
+func (b *protoBuilder) bool(field int, value bool) *protoBuilder {
+	if value {
+		return b.uint(field, 1)
+	}
+	return b.uint(field, 0)
+}
+
+func (b *protoBuilder) fixed64(field int, value uint64) *protoBuilder {
+	b.key(field, protoFixed64)
+	b.data = binary.LittleEndian.AppendUint64(b.data, value)
+	return b
+}
+
+func (b *protoBuilder) bytes(field int, value []byte) *protoBuilder {
+	b.key(field, protoBytes)
+	b.data = binary.AppendUvarint(b.data, uint64(len(value)))
+	b.data = append(b.data, value...)
+	return b
+}
+
+func (b *protoBuilder) string(field int, value string) *protoBuilder {
+	return b.bytes(field, []byte(value))
+}
+
+func (b *protoBuilder) message(field int, message *protoBuilder) *protoBuilder {
+	return b.bytes(field, message.data)
+}
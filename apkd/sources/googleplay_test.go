@@ -0,0 +1,369 @@
+package sources
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // G505: Play publishes SHA-1 digests
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/devices"
+)
+
+const testGSFID = 0x3a1b2c3d4e5f6071
+
+// playStub replays the Play API responses for the apps it holds. files maps
+// download paths to their contents.
+type playStub struct {
+	server   *httptest.Server
+	apps     map[string]playStubApp
+	files    map[string][]byte
+	checkins atomic.Int32
+}
+
+type playStubApp struct {
+	versionCode int
+	versionName string
+	developer   string
+	// splits are the names of the split APKs, served as /dl/<package>/<name>.
+	splits []string
+}
+
+func newPlayStub(t *testing.T, apps map[string]playStubApp) *playStub {
+	t.Helper()
+	stub := &playStub{apps: apps, files: make(map[string][]byte)}
+	for packageName, app := range apps {
+		stub.files["/dl/"+packageName+"/base"] = []byte("base of " + packageName)
+		for _, split := range app.splits {
+			stub.files["/dl/"+packageName+"/"+split] = []byte(split + " of " + packageName)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /checkin", stub.checkin)
+	mux.HandleFunc("GET /fdfe/details", stub.details)
+	mux.HandleFunc("GET /fdfe/delivery", stub.delivery)
+	mux.HandleFunc("GET /dl/", stub.download)
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (p *playStub) checkin(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request, err := decodeProto(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deviceConfiguration, err := request.message(18)
+	if err != nil || deviceConfiguration.uint(7) == 0 || len(deviceConfiguration[11]) == 0 {
+		http.Error(w, "missing device configuration", http.StatusBadRequest)
+		return
+	}
+	p.checkins.Add(1)
+	_, _ = w.Write((&protoBuilder{}).bool(1, true).fixed64(7, testGSFID).fixed64(8, 42).data)
+}
+
+func (p *playStub) authorized(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer token" &&
+		r.Header.Get("X-DFE-Device-Id") == strconv.FormatUint(testGSFID, 16)
+}
+
+func (p *playStub) details(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	packageName := r.URL.Query().Get("doc")
+	app, found := p.apps[packageName]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	appDetails := (&protoBuilder{}).
+		string(1, app.developer).
+		int(3, int64(app.versionCode)).
+		string(4, app.versionName).
+		int(9, 1234).
+		string(14, packageName)
+	docV2 := (&protoBuilder{}).
+		string(1, packageName).
+		message(13, (&protoBuilder{}).message(1, appDetails))
+	payload := (&protoBuilder{}).message(2, (&protoBuilder{}).message(4, docV2))
+	_, _ = w.Write((&protoBuilder{}).message(1, payload).data)
+}
+
+func (p *playStub) delivery(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	packageName := r.URL.Query().Get("doc")
+	app, found := p.apps[packageName]
+	if !found || r.URL.Query().Get("vc") != strconv.Itoa(app.versionCode) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	base := p.files["/dl/"+packageName+"/base"]
+	data := (&protoBuilder{}).
+		int(1, int64(len(base))).
+		string(2, playSignature(base)).
+		string(3, p.server.URL+"/dl/"+packageName+"/base").
+		message(5, (&protoBuilder{}).string(1, "MarketDA").string(2, "cookie"))
+	for _, split := range app.splits {
+		content := p.files["/dl/"+packageName+"/"+split]
+		data.message(15, (&protoBuilder{}).
+			string(1, split).
+			int(2, int64(len(content))).
+			string(4, playSignature(content)).
+			string(5, p.server.URL+"/dl/"+packageName+"/"+split))
+	}
+	response := (&protoBuilder{}).int(1, playDeliveryOK).message(2, data)
+	_, _ = w.Write((&protoBuilder{}).message(1, (&protoBuilder{}).message(21, response)).data)
+}
+
+func (p *playStub) download(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Cookie") != "MarketDA=cookie" || r.Header.Get("Authorization") != "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	content, found := p.files[r.URL.Path]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func playSignature(content []byte) string {
+	sum := sha1.Sum(content) //nolint:gosec // G401: Play publishes SHA-1 digests
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func mockGooglePlay(stub *playStub) *GooglePlay {
+	s := &GooglePlay{
+		device:     devices.RandomDevice(),
+		deliveries: make(map[string]playDelivery),
+	}
+	s.Source = s
+	s.config = defaultGooglePlayConfig()
+	s.config.BaseURL = stub.server.URL
+	s.config.AuthToken = "token"
+	s.Net = stub.server.Client()
+	return s
+}
+
+func TestGooglePlayFindByPackageReturnsBundle(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{
+		"com.example": {versionCode: 12, versionName: "1.2", developer: "Example", splits: []string{"config.arm64_v8a", "config.xxhdpi"}},
+	})
+	s := mockGooglePlay(stub)
+
+	version, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version.Code != 12 || version.Name != "1.2" || version.DeveloperId != "Example" || version.PackageName != "com.example" {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+	if version.Type != APKS {
+		t.Fatalf("expected type %s, got %s", APKS, version.Type)
+	}
+	var expectedSize uint64
+	for _, content := range stub.files {
+		expectedSize += uint64(len(content))
+	}
+	if version.Size != expectedSize {
+		t.Fatalf("expected size %d, got %d", expectedSize, version.Size)
+	}
+	if _, err := s.FindByPackage(context.Background(), "com.example", 12); err != nil {
+		t.Fatalf("unexpected error for pinned version: %v", err)
+	}
+	if checkins := stub.checkins.Load(); checkins != 1 {
+		t.Fatalf("expected one checkin, got %d", checkins)
+	}
+}
+
+func TestGooglePlayReusesCheckinFromSession(t *testing.T) {
+	useSessionDir(t)
+	stub := newPlayStub(t, map[string]playStubApp{"com.example": {versionCode: 12}})
+	device := devices.RandomDevice()
+	for range 2 {
+		s := mockGooglePlay(stub)
+		s.device = device
+		if _, err := s.FindByPackage(context.Background(), "com.example", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if checkins := stub.checkins.Load(); checkins != 1 {
+		t.Fatalf("expected one checkin across runs, got %d", checkins)
+	}
+}
+
+func TestGooglePlayFindByPackageNotFound(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{"com.example": {versionCode: 12}})
+	s := mockGooglePlay(stub)
+
+	var notFound *AppNotFoundError
+	if _, err := s.FindByPackage(context.Background(), "com.missing", 0); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError, got %v", err)
+	}
+	if _, err := s.FindByPackage(context.Background(), "com.example", 11); !errors.As(err, &notFound) {
+		t.Fatalf("expected AppNotFoundError for an older version, got %v", err)
+	}
+}
+
+func TestGooglePlayUsesConfiguredGSFID(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{"com.example": {versionCode: 12}})
+	s := mockGooglePlay(stub)
+	s.gsfID = strconv.FormatUint(testGSFID, 16)
+
+	if _, err := s.FindByPackage(context.Background(), "com.example", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checkins := stub.checkins.Load(); checkins != 0 {
+		t.Fatalf("expected no checkin, got %d", checkins)
+	}
+}
+
+func TestGooglePlayDownloadSingleAPK(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{"com.example": {versionCode: 12}})
+	s := mockGooglePlay(stub)
+
+	version, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version.Type != APK {
+		t.Fatalf("expected type %s, got %s", APK, version.Type)
+	}
+	if version.Checksum.Algorithm != SHA1 {
+		t.Fatalf("expected a sha1 checksum, got %+v", version.Checksum)
+	}
+	stream, err := s.DownloadRange(context.Background(), version, ByteRange{Offset: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	body, _ := io.ReadAll(stream.Body)
+	if stream.Offset != 5 || string(body) != "of com.example" {
+		t.Fatalf("unexpected ranged download at %d: %q", stream.Offset, body)
+	}
+}
+
+func TestGooglePlayDownloadBundlesSplits(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{
+		"com.example": {versionCode: 12, splits: []string{"config.arm64_v8a", "config.xxhdpi"}},
+	})
+	s := mockGooglePlay(stub)
+	version, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stream, err := s.Download(context.Background(), version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	if stream.Size != -1 {
+		t.Fatalf("expected unknown size, got %d", stream.Size)
+	}
+	data, err := io.ReadAll(stream.Body)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("bundle is not a zip: %v", err)
+	}
+	expected := map[string]string{
+		"base.apk":                   "base of com.example",
+		"split_config.arm64_v8a.apk": "config.arm64_v8a of com.example",
+		"split_config.xxhdpi.apk":    "config.xxhdpi of com.example",
+	}
+	if len(archive.File) != len(expected)+1 || archive.File[0].Name != "toc.pb" {
+		t.Fatalf("expected toc.pb and %d APKs, got %d entries", len(expected), len(archive.File))
+	}
+	for _, file := range archive.File[1:] {
+		entry, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(entry)
+		entry.Close()
+		if string(content) != expected[file.Name] {
+			t.Fatalf("unexpected content of %s: %q", file.Name, content)
+		}
+	}
+}
+
+func TestGooglePlayDownloadRejectsTamperedSplit(t *testing.T) {
+	stub := newPlayStub(t, map[string]playStubApp{
+		"com.example": {versionCode: 12, splits: []string{"config.xxhdpi"}},
+	})
+	s := mockGooglePlay(stub)
+	version, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stub.files["/dl/com.example/config.xxhdpi"] = []byte("tampered")
+
+	stream, err := s.Download(context.Background(), version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	var mismatch *ChecksumMismatchError
+	if _, err := io.ReadAll(stream.Body); !errors.As(err, &mismatch) {
+		t.Fatalf("expected ChecksumMismatchError, got %v", err)
+	}
+	if mismatch.Path != "split_config.xxhdpi.apk" {
+		t.Fatalf("unexpected mismatch path: %s", mismatch.Path)
+	}
+}
+
+func TestGooglePlayCheckConfigRequiresToken(t *testing.T) {
+	s := &GooglePlay{config: defaultGooglePlayConfig()}
+	if err := CheckConfig(s); err == nil {
+		t.Fatalf("expected missing token error")
+	}
+	s.config.AuthToken = "token"
+	if err := CheckConfig(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeProtoRoundTrip(t *testing.T) {
+	nested := (&protoBuilder{}).string(1, "inner")
+	data := (&protoBuilder{}).
+		int(1, 300).
+		fixed64(2, 1<<40).
+		string(3, "text").
+		message(4, nested).
+		message(4, nested).
+		data
+
+	message, err := decodeProto(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message.uint(1) != 300 || message.uint(2) != 1<<40 || message.string(3) != "text" {
+		t.Fatalf("unexpected message: %v", message)
+	}
+	inner, err := message.messages(4)
+	if err != nil || len(inner) != 2 || inner[1].string(1) != "inner" {
+		t.Fatalf("unexpected nested messages: %v, %v", inner, err)
+	}
+	if _, err := decodeProto(data[:len(data)-1]); err == nil {
+		t.Fatalf("expected truncated message error")
+	}
+}
@@ -2,6 +2,7 @@ package sources
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,6 +22,7 @@ type ReleaseNashStore struct {
 	VersionCode int    `json:"version_code"`
 	VersionName string `json:"version_name"`
 	Link        string `json:"install_path"`
+	Hash        string `json:"hash"`
 }
 
 type AppNashStore struct {
@@ -93,7 +95,7 @@ func randomSemVer() string {
 	return fmt.Sprintf("%d.%d", major, patch)
 }
 
-func (s *NashStore) getAppInfo(packageName string) (AppInfoNashStore, error) {
+func (s *NashStore) getAppInfo(ctx context.Context, packageName string) (AppInfoNashStore, error) {
 	var appInfo AppInfoNashStore
 	url := s.config.BaseURL + "/api/mobile/v1/profile/updates"
 	payloadData := map[string]any{
@@ -113,7 +115,7 @@ func (s *NashStore) getAppInfo(packageName string) (AppInfoNashStore, error) {
 		return appInfo, fmt.Errorf("failed to marshal app info request: %w", err)
 	}
 	payload := bytes.NewReader(payloadBytes)
-	req, err := s.NewRequest("POST", url, payload)
+	req, err := s.NewRequest(ctx, "POST", url, payload)
 
 	if err != nil {
 		return appInfo, err
@@ -172,9 +174,9 @@ func (s *NashStore) getAppInfo(packageName string) (AppInfoNashStore, error) {
 	return appInfo, errors.New("failed to parse app info")
 }
 
-func (s *NashStore) FindByPackage(packageName string, versionCode int) (Version, error) {
+func (s *NashStore) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
 	var version Version
-	appInfo, err := s.getAppInfo(packageName)
+	appInfo, err := s.getAppInfo(ctx, packageName)
 	if err != nil {
 		return version, err
 	}
@@ -188,26 +190,41 @@ func (s *NashStore) FindByPackage(packageName string, versionCode int) (Version,
 	version.DeveloperId = appInfo.App.Id
 	version.Link = appInfo.App.Release.Link
 	version.Type = APK
+	version.Checksum = ChecksumFromHex(appInfo.App.Release.Hash)
 
 	return version, nil
 }
 
-func (s *NashStore) Download(version Version) (*DownloadStream, error) {
-	req, err := s.NewRequest("GET", version.Link, nil)
+func (s *NashStore) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *NashStore) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	req, err := s.NewRequest(ctx, "GET", version.Link, nil)
 	if err != nil {
 		return nil, err
 	}
-	return createResponseReader(s.Http(), req)
+	return createRangeResponseReader(s.Http(), req, byteRange)
 }
 
 func (s *NashStore) MaxParallelsDownloads() int {
 	return 3
 }
 
-func (s *NashStore) FindByDeveloper(developerId string) ([]string, error) {
+// ListVersions returns the current version only: NashStore does not serve older
+// releases.
+func (s *NashStore) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	version, err := s.FindByPackage(ctx, packageName, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []Version{version}, nil
+}
+
+func (s *NashStore) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
 	url := s.config.BaseURL + "/api/mobile/v1/application/" + developerId
 
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 
 	if err != nil {
 		return nil, err
@@ -282,9 +299,10 @@ func (s *NashStore) FindByDeveloper(developerId string) ([]string, error) {
 
 func newNashStoreSource() (Source, error) {
 	s := &NashStore{
-		device: devices.RandomDevice(),
+		device: currentDevice(),
 	}
 	s.Source = s
+	s.device = s.stableDevice(s.device)
 	tok := s.answer42()
 	appHeader := map[string]any{
 		"androidId":   s.device.AndroidID,
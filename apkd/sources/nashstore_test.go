@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -21,7 +22,7 @@ func TestNashStoreGetAppInfoInvalidSizeTypeReturnsError(t *testing.T) {
 		}, nil
 	})
 
-	_, err := s.getAppInfo("com.example.app")
+	_, err := s.getAppInfo(context.Background(), "com.example.app")
 	if err == nil {
 		t.Fatalf("expected getAppInfo error for invalid size type")
 	}
@@ -43,7 +44,7 @@ func TestNashStoreFindByDeveloperInvalidAppShapeReturnsError(t *testing.T) {
 		}, nil
 	})
 
-	_, err := s.FindByDeveloper("dev")
+	_, err := s.FindByDeveloper(context.Background(), "dev")
 	if err == nil {
 		t.Fatalf("expected FindByDeveloper error for invalid app shape")
 	}
@@ -62,7 +63,7 @@ func TestNashStoreFindByDeveloperHandlesEmptyOtherApps(t *testing.T) {
 		}, nil
 	})
 
-	packages, err := s.FindByDeveloper("dev")
+	packages, err := s.FindByDeveloper(context.Background(), "dev")
 	if err != nil {
 		t.Fatalf("unexpected FindByDeveloper error: %v", err)
 	}
@@ -89,7 +90,7 @@ func TestNashStoreFindByPackageHappyPath(t *testing.T) {
 	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(nashStoreHappyBody)), Request: req}, nil
 	})
-	v, err := s.FindByPackage("com.example", 0)
+	v, err := s.FindByPackage(context.Background(), "com.example", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -101,12 +102,25 @@ func TestNashStoreFindByPackageHappyPath(t *testing.T) {
 	}
 }
 
+func TestNashStoreListVersionsReturnsCurrentVersion(t *testing.T) {
+	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(nashStoreHappyBody)), Request: req}, nil
+	})
+	versions, err := s.ListVersions(context.Background(), "com.example")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Code != 10 {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+}
+
 func TestNashStoreFindByPackageNotFound(t *testing.T) {
 	const emptyBody = `{"list":[]}`
 	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(emptyBody)), Request: req}, nil
 	})
-	_, err := s.FindByPackage("com.missing", 0)
+	_, err := s.FindByPackage(context.Background(), "com.missing", 0)
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError, got %T: %v", err, err)
@@ -117,7 +131,7 @@ func TestNashStoreFindByPackageVersionCodeMismatch(t *testing.T) {
 	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(nashStoreHappyBody)), Request: req}, nil
 	})
-	_, err := s.FindByPackage("com.example", 999)
+	_, err := s.FindByPackage(context.Background(), "com.example", 999)
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError for version code mismatch, got %T: %v", err, err)
@@ -128,7 +142,7 @@ func TestNashStoreGetAppInfoNon200(t *testing.T) {
 	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Header: http.Header{}, Body: io.NopCloser(strings.NewReader("forbidden")), Request: req}, nil
 	})
-	if _, err := s.getAppInfo("com.example"); err == nil {
+	if _, err := s.getAppInfo(context.Background(), "com.example"); err == nil {
 		t.Fatal("expected error for non-200 response")
 	}
 }
@@ -138,7 +152,7 @@ func TestNashStoreGetAppInfoMultipleAppsReturnsError(t *testing.T) {
 	s := mockNashStore(func(req *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
 	})
-	if _, err := s.getAppInfo("com.a"); err == nil {
+	if _, err := s.getAppInfo(context.Background(), "com.a"); err == nil {
 		t.Fatal("expected error when multiple apps returned")
 	}
 }
@@ -154,7 +168,7 @@ func TestNashStoreIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create source: %v", err)
 	}
-	v, err := src.FindByPackage("com.bastion", 0)
+	v, err := src.FindByPackage(context.Background(), "com.bastion", 0)
 	if err != nil {
 		t.Fatalf("FindByPackage: %v", err)
 	}
@@ -163,7 +177,7 @@ func TestNashStoreIntegration(t *testing.T) {
 	}
 	t.Logf("version: %s (%d), size: %d", v.Name, v.Code, v.Size)
 
-	stream, err := src.Download(v)
+	stream, err := src.Download(context.Background(), v)
 	if err != nil {
 		t.Fatalf("Download: %v", err)
 	}
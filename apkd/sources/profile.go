@@ -8,12 +8,29 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/kiber-io/apkd/apkd/devices"
+	"github.com/kiber-io/apkd/apkd/network"
 	"gopkg.in/yaml.v3"
 )
 
 type BaseSourceConfig struct {
 	BaseURL string            `yaml:"base_url"`
 	Headers map[string]string `yaml:"headers"`
+	// MaxParallelDownloads overrides Source.MaxParallelsDownloads when > 0.
+	MaxParallelDownloads int `yaml:"max_parallel_downloads"`
+	// Cookies keeps the cookies of responses for the run.
+	Cookies bool `yaml:"cookies"`
+	// PersistCookies keeps the cookies in the session of the source, so they
+	// survive between runs. It implies Cookies.
+	PersistCookies bool `yaml:"persist_cookies"`
+}
+
+func (c BaseSourceConfig) baseSourceConfig() BaseSourceConfig {
+	return c
+}
+
+type baseSourceConfigProvider interface {
+	baseSourceConfig() BaseSourceConfig
 }
 
 type ConfigDecoder func(node *yaml.Node) (any, error)
@@ -24,6 +41,9 @@ var sourceConfigDecoders = make(map[string]ConfigDecoder)
 var configuredSourceConfigsMu sync.RWMutex
 var configuredSourceConfigs = make(map[string]any)
 
+var configuredDeviceMu sync.RWMutex
+var configuredDevice *devices.Device
+
 func RegisterSourceConfigDecoder(sourceName string, decoder ConfigDecoder) error {
 	normalizedSourceName := normalizeSourceName(sourceName)
 	if normalizedSourceName == "" {
@@ -123,6 +143,28 @@ func ConfigureSourceConfigs(sourceConfigs map[string]any) {
 	configuredSourceConfigsMu.Unlock()
 }
 
+// ConfigureDevice sets the device that sources created afterwards present to
+// the stores.
+func ConfigureDevice(device devices.Device) {
+	configuredDeviceMu.Lock()
+	configuredDevice = &device
+	configuredDeviceMu.Unlock()
+}
+
+// currentDevice returns the configured device, or a random one when none was
+// configured.
+func currentDevice() devices.Device {
+	configuredDeviceMu.RLock()
+	defer configuredDeviceMu.RUnlock()
+	if configuredDevice == nil {
+		return devices.RandomDevice()
+	}
+	device := *configuredDevice
+	device.CPUAbis = append([]string(nil), device.CPUAbis...)
+	device.Locales = append([]string(nil), device.Locales...)
+	return device
+}
+
 func GetConfiguredSourceConfig(sourceName string) (any, bool) {
 	normalizedSourceName := normalizeSourceName(sourceName)
 	configuredSourceConfigsMu.RLock()
@@ -192,6 +234,63 @@ func ValidateBaseSourceConfig(config BaseSourceConfig) error {
 			return errors.New("headers contains an empty header name")
 		}
 	}
+	if config.MaxParallelDownloads < 0 {
+		return errors.New("max_parallel_downloads must be >= 0")
+	}
+	return nil
+}
+
+// MaxParallelDownloads returns the number of concurrent downloads allowed for
+// the source: the configured max_parallel_downloads if set, otherwise the
+// source's own MaxParallelsDownloads. The result is always at least 1.
+func MaxParallelDownloads(s Source) int {
+	limit := s.MaxParallelsDownloads()
+	if config, exists := GetConfiguredSourceConfig(s.Name()); exists {
+		if provider, ok := config.(baseSourceConfigProvider); ok {
+			if configured := provider.baseSourceConfig().MaxParallelDownloads; configured > 0 {
+				limit = configured
+			}
+		}
+	}
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
+
+// configureCookies gives the client of the source a cookie jar when its
+// config asks for one: the jar of its session for persist_cookies, otherwise
+// an in-memory jar unless the source already set up one.
+func configureCookies(s Source) error {
+	config, exists := GetConfiguredSourceConfig(s.Name())
+	if !exists {
+		return nil
+	}
+	provider, ok := config.(baseSourceConfigProvider)
+	if !ok {
+		return nil
+	}
+	baseConfig := provider.baseSourceConfig()
+	if !baseConfig.Cookies && !baseConfig.PersistCookies {
+		return nil
+	}
+	cookieSource, ok := s.(interface {
+		Http() network.Doer
+		Session() *Session
+	})
+	if !ok {
+		return fmt.Errorf("source %s does not support cookies", s.Name())
+	}
+	client, ok := cookieSource.Http().(*network.Client)
+	if !ok {
+		return fmt.Errorf("source %s does not support cookies", s.Name())
+	}
+	switch {
+	case baseConfig.PersistCookies:
+		client.WithCookieJar(cookieSource.Session().CookieJar())
+	case client.CookieJar() == nil:
+		client.WithCookieJar(network.NewCookieJar())
+	}
 	return nil
 }
 
@@ -3,10 +3,14 @@ package sources
 import (
 	"errors"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/kiber-io/apkd/apkd/network"
 	"gopkg.in/yaml.v3"
 )
 
@@ -165,3 +169,87 @@ func TestApplyConfiguredHeadersCanonicalizesBaseHeaders(t *testing.T) {
 		t.Fatalf("expected configured header to replace base value, got %q", got)
 	}
 }
+
+func TestMaxParallelDownloadsUsesConfigOverride(t *testing.T) {
+	configuredSourceConfigsMu.RLock()
+	oldConfigs := configuredSourceConfigs
+	configuredSourceConfigsMu.RUnlock()
+	t.Cleanup(func() {
+		configuredSourceConfigsMu.Lock()
+		configuredSourceConfigs = oldConfigs
+		configuredSourceConfigsMu.Unlock()
+	})
+
+	src := stubSource{name: "demo"}
+	ConfigureSourceConfigs(nil)
+	if got := MaxParallelDownloads(src); got != 1 {
+		t.Fatalf("expected source default limit 1, got %d", got)
+	}
+
+	ConfigureSourceConfigs(map[string]any{
+		"demo": testConfig{BaseSourceConfig: BaseSourceConfig{MaxParallelDownloads: 5}},
+	})
+	if got := MaxParallelDownloads(src); got != 5 {
+		t.Fatalf("expected configured limit 5, got %d", got)
+	}
+
+	ConfigureSourceConfigs(map[string]any{
+		"demo": testConfig{},
+	})
+	if got := MaxParallelDownloads(src); got != 1 {
+		t.Fatalf("expected unset config to keep source default, got %d", got)
+	}
+}
+
+func TestValidateBaseSourceConfigRejectsNegativeMaxParallelDownloads(t *testing.T) {
+	if err := ValidateBaseSourceConfig(BaseSourceConfig{MaxParallelDownloads: -1}); err == nil {
+		t.Fatalf("expected validation error for negative max_parallel_downloads")
+	}
+}
+
+func TestConfigureCookies(t *testing.T) {
+	configuredSourceConfigsMu.RLock()
+	oldConfigs := configuredSourceConfigs
+	configuredSourceConfigsMu.RUnlock()
+	t.Cleanup(func() {
+		configuredSourceConfigsMu.Lock()
+		configuredSourceConfigs = oldConfigs
+		configuredSourceConfigsMu.Unlock()
+	})
+	dir := useSessionDir(t)
+	newSource := func() *ApkPure {
+		s := &ApkPure{}
+		s.Source = s
+		s.Net = network.NewHttpClient(time.Second, nil)
+		return s
+	}
+	jarOf := func(s *ApkPure) http.CookieJar {
+		return s.Net.(*network.Client).CookieJar()
+	}
+
+	ConfigureSourceConfigs(nil)
+	plain := newSource()
+	if err := configureCookies(plain); err != nil || jarOf(plain) != nil {
+		t.Fatalf("expected no jar without config, got %v (%v)", jarOf(plain), err)
+	}
+
+	ConfigureSourceConfigs(map[string]any{"apkpure": ApkPureConfig{BaseSourceConfig: BaseSourceConfig{Cookies: true}}})
+	inMemory := newSource()
+	if err := configureCookies(inMemory); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := jarOf(inMemory).(*cookiejar.Jar); !ok {
+		t.Fatalf("expected an in-memory jar, got %T", jarOf(inMemory))
+	}
+
+	ConfigureSourceConfigs(map[string]any{"apkpure": ApkPureConfig{BaseSourceConfig: BaseSourceConfig{PersistCookies: true}}})
+	persisted := newSource()
+	if err := configureCookies(persisted); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	site, _ := url.Parse("https://apkpure.com/")
+	jarOf(persisted).SetCookies(site, []*http.Cookie{{Name: "cf_clearance", Value: "abc", Path: "/"}})
+	if cookies := openSession(dir, "apkpure", persisted.Log()).CookieJar().Cookies(site); len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Fatalf("expected the cookie to be saved in the session, got %v", cookies)
+	}
+}
@@ -3,6 +3,7 @@ package sources
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	crand "crypto/rand"
 	"encoding/json"
 	"errors"
@@ -10,12 +11,15 @@ import (
 	"io"
 	mrand "math/rand"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kiber-io/apkd/apkd/devices"
 	"github.com/kiber-io/apkd/apkd/network"
@@ -45,10 +49,21 @@ type RuStoreConfig struct {
 	AppVersion       string `yaml:"app_version"`
 	AppVersionCode   string `yaml:"app_version_code"`
 	FirmwareLang     string `yaml:"firmware_lang"`
+	// Splits enables bundle mode: apps are downloaded as base plus the config
+	// splits for the device and packaged as .apks.
+	Splits bool `yaml:"splits"`
+	// Locales are the locales the device reports, used to select language
+	// splits. The locales of the device profile take precedence.
+	Locales []string `yaml:"locales"`
 }
 
+// ruStoreLatestVersionTTL is how long a discovered RuStore version is reused
+// before /rustore-info/new-version is asked again.
+const ruStoreLatestVersionTTL = 24 * time.Hour
+
 var ruStoreVerCodeRegexp = regexp.MustCompile(`^\d+$`)
 var firmwareLangRegexp = regexp.MustCompile(`^[a-z]{2,8}$`)
+var ruStoreLocaleRegexp = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?$`)
 
 func (s *RuStore) Name() string {
 	return "rustore"
@@ -62,15 +77,16 @@ func defaultRuStoreConfig() RuStoreConfig {
 		AppVersion:     "1.103.1.0",
 		AppVersionCode: "1103100",
 		FirmwareLang:   "ru",
+		Locales:        []string{"en_US", "ru_RU"},
 	}
 }
 
-func (s *RuStore) ensureLatestVersion() {
+func (s *RuStore) ensureLatestVersion(ctx context.Context) {
 	if !s.latestVersionCheckEnabled {
 		return
 	}
 	s.latestVersionOnce.Do(func() {
-		rustoreUpdate, err := s.getLatestRustoreVersion()
+		rustoreUpdate, err := s.latestRustoreVersion(ctx)
 		if err != nil {
 			s.Log().Logw(fmt.Sprintf("Failed to get latest RuStore version: %v, using hardcoded default values. They may be outdated. Please update your profile or report an issue.", err))
 			return
@@ -93,9 +109,26 @@ func (s *RuStore) ensureLatestVersion() {
 	})
 }
 
-func (s *RuStore) getLatestRustoreVersion() (RuStoreUpdate, error) {
+// latestRustoreVersion returns the latest RuStore version, taken from the
+// session when it was discovered less than ruStoreLatestVersionTTL ago.
+func (s *RuStore) latestRustoreVersion(ctx context.Context) (RuStoreUpdate, error) {
+	name, nameFound := s.Session().Get("latest_version_name")
+	code, codeFound := s.Session().Get("latest_version_code")
+	if nameFound && codeFound {
+		return RuStoreUpdate{Body: RuStoreUpdateBody{LatestVersionCode: code, LatestVersionName: name}}, nil
+	}
+	rustoreUpdate, err := s.getLatestRustoreVersion(ctx)
+	if err != nil {
+		return RuStoreUpdate{}, err
+	}
+	s.Session().Set("latest_version_name", rustoreUpdate.Body.LatestVersionName, ruStoreLatestVersionTTL)
+	s.Session().Set("latest_version_code", rustoreUpdate.Body.LatestVersionCode, ruStoreLatestVersionTTL)
+	return rustoreUpdate, nil
+}
+
+func (s *RuStore) getLatestRustoreVersion(ctx context.Context) (RuStoreUpdate, error) {
 	url := s.config.BaseURL + "/rustore-info/new-version"
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return RuStoreUpdate{}, err
 	}
@@ -126,8 +159,12 @@ func (s *RuStore) getLatestRustoreVersion() (RuStoreUpdate, error) {
 	return rustoreUpdate, nil
 }
 
-func (s *RuStore) Download(version Version) (*DownloadStream, error) {
-	appInfo, err := s.getAppInfo(version.PackageName)
+func (s *RuStore) Download(ctx context.Context, version Version) (*DownloadStream, error) {
+	return s.DownloadRange(ctx, version, ByteRange{})
+}
+
+func (s *RuStore) DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	appInfo, err := s.getAppInfo(ctx, version.PackageName)
 	if err != nil {
 		return nil, err
 	}
@@ -135,15 +172,90 @@ func (s *RuStore) Download(version Version) (*DownloadStream, error) {
 	if !ok {
 		return nil, errors.New("appId not found or invalid in app info")
 	}
-	downloadLink, err := s.getDownloadLink(appId)
+	if version.Type == APKS {
+		downloadLinks, err := s.getDownloadLinks(ctx, appId, true)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := ruStoreBundleParts(downloadLinks)
+		if err != nil {
+			return nil, err
+		}
+		return s.downloadBundle(ctx, version.PackageName, parts), nil
+	}
+	downloadLink, err := s.getDownloadLink(ctx, appId)
 	if err != nil {
 		return nil, err
 	}
-	req, err := s.NewRequest("GET", downloadLink, nil)
+	req, err := s.NewRequest(ctx, "GET", downloadLink.URL, nil)
 	if err != nil {
 		return nil, err
 	}
-	return createResponseReader(s.Http(), req)
+	stream, err := createRangeResponseReader(s.Http(), req, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	stream.AdvisoryChecksum = downloadLink.Hash
+	return stream, nil
+}
+
+// ruStoreBundleParts turns the download links of bundle mode into bundle
+// parts, base APK first. RuStore names the files of splits after the split
+// (config.arm64_v8a.apk); the one link that is not named like a split is the
+// base APK.
+func ruStoreBundleParts(downloadLinks []ruStoreDownloadURL) ([]bundlePart, error) {
+	base := -1
+	for i, downloadLink := range downloadLinks {
+		if downloadLink.isSplit() {
+			continue
+		}
+		if base >= 0 {
+			return nil, fmt.Errorf("cannot tell the base APK from the splits: both %s and %s look like a base APK", downloadLinks[base].URL, downloadLink.URL)
+		}
+		base = i
+	}
+	if base < 0 {
+		return nil, errors.New("download link response has no base APK")
+	}
+	parts := []bundlePart{{URL: downloadLinks[base].URL, AdvisoryChecksum: downloadLinks[base].Hash}}
+	for i, downloadLink := range downloadLinks {
+		if i != base {
+			splitID := strings.TrimPrefix(downloadLink.fileName(), "split_")
+			parts = append(parts, bundlePart{SplitID: splitID, URL: downloadLink.URL, AdvisoryChecksum: downloadLink.Hash})
+		}
+	}
+	return parts, nil
+}
+
+// ruStoreDownloadURL is an entry of downloadUrls in the download-link
+// response. Size is zero when the entry does not carry it. Hash is set when
+// the entry carries one. It is only an advisory checksum: its algorithm is
+// guessed from its length, and it is not known whether it covers the APK or
+// the zip RuStore sometimes wraps it in.
+type ruStoreDownloadURL struct {
+	URL  string
+	Size uint64
+	Hash Checksum
+}
+
+// fileName returns the file name of the link without .apk, e.g.
+// config.arm64_v8a for .../config.arm64_v8a.apk, or "" when the link has no
+// usable file name.
+func (u ruStoreDownloadURL) fileName() string {
+	parsed, err := neturl.Parse(u.URL)
+	if err != nil {
+		return ""
+	}
+	name := strings.TrimSuffix(path.Base(parsed.Path), ".apk")
+	if name == "." || name == "/" || strings.ContainsAny(name, `\:`) {
+		return ""
+	}
+	return name
+}
+
+func (u ruStoreDownloadURL) isSplit() bool {
+	name := u.fileName()
+	return strings.HasPrefix(name, "config.") || strings.HasPrefix(name, "split_")
 }
 
 func (s *RuStore) generateDeviceId() string {
@@ -178,8 +290,8 @@ func (s *RuStore) generateDeviceId() string {
 	return string(b1) + "-" + string(b2)
 }
 
-func (s *RuStore) getAppInfo(packageName string) (map[string]any, error) {
-	s.ensureLatestVersion()
+func (s *RuStore) getAppInfo(ctx context.Context, packageName string) (map[string]any, error) {
+	s.ensureLatestVersion(ctx)
 	s.appsCacheMu.RLock()
 	appInfo, ok := s.appsCache[packageName]
 	s.appsCacheMu.RUnlock()
@@ -189,7 +301,7 @@ func (s *RuStore) getAppInfo(packageName string) (map[string]any, error) {
 	// If the app info is not in the cache, fetch it from the API
 	// and store it in the cache
 	url := s.config.BaseURL + "/applicationData/overallInfo/" + packageName
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -236,85 +348,111 @@ func (s *RuStore) getAppInfo(packageName string) (map[string]any, error) {
 	return nil, &AppNotFoundError{PackageName: packageName}
 }
 
-func (s *RuStore) getDownloadLink(appId float64) (string, error) {
-	s.ensureLatestVersion()
+// getDownloadLink returns the link of the universal APK.
+func (s *RuStore) getDownloadLink(ctx context.Context, appId float64) (ruStoreDownloadURL, error) {
+	downloadLinks, err := s.getDownloadLinks(ctx, appId, false)
+	if err != nil {
+		return ruStoreDownloadURL{}, err
+	}
+	return downloadLinks[0], nil
+}
+
+// getDownloadLinks returns the links of every file of the app. Without splits
+// RuStore is asked for a single APK.
+func (s *RuStore) getDownloadLinks(ctx context.Context, appId float64, withSplits bool) ([]ruStoreDownloadURL, error) {
+	s.ensureLatestVersion(ctx)
 	url := s.config.BaseURL + "/applicationData/v2/download-link"
+	locales := s.config.Locales
+	if len(s.device.Locales) > 0 {
+		locales = s.device.Locales
+	}
 	payloadData := map[string]any{
 		"appId":                appId,
 		"firstInstall":         true,
 		"mobileServices":       []string{"GMS"},
 		"supportedAbis":        s.device.CPUAbis,
 		"screenDensity":        s.device.DPI,
-		"supportedLocales":     []string{"en_US", "ru_RU"},
+		"supportedLocales":     locales,
 		"sdkVersion":           s.device.SDKInt,
-		"withoutSplits":        true,
+		"withoutSplits":        !withSplits,
 		"signatureFingerprint": nil,
 	}
 	payloadBytes, err := json.Marshal(payloadData)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal download link request: %w", err)
+		return nil, fmt.Errorf("failed to marshal download link request: %w", err)
 	}
 	payload := bytes.NewReader(payloadBytes)
-	req, err := s.NewRequest("POST", url, payload)
+	req, err := s.NewRequest(ctx, "POST", url, payload)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	resp, err := s.Http().Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch download link: %w", err)
+		return nil, fmt.Errorf("failed to fetch download link: %w", err)
 	}
 
 	defer resp.Body.Close()
 	body, err := readBody(resp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusNotFound {
-			return "", &AppNotFoundError{PackageName: strconv.Itoa(int(appId))}
+			return nil, &AppNotFoundError{PackageName: strconv.Itoa(int(appId))}
 		}
-		return "", fmt.Errorf("failed to get download link (%d): %s", resp.StatusCode, body)
+		return nil, fmt.Errorf("failed to get download link (%d): %s", resp.StatusCode, body)
 	}
 	var result map[string]any
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse download link response: %w", err)
+		return nil, fmt.Errorf("failed to parse download link response: %w", err)
 	}
 	if _, ok := result["error"]; ok {
 		errMsg, ok := result["error"].(string)
 		if !ok {
-			return "", errors.New("error field is not a string in download link response")
+			return nil, errors.New("error field is not a string in download link response")
 		}
-		return "", errors.New(errMsg)
+		return nil, errors.New(errMsg)
 	}
 	if result["code"] != "OK" {
 		msg, ok := result["message"].(string)
 		if !ok {
-			return "", errors.New("message field is not a string in download link response")
+			return nil, errors.New("message field is not a string in download link response")
 		}
-		return "", errors.New(msg)
+		return nil, errors.New(msg)
 	}
 	bodyMap, ok := result["body"].(map[string]any)
 	if !ok {
-		return "", errors.New("body not found or invalid in download link response")
+		return nil, errors.New("body not found or invalid in download link response")
 	}
 	downloadUrls, ok := bodyMap["downloadUrls"].([]any)
 	if !ok || len(downloadUrls) == 0 {
-		return "", errors.New("downloadUrls not found or empty in download link response")
-	}
-	downloadUrlEntry, ok := downloadUrls[0].(map[string]any)
-	if !ok {
-		return "", errors.New("first downloadUrl entry is not a map in download link response")
+		return nil, errors.New("downloadUrls not found or empty in download link response")
 	}
-	urlStr, ok := downloadUrlEntry["url"].(string)
-	if !ok {
-		return "", errors.New("url field not found or invalid in download link response")
+	links := make([]ruStoreDownloadURL, 0, len(downloadUrls))
+	for i, entry := range downloadUrls {
+		downloadUrlEntry, ok := entry.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("downloadUrl entry %d is not a map in download link response", i)
+		}
+		urlStr, ok := downloadUrlEntry["url"].(string)
+		if !ok {
+			return nil, errors.New("url field not found or invalid in download link response")
+		}
+		downloadURL := ruStoreDownloadURL{URL: urlStr}
+		if size, ok := downloadUrlEntry["size"].(float64); ok && size > 0 {
+			downloadURL.Size = uint64(size)
+		}
+		if hash, ok := downloadUrlEntry["hash"].(string); ok {
+			downloadURL.Hash = ChecksumFromHex(hash)
+		}
+		links = append(links, downloadURL)
 	}
-	return urlStr, nil
+	return links, nil
 }
 
-func (s *RuStore) FindByPackage(packageName string, versionCode int) (Version, error) {
-	appInfo, err := s.getAppInfo(packageName)
+func (s *RuStore) FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error) {
+	appInfo, err := s.getAppInfo(ctx, packageName)
 	if err != nil {
 		return Version{}, err
 	}
@@ -345,17 +483,59 @@ func (s *RuStore) FindByPackage(packageName string, versionCode int) (Version, e
 		DeveloperId: developerId,
 		Type:        APK,
 	}
+	if s.config.Splits {
+		if err := s.describeBundle(ctx, appInfo, &version); err != nil {
+			return Version{}, err
+		}
+	}
 	return version, nil
 }
 
+// describeBundle asks for the split links of the app in bundle mode. An app
+// with splits is downloaded as APKS, sized by its parts when RuStore reports
+// the size of each; an app served as a single file stays an APK.
+func (s *RuStore) describeBundle(ctx context.Context, appInfo map[string]any, version *Version) error {
+	appId, ok := appInfo["appId"].(float64)
+	if !ok {
+		return errors.New("appId not found or invalid in app info")
+	}
+	downloadLinks, err := s.getDownloadLinks(ctx, appId, true)
+	if err != nil {
+		return err
+	}
+	if len(downloadLinks) == 1 {
+		return nil
+	}
+	version.Type = APKS
+	version.Size = 0
+	for _, downloadLink := range downloadLinks {
+		if downloadLink.Size == 0 {
+			version.Size = 0
+			break
+		}
+		version.Size += downloadLink.Size
+	}
+	return nil
+}
+
 func (s *RuStore) MaxParallelsDownloads() int {
 	return 3
 }
 
-func (s *RuStore) FindByDeveloper(developerId string) ([]string, error) {
-	s.ensureLatestVersion()
+// ListVersions returns the current version only: RuStore does not serve older
+// releases.
+func (s *RuStore) ListVersions(ctx context.Context, packageName string) ([]Version, error) {
+	version, err := s.FindByPackage(ctx, packageName, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []Version{version}, nil
+}
+
+func (s *RuStore) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
+	s.ensureLatestVersion(ctx)
 	url := s.config.BaseURL + "/applicationData/devs/" + developerId + "/apps?limit=1000"
-	req, err := s.NewRequest("GET", url, nil)
+	req, err := s.NewRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -620,21 +800,22 @@ func buildUserAgent(appVersion string, device devices.Device) string {
 func newRuStoreSource() (Source, error) {
 	s := &RuStore{
 		appsCache: make(map[string]map[string]any),
-		device:    devices.RandomDevice(),
+		device:    currentDevice(),
 	}
+	s.Source = s
 	defaultConfig := defaultRuStoreConfig()
 	config, err := ResolveSourceConfig(s.Name(), defaultConfig)
 	if err != nil {
 		return nil, err
 	}
-	s.Source = s
 	s.config = config
+	s.device = s.stableDevice(s.device)
 	s.Log().Logd(fmt.Sprintf("Initialized with device: %s %s (Android %s, SDK %d)", s.device.Brand, s.device.Model, s.device.AndroidVersion, s.device.SDKInt))
 	s.Log().Logd(fmt.Sprintf("Using config: %+v", config))
 	s.latestVersionCheckEnabled = defaultConfig.AppVersion == config.AppVersion && defaultConfig.AppVersionCode == config.AppVersionCode
 	headers := ApplyConfiguredHeaders(http.Header{
 		"User-Agent":             {buildUserAgent(s.config.AppVersion, s.device)},
-		"deviceId":               {s.generateDeviceId()},
+		"deviceId":               {s.Session().GetOrCreate("device_id", s.generateDeviceId)},
 		"deviceManufacturerName": {s.device.Manufacturer},
 		"deviceModelName":        {s.device.Model},
 		"deviceModel":            {s.device.Manufacturer + " " + s.device.Model},
@@ -659,6 +840,9 @@ func init() {
 			func(c *RuStoreConfig) {
 				NormalizeBaseSourceConfig(&c.BaseSourceConfig)
 				c.FirmwareLang = strings.ToLower(strings.TrimSpace(c.FirmwareLang))
+				for i, locale := range c.Locales {
+					c.Locales[i] = strings.ReplaceAll(strings.TrimSpace(locale), "-", "_")
+				}
 			},
 			func(c RuStoreConfig) error {
 				if err := ValidateBaseSourceConfig(c.BaseSourceConfig); err != nil {
@@ -673,6 +857,14 @@ func init() {
 				if !firmwareLangRegexp.MatchString(c.FirmwareLang) {
 					return fmt.Errorf("firmware_lang %q must match [a-z]{2,8}", c.FirmwareLang)
 				}
+				if len(c.Locales) == 0 {
+					return errors.New("locales cannot be empty")
+				}
+				for _, locale := range c.Locales {
+					if !ruStoreLocaleRegexp.MatchString(locale) {
+						return fmt.Errorf("locale %q must look like en_US", locale)
+					}
+				}
 				return nil
 			},
 		),
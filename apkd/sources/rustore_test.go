@@ -2,10 +2,14 @@ package sources
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -233,7 +237,7 @@ func TestRuStoreGetAppInfoConcurrentCacheAccess(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			_, err := s.getAppInfo(packageName)
+			_, err := s.getAppInfo(context.Background(), packageName)
 			errs <- err
 		}()
 	}
@@ -290,7 +294,7 @@ func TestRuStoreGetAppInfoCacheHit(t *testing.T) {
 		calls++
 		return okResp(req, "{}"), nil
 	})
-	if _, err := s.getAppInfo("com.cached"); err != nil {
+	if _, err := s.getAppInfo(context.Background(), "com.cached"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if calls != 0 {
@@ -302,7 +306,7 @@ func TestRuStoreGetAppInfoNotFound(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return statusResp(req, http.StatusNotFound), nil
 	})
-	_, err := s.getAppInfo("com.missing")
+	_, err := s.getAppInfo(context.Background(), "com.missing")
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError, got %T: %v", err, err)
@@ -313,7 +317,7 @@ func TestRuStoreGetAppInfoNon200(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return statusResp(req, http.StatusInternalServerError), nil
 	})
-	if _, err := s.getAppInfo("com.example"); err == nil {
+	if _, err := s.getAppInfo(context.Background(), "com.example"); err == nil {
 		t.Fatal("expected error for non-200 response")
 	}
 }
@@ -322,7 +326,7 @@ func TestRuStoreGetAppInfoInvalidJSON(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, "not json"), nil
 	})
-	if _, err := s.getAppInfo("com.example"); err == nil {
+	if _, err := s.getAppInfo(context.Background(), "com.example"); err == nil {
 		t.Fatal("expected error for invalid JSON")
 	}
 }
@@ -331,7 +335,7 @@ func TestRuStoreGetAppInfoCodeNotOK(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, `{"code":"ERROR","message":"not found"}`), nil
 	})
-	_, err := s.getAppInfo("com.example")
+	_, err := s.getAppInfo(context.Background(), "com.example")
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError for code!=OK, got %T: %v", err, err)
@@ -342,7 +346,7 @@ func TestRuStoreFindByPackageHappyPath(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, ruStoreOKAppInfo), nil
 	})
-	v, err := s.FindByPackage("com.example", 0)
+	v, err := s.FindByPackage(context.Background(), "com.example", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -355,7 +359,7 @@ func TestRuStoreFindByPackageVersionCodeMismatch(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, ruStoreOKAppInfo), nil
 	})
-	_, err := s.FindByPackage("com.example", 999)
+	_, err := s.FindByPackage(context.Background(), "com.example", 999)
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError for version code mismatch, got %T: %v", err, err)
@@ -366,12 +370,53 @@ func TestRuStoreGetDownloadLinkHappyPath(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, ruStoreOKDownloadLink), nil
 	})
-	link, err := s.getDownloadLink(1)
+	link, err := s.getDownloadLink(context.Background(), 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if link != "https://cdn.example.com/app.apk" {
-		t.Fatalf("unexpected download link: %q", link)
+	if link.URL != "https://cdn.example.com/app.apk" {
+		t.Fatalf("unexpected download link: %q", link.URL)
+	}
+	if !link.Hash.IsZero() {
+		t.Fatalf("expected no checksum without hash field, got %+v", link.Hash)
+	}
+}
+
+func TestRuStoreGetDownloadLinkParsesHash(t *testing.T) {
+	const body = `{"code":"OK","body":{"downloadUrls":[{"url":"https://cdn.example.com/app.apk","hash":"0CC175B9C0F1B6A831C399E269772661"}]}}`
+	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
+		return okResp(req, body), nil
+	})
+	link, err := s.getDownloadLink(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link.Hash.Algorithm != MD5 || link.Hash.Digest != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Fatalf("unexpected checksum: %+v", link.Hash)
+	}
+}
+
+func TestRuStoreDownloadKeepsHashAdvisory(t *testing.T) {
+	const body = `{"code":"OK","body":{"downloadUrls":[{"url":"https://cdn.example.com/app.apk","hash":"0cc175b9c0f1b6a831c399e269772661"}]}}`
+	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/applicationData/overallInfo/"):
+			return okResp(req, ruStoreOKAppInfo), nil
+		case req.URL.Path == "/applicationData/v2/download-link":
+			return okResp(req, body), nil
+		}
+		return okResp(req, "zip"), nil
+	})
+	stream, err := s.Download(context.Background(), Version{PackageName: "com.example"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stream.Body.Close()
+	if !stream.Checksum.IsZero() {
+		t.Fatalf("expected no verified checksum, got %+v", stream.Checksum)
+	}
+	if stream.AdvisoryChecksum.Digest != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Fatalf("expected the hash as advisory checksum, got %+v", stream.AdvisoryChecksum)
 	}
 }
 
@@ -379,18 +424,204 @@ func TestRuStoreGetDownloadLinkNotFound(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return statusResp(req, http.StatusNotFound), nil
 	})
-	_, err := s.getDownloadLink(1)
+	_, err := s.getDownloadLink(context.Background(), 1)
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError for 404, got %T: %v", err, err)
 	}
 }
 
+const ruStoreBundleDownloadLink = `{"code":"OK","body":{"downloadUrls":[` +
+	`{"url":"https://cdn.example.com/splits/config.arm64_v8a.apk","size":2000},` +
+	`{"url":"https://cdn.example.com/app.apk","size":10000},` +
+	`{"url":"https://cdn.example.com/splits/config.xxhdpi.apk","size":500}]}}`
+
+func mockRuStoreBundle(t *testing.T, payloads *[]map[string]any) *RuStore {
+	return mockRuStoreLinks(t, payloads, ruStoreBundleDownloadLink)
+}
+
+func mockRuStoreLinks(t *testing.T, payloads *[]map[string]any, downloadLink string) *RuStore {
+	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/applicationData/overallInfo/"):
+			return okResp(req, ruStoreOKAppInfo), nil
+		case req.URL.Path == "/applicationData/v2/download-link":
+			var payload map[string]any
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				t.Errorf("failed to decode download link payload: %v", err)
+			}
+			*payloads = append(*payloads, payload)
+			return okResp(req, downloadLink), nil
+		case req.URL.Host == "cdn.example.com":
+			return okResp(req, "apk "+path.Base(req.URL.Path)), nil
+		}
+		return statusResp(req, http.StatusNotFound), nil
+	})
+	s.device = devices.Device{SDKInt: 34, DPI: 480, CPUAbis: []string{"arm64-v8a"}}
+	s.config.Splits = true
+	s.config.Locales = []string{"de_DE"}
+	return s
+}
+
+func TestRuStoreFindByPackageBundleMode(t *testing.T) {
+	var payloads []map[string]any
+	s := mockRuStoreBundle(t, &payloads)
+	v, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Type != APKS {
+		t.Fatalf("expected APKS in bundle mode, got %q", v.Type)
+	}
+	if v.Size != 12500 {
+		t.Fatalf("expected the size of every part, got %d", v.Size)
+	}
+}
+
+func TestRuStoreBundleModeKeepsSingleApk(t *testing.T) {
+	var payloads []map[string]any
+	s := mockRuStoreLinks(t, &payloads, ruStoreOKDownloadLink)
+	v, err := s.FindByPackage(context.Background(), "com.example", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Type != APK || v.Size != 123456 {
+		t.Fatalf("expected a plain APK for a single download link, got %+v", v)
+	}
+	stream, err := s.Download(context.Background(), v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := io.ReadAll(stream.Body)
+	stream.Body.Close()
+	if string(data) != "apk app.apk" {
+		t.Fatalf("expected the APK itself, got %q", data)
+	}
+	if len(payloads) != 2 || payloads[1]["withoutSplits"] != true {
+		t.Fatalf("expected the download to ask for the universal APK, got %v", payloads)
+	}
+}
+
+func TestRuStoreDownloadBundlesSplits(t *testing.T) {
+	var payloads []map[string]any
+	s := mockRuStoreBundle(t, &payloads)
+	stream, err := s.Download(context.Background(), Version{PackageName: "com.example", Type: APKS})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Body.Close()
+	data, err := io.ReadAll(stream.Body)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 download link request, got %d", len(payloads))
+	}
+	if payloads[0]["withoutSplits"] != false {
+		t.Fatalf("expected withoutSplits=false, got %v", payloads[0]["withoutSplits"])
+	}
+	if locales, _ := payloads[0]["supportedLocales"].([]any); len(locales) != 1 || locales[0] != "de_DE" {
+		t.Fatalf("unexpected supportedLocales: %v", payloads[0]["supportedLocales"])
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("bundle is not a zip: %v", err)
+	}
+	expected := []string{"toc.pb", "base.apk", "split_config.arm64_v8a.apk", "split_config.xxhdpi.apk"}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected entries: got=%v expected=%v", names, expected)
+	}
+	entry, err := archive.File[2].Open()
+	if err != nil {
+		t.Fatalf("failed to open split: %v", err)
+	}
+	content, _ := io.ReadAll(entry)
+	entry.Close()
+	if string(content) != "apk config.arm64_v8a.apk" {
+		t.Fatalf("unexpected split content: %q", content)
+	}
+}
+
+func TestRuStoreDeviceLocalesTakePrecedence(t *testing.T) {
+	var payloads []map[string]any
+	s := mockRuStoreBundle(t, &payloads)
+	s.device.Locales = []string{"fr_FR", "en_GB"}
+	if _, err := s.getDownloadLinks(context.Background(), 1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if locales, _ := payloads[0]["supportedLocales"].([]any); len(locales) != 2 || locales[0] != "fr_FR" {
+		t.Fatalf("unexpected supportedLocales: %v", payloads[0]["supportedLocales"])
+	}
+}
+
+func TestRuStoreBundlePartsNaming(t *testing.T) {
+	parts, err := ruStoreBundleParts([]ruStoreDownloadURL{
+		{URL: "https://cdn.example.com/config.ru.apk"},
+		{URL: "https://cdn.example.com/split_feature.apk"},
+		{URL: "https://cdn.example.com/app.apk"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, part := range parts {
+		names = append(names, part.Name())
+	}
+	expected := []string{"base.apk", "split_config.ru.apk", "split_feature.apk"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected part names: got=%v expected=%v", names, expected)
+	}
+	if parts[0].URL != "https://cdn.example.com/app.apk" {
+		t.Fatalf("expected the base APK to be found by name, got %s", parts[0].URL)
+	}
+
+	if _, err := ruStoreBundleParts([]ruStoreDownloadURL{
+		{URL: "https://cdn.example.com/app.apk"},
+		{URL: "https://cdn.example.com/other.apk"},
+	}); err == nil {
+		t.Fatalf("expected an error for two base candidates")
+	}
+	if _, err := ruStoreBundleParts([]ruStoreDownloadURL{
+		{URL: "https://cdn.example.com/config.ru.apk"},
+	}); err == nil {
+		t.Fatalf("expected an error without a base APK")
+	}
+}
+
+func TestDecodeRuStoreConfigLocales(t *testing.T) {
+	decode := func(text string) (any, error) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(text), &node); err != nil {
+			t.Fatalf("failed to unmarshal yaml node: %v", err)
+		}
+		return DecodeSourceConfig("rustore", node.Content[0])
+	}
+	configAny, err := decode("{splits: true, locales: [en-GB, fr]}")
+	if err != nil {
+		t.Fatalf("unexpected config decode error: %v", err)
+	}
+	config := configAny.(RuStoreConfig)
+	if !config.Splits || !reflect.DeepEqual(config.Locales, []string{"en_GB", "fr"}) {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	for _, text := range []string{"{locales: []}", "{locales: [english]}"} {
+		if _, err := decode(text); err == nil {
+			t.Fatalf("expected decode error for %s", text)
+		}
+	}
+}
+
 func TestRuStoreFindByDeveloperHappyPath(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, ruStoreOKDevApps), nil
 	})
-	pkgs, err := s.FindByDeveloper("dev123")
+	pkgs, err := s.FindByDeveloper(context.Background(), "dev123")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -403,7 +634,7 @@ func TestRuStoreFindByDeveloperNotFound(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return statusResp(req, http.StatusNotFound), nil
 	})
-	_, err := s.FindByDeveloper("nobody")
+	_, err := s.FindByDeveloper(context.Background(), "nobody")
 	var notFound *AppNotFoundError
 	if !errors.As(err, &notFound) {
 		t.Fatalf("expected AppNotFoundError for 404, got %T: %v", err, err)
@@ -415,7 +646,7 @@ func TestRuStoreGetLatestVersionParsesResponse(t *testing.T) {
 	s := mockRuStore(func(req *http.Request) (*http.Response, error) {
 		return okResp(req, body), nil
 	})
-	update, err := s.getLatestRustoreVersion()
+	update, err := s.getLatestRustoreVersion(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -424,6 +655,29 @@ func TestRuStoreGetLatestVersionParsesResponse(t *testing.T) {
 	}
 }
 
+func TestRuStoreLatestVersionIsCachedInSession(t *testing.T) {
+	useSessionDir(t)
+	const body = `{"body":{"latestVersion":"1103100","latestVersionName":"1.103.1.0"}}`
+	calls := 0
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return okResp(req, body), nil
+	})
+	for range 2 {
+		s := mockRuStore(doer)
+		update, err := s.latestRustoreVersion(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if update.Body.LatestVersionCode != "1103100" || update.Body.LatestVersionName != "1.103.1.0" {
+			t.Fatalf("unexpected update: %+v", update.Body)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the second run to reuse the session, got %d requests", calls)
+	}
+}
+
 func TestBuildUserAgent(t *testing.T) {
 	device := devices.Device{
 		AndroidVersion: "12",
@@ -449,7 +703,7 @@ func TestRuStoreIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create source: %v", err)
 	}
-	v, err := src.FindByPackage("com.vkontakte.android", 0)
+	v, err := src.FindByPackage(context.Background(), "com.vkontakte.android", 0)
 	if err != nil {
 		t.Fatalf("FindByPackage: %v", err)
 	}
@@ -458,7 +712,7 @@ func TestRuStoreIntegration(t *testing.T) {
 	}
 	t.Logf("version: %s (%d), size: %d", v.Name, v.Code, v.Size)
 
-	stream, err := src.Download(v)
+	stream, err := src.Download(context.Background(), v)
 	if err != nil {
 		t.Fatalf("Download: %v", err)
 	}
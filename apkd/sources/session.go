@@ -0,0 +1,337 @@
+package sources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/logging"
+	"github.com/kiber-io/apkd/apkd/network"
+)
+
+const sessionFileExt = ".json"
+
+var sessionDirMu sync.RWMutex
+var sessionDir string
+
+// DefaultSessionDir returns the directory sessions are kept in: apkd/sessions
+// under $XDG_STATE_HOME or ~/.local/state, or under the user config directory
+// on Windows and macOS.
+func DefaultSessionDir() (string, error) {
+	if stateDir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(stateDir) {
+		return filepath.Join(stateDir, "apkd", "sessions"), nil
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configDir, "apkd", "sessions"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "state", "apkd", "sessions"), nil
+}
+
+// ConfigureSessionDir sets the directory sources created afterwards persist
+// their sessions in. Empty keeps sessions in memory for the run.
+func ConfigureSessionDir(dir string) {
+	sessionDirMu.Lock()
+	sessionDir = dir
+	sessionDirMu.Unlock()
+}
+
+func configuredSessionDir() string {
+	sessionDirMu.RLock()
+	defer sessionDirMu.RUnlock()
+	return sessionDir
+}
+
+// ResetSessions deletes the session of sourceName from dir, or every session
+// when sourceName is empty. It returns the names of the deleted sessions.
+func ResetSessions(dir string, sourceName string) ([]string, error) {
+	sourceName = normalizeSourceName(sourceName)
+	if sourceName != "" {
+		err := os.Remove(filepath.Join(dir, sessionFileName(sourceName)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete session of %s: %w", sourceName, err)
+		}
+		return []string{sourceName}, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != sessionFileExt {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to delete session %s: %w", entry.Name(), err)
+		}
+		removed = append(removed, strings.TrimSuffix(entry.Name(), sessionFileExt))
+	}
+	return removed, nil
+}
+
+// sessionFileName maps a source name to its session file. Repository names
+// may contain characters that are not valid in file names.
+func sessionFileName(sourceName string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, sourceName) + sessionFileExt
+}
+
+type sessionValue struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// sessionCookie is a cookie as it was set, replayed into the jar on load.
+type sessionCookie struct {
+	URL      string    `json:"url"`
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+}
+
+func (c sessionCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+func (c sessionCookie) sameCookie(other sessionCookie) bool {
+	if c.Name != other.Name || c.Domain != other.Domain || c.Path != other.Path {
+		return false
+	}
+	// Host-only cookies belong to the host they were set by.
+	return c.Domain != "" || sessionCookieHost(c.URL) == sessionCookieHost(other.URL)
+}
+
+func sessionCookieHost(rawURL string) string {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+type sessionFile struct {
+	Values  map[string]sessionValue `json:"values,omitempty"`
+	Cookies []sessionCookie         `json:"cookies,omitempty"`
+}
+
+// Session is the state a source keeps between runs: identifiers, discovered
+// values and cookies. It is saved after every change when a session directory
+// is configured, and lives in memory otherwise.
+type Session struct {
+	mu      sync.Mutex
+	path    string
+	logger  *logging.Logger
+	data    sessionFile
+	jar     *cookiejar.Jar
+	nowFunc func() time.Time
+}
+
+func newSession(path string, logger *logging.Logger) *Session {
+	return &Session{
+		path:    path,
+		logger:  logger,
+		data:    sessionFile{Values: make(map[string]sessionValue)},
+		jar:     network.NewCookieJar(),
+		nowFunc: time.Now,
+	}
+}
+
+// openSession loads the session of sourceName from dir. A missing or
+// unreadable file starts an empty session, which replaces it on the next
+// change.
+func openSession(dir string, sourceName string, logger *logging.Logger) *Session {
+	if dir == "" {
+		return newSession("", logger)
+	}
+	s := newSession(filepath.Join(dir, sessionFileName(sourceName)), logger)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &s.data)
+	}
+	if err != nil {
+		logger.Logw(fmt.Sprintf("Ignoring session %s: %v", s.path, err))
+		s.data = sessionFile{}
+	}
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]sessionValue)
+	}
+	now := s.nowFunc()
+	for _, cookie := range s.data.Cookies {
+		if cookie.expired(now) {
+			continue
+		}
+		parsed, err := neturl.Parse(cookie.URL)
+		if err != nil {
+			continue
+		}
+		s.jar.SetCookies(parsed, []*http.Cookie{{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Expires:  cookie.Expires,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}})
+	}
+	return s
+}
+
+// Get returns the value stored under key, unless it expired.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, exists := s.data.Values[key]
+	if !exists || (!value.Expires.IsZero() && !value.Expires.After(s.nowFunc())) {
+		return "", false
+	}
+	return value.Value, true
+}
+
+// Set stores value under key. With ttl > 0 the value expires after ttl.
+func (s *Session) Set(key string, value string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := sessionValue{Value: value}
+	if ttl > 0 {
+		entry.Expires = s.nowFunc().Add(ttl)
+	}
+	s.data.Values[key] = entry
+	s.save()
+}
+
+// GetOrCreate returns the value stored under key, storing the result of
+// create when there is none. The value does not expire.
+func (s *Session) GetOrCreate(key string, create func() string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, exists := s.data.Values[key]; exists && value.Expires.IsZero() {
+		return value.Value
+	}
+	value := create()
+	s.data.Values[key] = sessionValue{Value: value}
+	s.save()
+	return value
+}
+
+// CookieJar returns a jar whose cookies are kept in the session.
+func (s *Session) CookieJar() http.CookieJar {
+	return sessionJar{session: s}
+}
+
+func (s *Session) setCookies(u *neturl.URL, cookies []*http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jar.SetCookies(u, cookies)
+	now := s.nowFunc()
+	origin := (&neturl.URL{Scheme: u.Scheme, Host: u.Host}).String()
+	for _, cookie := range cookies {
+		stored := sessionCookie{
+			URL:      origin,
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   strings.TrimPrefix(strings.ToLower(cookie.Domain), "."),
+			Path:     cookie.Path,
+			Expires:  cookie.Expires,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		switch {
+		case cookie.MaxAge < 0:
+			stored.Expires = now
+		case cookie.MaxAge > 0:
+			stored.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+		kept := s.data.Cookies[:0]
+		for _, existing := range s.data.Cookies {
+			if !existing.sameCookie(stored) && !existing.expired(now) {
+				kept = append(kept, existing)
+			}
+		}
+		s.data.Cookies = kept
+		if !stored.expired(now) {
+			s.data.Cookies = append(s.data.Cookies, stored)
+		}
+	}
+	s.save()
+}
+
+// save writes the session to its file. Failures are logged: a session that
+// cannot be saved still works for the current run.
+func (s *Session) save() {
+	if s.path == "" {
+		return
+	}
+	if err := s.write(); err != nil {
+		s.logger.Logw(fmt.Sprintf("Failed to save session %s: %v", s.path, err))
+	}
+}
+
+func (s *Session) write() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+type sessionJar struct {
+	session *Session
+}
+
+func (j sessionJar) SetCookies(u *neturl.URL, cookies []*http.Cookie) {
+	j.session.setCookies(u, cookies)
+}
+
+func (j sessionJar) Cookies(u *neturl.URL) []*http.Cookie {
+	return j.session.jar.Cookies(u)
+}
@@ -0,0 +1,142 @@
+package sources
+
+import (
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/logging"
+)
+
+func useSessionDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	ConfigureSessionDir(dir)
+	t.Cleanup(func() {
+		ConfigureSessionDir("")
+	})
+	return dir
+}
+
+func TestSessionValuesExpire(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newSession("", logging.Named("test"))
+	s.nowFunc = func() time.Time { return now }
+
+	s.Set("version", "1.0", time.Hour)
+	if value, ok := s.Get("version"); !ok || value != "1.0" {
+		t.Fatalf("unexpected value: %q %v", value, ok)
+	}
+	now = now.Add(time.Hour)
+	if _, ok := s.Get("version"); ok {
+		t.Fatalf("expected the value to expire")
+	}
+
+	first := s.GetOrCreate("id", func() string { return "a" })
+	second := s.GetOrCreate("id", func() string { return "b" })
+	if first != "a" || second != "a" {
+		t.Fatalf("expected a stable value, got %q and %q", first, second)
+	}
+}
+
+func TestSessionPersistsValuesAndCookies(t *testing.T) {
+	dir := t.TempDir()
+	logger := logging.Named("test")
+	site, _ := neturl.Parse("https://store.example/api/apps")
+
+	s := openSession(dir, "store", logger)
+	s.Set("device_id", "abc", 0)
+	jar := s.CookieJar()
+	jar.SetCookies(site, []*http.Cookie{
+		{Name: "sid", Value: "1", Path: "/"},
+		{Name: "tracking", Value: "x", Path: "/", MaxAge: 3600},
+	})
+	jar.SetCookies(site, []*http.Cookie{{Name: "tracking", Value: "", Path: "/", MaxAge: -1}})
+	jar.SetCookies(site, []*http.Cookie{{Name: "sid", Value: "2", Path: "/"}})
+
+	reopened := openSession(dir, "store", logger)
+	if value, ok := reopened.Get("device_id"); !ok || value != "abc" {
+		t.Fatalf("unexpected device_id after reload: %q %v", value, ok)
+	}
+	cookies := reopened.CookieJar().Cookies(site)
+	if len(cookies) != 1 || cookies[0].Name != "sid" || cookies[0].Value != "2" {
+		t.Fatalf("unexpected cookies after reload: %v", cookies)
+	}
+	if other, _ := neturl.Parse("https://other.example/"); len(reopened.CookieJar().Cookies(other)) != 0 {
+		t.Fatalf("host-only cookie leaked to another host")
+	}
+}
+
+func TestSessionIgnoresCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("failed to write session: %v", err)
+	}
+	s := openSession(dir, "store", logging.Named("test"))
+	if _, ok := s.Get("device_id"); ok {
+		t.Fatalf("expected an empty session")
+	}
+	s.Set("device_id", "abc", 0)
+	if value, ok := openSession(dir, "store", logging.Named("test")).Get("device_id"); !ok || value != "abc" {
+		t.Fatalf("expected the corrupt file to be replaced, got %q %v", value, ok)
+	}
+}
+
+func TestResetSessions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"rustore", "googleplay"} {
+		openSession(dir, name, logging.Named("test")).Set("device_id", name, 0)
+	}
+
+	removed, err := ResetSessions(dir, "RuStore")
+	if err != nil || len(removed) != 1 || removed[0] != "rustore" {
+		t.Fatalf("unexpected reset result: %v, %v", removed, err)
+	}
+	if removed, err := ResetSessions(dir, "rustore"); err != nil || len(removed) != 0 {
+		t.Fatalf("expected nothing to reset, got %v, %v", removed, err)
+	}
+	removed, err = ResetSessions(dir, "")
+	if err != nil || len(removed) != 1 || removed[0] != "googleplay" {
+		t.Fatalf("unexpected reset result: %v, %v", removed, err)
+	}
+	if removed, err := ResetSessions(filepath.Join(dir, "missing"), ""); err != nil || len(removed) != 0 {
+		t.Fatalf("expected a missing directory to be empty, got %v, %v", removed, err)
+	}
+}
+
+func TestDefaultSessionDirUsesXDGStateHome(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateDir)
+	dir, err := DefaultSessionDir()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dir != filepath.Join(stateDir, "apkd", "sessions") {
+		t.Fatalf("unexpected session dir: %s", dir)
+	}
+}
+
+func TestBaseSourceStableDevice(t *testing.T) {
+	useSessionDir(t)
+	device := testApkMirrorDevice()
+	device.Fingerprint = "google/panther/panther:14/UP1A.231005.007:user/release-keys"
+	device.AndroidID = "1111111111111111"
+	first := mockRuStore(nil)
+	if got := first.stableDevice(device).AndroidID; got != "1111111111111111" {
+		t.Fatalf("unexpected android id: %s", got)
+	}
+
+	device.AndroidID = "2222222222222222"
+	second := mockRuStore(nil)
+	if got := second.stableDevice(device).AndroidID; got != "1111111111111111" {
+		t.Fatalf("expected the android id of the previous run, got %s", got)
+	}
+	device.Fingerprint = "google/panther/panther:13/TQ3A.230805.001:user/release-keys"
+	if got := second.stableDevice(device).AndroidID; got != "2222222222222222" {
+		t.Fatalf("expected a new android id for another build, got %s", got)
+	}
+}
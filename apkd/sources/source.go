@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"cmp"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -8,13 +9,18 @@ import (
 	"io"
 	"maps"
 	"net/http"
+	neturl "net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/kiber-io/apkd/apkd/devices"
 	"github.com/kiber-io/apkd/apkd/logging"
 	"github.com/kiber-io/apkd/apkd/network"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/vbauerster/mpb/v8"
 )
 
@@ -22,23 +28,116 @@ import (
 // read from; Size is the exact byte count that will arrive, taken from the
 // HTTP Content-Length header. Size is -1 when the server did not send a
 // Content-Length (e.g. chunked transfer, transparent gzip decompression).
+//
+// Offset is the position in the file at which Body starts. It is non-zero only
+// when a ByteRange was requested and the server honoured it; a server that
+// ignores ranges yields Offset 0 and the whole file. URL, ETag and
+// LastModified describe the response so that an interrupted download can be
+// resumed later.
+//
+// Checksum, when set, overrides Version.Checksum for sources that learn the
+// file digest only when the download link is issued. AdvisoryChecksum is a
+// digest whose meaning is not confirmed: it is checked only when no other
+// checksum is known, and a mismatch is logged instead of failing the
+// download.
 type DownloadStream struct {
-	Body io.ReadCloser
-	Size int64
+	Body             io.ReadCloser
+	Size             int64
+	Offset           int64
+	URL              string
+	ETag             string
+	LastModified     string
+	Checksum         Checksum
+	AdvisoryChecksum Checksum
 }
 
+// ByteRange asks a RangeDownloader to resume a download. Offset is the number
+// of bytes already stored locally. Validator is the ETag or Last-Modified
+// value of the response those bytes came from; it is sent as If-Range so the
+// server returns the full file if it has changed.
+type ByteRange struct {
+	Offset    int64
+	Validator string
+}
+
+// RangeDownloader is implemented by sources that can resume a download from
+// an offset.
+type RangeDownloader interface {
+	DownloadRange(ctx context.Context, version Version, byteRange ByteRange) (*DownloadStream, error)
+}
+
+// DownloadFrom downloads the version from the source, resuming at byteRange
+// when the source supports it. Callers must check DownloadStream.Offset: it
+// is 0 when the range could not be applied.
+func DownloadFrom(ctx context.Context, s Source, version Version, byteRange ByteRange) (*DownloadStream, error) {
+	if rangeDownloader, ok := s.(RangeDownloader); ok && byteRange.Offset > 0 {
+		return rangeDownloader.DownloadRange(ctx, version, byteRange)
+	}
+	return s.Download(ctx, version)
+}
+
+// VersionLister is implemented by sources that can enumerate every version
+// they offer for a package, not only the latest one.
+type VersionLister interface {
+	ListVersions(ctx context.Context, packageName string) ([]Version, error)
+}
+
+// ListVersions returns the versions the source offers for the package, newest
+// first. Sources that do not implement VersionLister report their latest
+// version only.
+func ListVersions(ctx context.Context, s Source, packageName string) ([]Version, error) {
+	lister, ok := s.(VersionLister)
+	if !ok {
+		version, err := s.FindByPackage(ctx, packageName, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Version{version}, nil
+	}
+	versions, err := lister.ListVersions(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(versions, func(a, b Version) int {
+		return cmp.Compare(b.Code, a.Code)
+	})
+	return versions, nil
+}
+
+// ConfigChecker is implemented by sources that cannot work until the config
+// file provides some settings, such as account credentials. CheckConfig
+// reports what is missing.
+type ConfigChecker interface {
+	CheckConfig() error
+}
+
+// CheckConfig returns the configuration error of the source, if it reports
+// one.
+func CheckConfig(s Source) error {
+	if checker, ok := s.(ConfigChecker); ok {
+		return checker.CheckConfig()
+	}
+	return nil
+}
+
+// Source is a store apkd can search and download from. The context passed to
+// each method is cancelled when the run is interrupted; implementations must
+// use it for every request they make (see BaseSource.NewRequest).
 type Source interface {
 	MaxParallelsDownloads() int
 	Name() string
-	FindByPackage(packageName string, versionCode int) (Version, error)
-	FindByDeveloper(developerId string) ([]string, error)
-	Download(version Version) (*DownloadStream, error)
+	FindByPackage(ctx context.Context, packageName string, versionCode int) (Version, error)
+	FindByDeveloper(ctx context.Context, developerId string) ([]string, error)
+	Download(ctx context.Context, version Version) (*DownloadStream, error)
 }
 
 type BaseSource struct {
 	Source
 	Net            network.Doer
 	DefaultHeaders http.Header
+
+	sessionOnce sync.Once
+	session     *Session
 }
 
 type Error struct {
@@ -55,7 +154,7 @@ func (s *BaseSource) MaxParallelsDownloads() int {
 	return 1
 }
 
-func (s *BaseSource) FindByDeveloper(developerId string) ([]string, error) {
+func (s *BaseSource) FindByDeveloper(ctx context.Context, developerId string) ([]string, error) {
 	return []string{}, nil
 }
 
@@ -70,13 +169,42 @@ func (s *BaseSource) Log() *logging.Logger {
 	return logging.Named(loggerName)
 }
 
+// Session returns the state the source keeps between runs. It is opened on
+// first use from the directory set with ConfigureSessionDir.
+func (s *BaseSource) Session() *Session {
+	s.sessionOnce.Do(func() {
+		s.session = openSession(configuredSessionDir(), normalizeSourceName(s.Name()), s.Log())
+	})
+	return s.session
+}
+
+// stableDevice keeps the Android ID of device from the previous runs, so the
+// source keeps presenting the same device. The ID is bound to the build
+// fingerprint: another device profile gets its own ID.
+func (s *BaseSource) stableDevice(device devices.Device) devices.Device {
+	device.AndroidID = s.Session().GetOrCreate("android_id/"+device.Fingerprint, func() string {
+		return device.AndroidID
+	})
+	return device
+}
+
 type FileType string
 
 const (
 	APK  FileType = "apk"
 	XAPK FileType = "xapk"
+	// APKS is a zip of a base.apk and its split APKs, as built by bundletool.
+	APKS FileType = "apks"
+	// APKM is APKMirror's split APK bundle: a zip of base.apk, the split
+	// APKs and an info.json.
+	APKM FileType = "apkm"
 )
 
+// FileTypes lists every type of file a source can download.
+func FileTypes() []FileType {
+	return []FileType{APK, XAPK, APKS, APKM}
+}
+
 type Version struct {
 	Name        string
 	Code        int
@@ -85,6 +213,11 @@ type Version struct {
 	PackageName string
 	DeveloperId string
 	Type        FileType
+	Checksum    Checksum
+	// Signers lists the SHA-256 digests (lowercase hex) of the signing
+	// certificates the source says the file is signed with, if it publishes
+	// them. They act as the default signature pin for the package.
+	Signers []string
 }
 
 type ProgressReader struct {
@@ -101,6 +234,18 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 	return n, err //nolint:wrapcheck // io.EOF must pass through per io.Reader contract
 }
 
+// ChecksumMismatchError is returned when a downloaded file does not match the
+// checksum published by its source.
+type ChecksumMismatchError struct {
+	Path     string
+	Expected Checksum
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s %s, got %s", e.Path, e.Expected.Algorithm, e.Expected.Digest, e.Actual)
+}
+
 type AppNotFoundError struct {
 	PackageName string
 }
@@ -146,6 +291,10 @@ func InitializeRegisteredSources() error {
 				initializeRegisteredSourcesErr = fmt.Errorf("failed to initialize source from factory #%d: %w", i+1, err)
 				return
 			}
+			if err := configureCookies(source); err != nil {
+				initializeRegisteredSourcesErr = err
+				return
+			}
 			if err := Register(source); err != nil {
 				initializeRegisteredSourcesErr = fmt.Errorf("failed to register source %s: %w", source.Name(), err)
 				return
@@ -199,16 +348,75 @@ func unpackResponse(res *http.Response) (io.ReadCloser, error) {
 	}
 }
 
+// fetchDocument fetches an HTML page and returns it together with the URL it
+// was served from after redirects.
+func (s *BaseSource) fetchDocument(ctx context.Context, url string) (*goquery.Document, *neturl.URL, error) {
+	req, err := s.NewRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := s.Http().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+	reader, err := unpackResponse(res)
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+		if reader != res.Body {
+			_ = res.Body.Close()
+		}
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("error: %s", res.Status)
+	}
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	var resolvedURL *neturl.URL
+	if res.Request != nil && res.Request.URL != nil {
+		resolvedURL = new(neturl.URL)
+		*resolvedURL = *res.Request.URL
+	}
+	return doc, resolvedURL, nil
+}
+
 func createResponseReader(httpClient network.Doer, req *http.Request) (*DownloadStream, error) {
+	return createRangeResponseReader(httpClient, req, ByteRange{})
+}
+
+func createRangeResponseReader(httpClient network.Doer, req *http.Request, byteRange ByteRange) (*DownloadStream, error) {
 	if httpClient == nil {
 		httpClient = network.DefaultClient()
 	}
+	if byteRange.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", byteRange.Offset))
+		if byteRange.Validator != "" {
+			req.Header.Set("If-Range", byteRange.Validator)
+		}
+	}
 	req = network.WithoutClientTimeout(req)
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
+	var offset int64
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusPartialContent && byteRange.Offset > 0:
+		start, err := parseContentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != byteRange.Offset {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				return nil, fmt.Errorf("unexpected Content-Range %q: failed to close response body: %w", resp.Header.Get("Content-Range"), closeErr)
+			}
+			return nil, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), byteRange.Offset)
+		}
+		offset = start
+	default:
 		if resp.Body != nil {
 			if closeErr := resp.Body.Close(); closeErr != nil {
 				return nil, fmt.Errorf("error %s: failed to close response body: %w", resp.Status, closeErr)
@@ -216,11 +424,41 @@ func createResponseReader(httpClient network.Doer, req *http.Request) (*Download
 		}
 		return nil, fmt.Errorf("error: %s", resp.Status)
 	}
-	return &DownloadStream{Body: resp.Body, Size: resp.ContentLength}, nil
+	stream := &DownloadStream{
+		Body:         resp.Body,
+		Size:         resp.ContentLength,
+		Offset:       offset,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		stream.URL = resp.Request.URL.String()
+	} else if req.URL != nil {
+		stream.URL = req.URL.String()
+	}
+	return stream, nil
+}
+
+// parseContentRangeStart returns the first byte position of a
+// "bytes start-end/total" Content-Range header value.
+func parseContentRangeStart(contentRange string) (int64, error) {
+	rangeSpec, found := strings.CutPrefix(strings.TrimSpace(contentRange), "bytes ")
+	if !found {
+		return 0, fmt.Errorf("unsupported Content-Range %q", contentRange)
+	}
+	startText, _, found := strings.Cut(rangeSpec, "-")
+	if !found {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(startText), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q: %w", contentRange, err)
+	}
+	return start, nil
 }
 
-func (s *BaseSource) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
-	ctx := network.WithModule(context.Background(), s.Name())
+func (s *BaseSource) NewRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	ctx = network.WithModule(ctx, s.Name())
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
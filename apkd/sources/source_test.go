@@ -36,14 +36,26 @@ func (r *trackingReadCloser) Close() error {
 	return r.closeErr
 }
 
-func (s stubSource) MaxParallelsDownloads() int                 { return 1 }
-func (s stubSource) Name() string                               { return s.name }
-func (s stubSource) FindByPackage(string, int) (Version, error) { return Version{}, nil }
-func (s stubSource) FindByDeveloper(string) ([]string, error)   { return nil, nil }
-func (s stubSource) Download(Version) (*DownloadStream, error) {
+func (s stubSource) MaxParallelsDownloads() int { return 1 }
+func (s stubSource) Name() string               { return s.name }
+func (s stubSource) FindByPackage(context.Context, string, int) (Version, error) {
+	return Version{}, nil
+}
+func (s stubSource) FindByDeveloper(context.Context, string) ([]string, error) { return nil, nil }
+func (s stubSource) Download(context.Context, Version) (*DownloadStream, error) {
 	return &DownloadStream{Body: io.NopCloser(strings.NewReader("")), Size: -1}, nil
 }
 
+func TestListVersionsFallsBackToLatestVersion(t *testing.T) {
+	versions, err := ListVersions(context.Background(), stubSource{name: "stub"}, "com.example")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("expected the latest version only, got %+v", versions)
+	}
+}
+
 func TestRegisterValidatesNameAndDuplicates(t *testing.T) {
 	oldSources := sources
 	sources = map[string]Source{}
@@ -264,3 +276,101 @@ func setClientTimeout(t *testing.T, d time.Duration) {
 	}
 	t.Cleanup(network.ResetClientDefaults)
 }
+
+func TestCreateRangeResponseReaderResumesPartialContent(t *testing.T) {
+	var gotRange, gotIfRange string
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		gotRange = req.Header.Get("Range")
+		gotIfRange = req.Header.Get("If-Range")
+		return &http.Response{
+			StatusCode:    http.StatusPartialContent,
+			Status:        "206 Partial Content",
+			Header:        http.Header{"Content-Range": {"bytes 4-9/10"}, "Etag": {`"abc"`}},
+			Body:          io.NopCloser(strings.NewReader("456789")),
+			ContentLength: 6,
+			Request:       req,
+		}, nil
+	})
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/app.apk", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	stream, err := createRangeResponseReader(doer, req, ByteRange{Offset: 4, Validator: `"abc"`})
+	if err != nil {
+		t.Fatalf("unexpected createRangeResponseReader error: %v", err)
+	}
+	defer stream.Body.Close()
+	if gotRange != "bytes=4-" || gotIfRange != `"abc"` {
+		t.Fatalf("unexpected range headers: Range=%q If-Range=%q", gotRange, gotIfRange)
+	}
+	if stream.Offset != 4 || stream.Size != 6 {
+		t.Fatalf("unexpected stream offset/size: %d/%d", stream.Offset, stream.Size)
+	}
+	if stream.ETag != `"abc"` || stream.URL != "https://example.com/app.apk" {
+		t.Fatalf("unexpected stream metadata: %+v", stream)
+	}
+}
+
+func TestCreateRangeResponseReaderFallsBackToFullResponse(t *testing.T) {
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Status:        "200 OK",
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader("0123456789")),
+			ContentLength: 10,
+			Request:       req,
+		}, nil
+	})
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/app.apk", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	stream, err := createRangeResponseReader(doer, req, ByteRange{Offset: 4})
+	if err != nil {
+		t.Fatalf("unexpected createRangeResponseReader error: %v", err)
+	}
+	defer stream.Body.Close()
+	if stream.Offset != 0 || stream.Size != 10 {
+		t.Fatalf("expected full download when range is ignored, got offset=%d size=%d", stream.Offset, stream.Size)
+	}
+}
+
+func TestCreateRangeResponseReaderRejectsMismatchedContentRange(t *testing.T) {
+	body := &trackingReadCloser{Reader: strings.NewReader("")}
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Status:     "206 Partial Content",
+			Header:     http.Header{"Content-Range": {"bytes 0-9/10"}},
+			Body:       body,
+			Request:    req,
+		}, nil
+	})
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/app.apk", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	if _, err := createRangeResponseReader(doer, req, ByteRange{Offset: 4}); err == nil {
+		t.Fatalf("expected error for mismatched Content-Range")
+	}
+	if !body.closed {
+		t.Fatalf("expected response body to be closed")
+	}
+}
+
+func TestParseContentRangeStart(t *testing.T) {
+	start, err := parseContentRangeStart("bytes 100-199/200")
+	if err != nil || start != 100 {
+		t.Fatalf("unexpected parse result: %d, %v", start, err)
+	}
+	if _, err := parseContentRangeStart("items 1-2/3"); err == nil {
+		t.Fatalf("expected error for unsupported unit")
+	}
+	if _, err := parseContentRangeStart("bytes x-1/2"); err == nil {
+		t.Fatalf("expected error for invalid start")
+	}
+}
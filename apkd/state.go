@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+// stateFileName is the default state file, kept in the output directory so
+// that every mirror has its own history.
+const stateFileName = ".apkd-state.jsonl"
+
+// dataStateFileName is the state file in the data directory, used when no
+// output directory is set.
+const dataStateFileName = "state.jsonl"
+
+// runState records successful downloads. It is nil when the command does not
+// download anything.
+var runState *stateStore
+
+// stateRecord describes one successful download.
+type stateRecord struct {
+	Package      string    `json:"package"`
+	VersionCode  int       `json:"version_code"`
+	VersionName  string    `json:"version_name"`
+	Source       string    `json:"source"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	Signers      []string  `json:"signers,omitempty"`
+	Path         string    `json:"path"`
+}
+
+// stateStore is an append-only JSON-lines file of stateRecords. Records are
+// also kept in memory for lookups. Methods on a nil store do nothing.
+type stateStore struct {
+	mu      sync.Mutex
+	path    string
+	records []stateRecord
+}
+
+// resolveStatePath returns --state, or the state file in the output
+// directory. Without an output directory the state is kept in the data
+// directory, so downloads to the current directory leave no state file there.
+func resolveStatePath() (string, error) {
+	if statePath != "" {
+		return filepath.Abs(statePath)
+	}
+	if outputDir != "" {
+		return filepath.Abs(filepath.Join(outputDir, stateFileName))
+	}
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, dataStateFileName), nil
+}
+
+// defaultDataDir returns apkd under $XDG_DATA_HOME or ~/.local/share, or under
+// the user config directory on Windows and macOS.
+func defaultDataDir() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, "apkd"), nil
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configDir, "apkd"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "share", "apkd"), nil
+}
+
+// openStateStore loads the state file at path. A missing file is an empty
+// store; it is created on the first record.
+func openStateStore(path string) (*stateStore, error) {
+	store := &stateStore{path: path}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening state file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record stateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid state record: %w", path, lineNumber, err)
+		}
+		store.records = append(store.records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// Record appends a record to the state file. The path of the file is stored
+// absolute, as the state file may be shared by runs from other directories.
+func (s *stateStore) Record(record stateRecord) error {
+	if s == nil {
+		return nil
+	}
+	if absPath, err := filepath.Abs(record.Path); err == nil {
+		record.Path = absPath
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding state record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening state file %s: %w", s.path, err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing state file %s: %w", s.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing state file %s: %w", s.path, err)
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+// Records returns the records of the package, or every record when
+// packageName is empty, oldest first.
+func (s *stateStore) Records(packageName string) []stateRecord {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []stateRecord
+	for _, record := range s.records {
+		if packageName == "" || record.Package == packageName {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// Known returns the newest recorded download of the package that satisfies
+// the selector and whose file still exists. Selectors that are not answered
+// by a record, such as latest and all, are never known; their versions are
+// checked one by one with KnownCode once the sources are queried.
+func (s *stateStore) Known(packageName string, selector versionSelector) (stateRecord, bool) {
+	if !selector.answeredByRecord() {
+		return stateRecord{}, false
+	}
+	return s.newestExisting(packageName, selector)
+}
+
+// KnownCode returns the recorded download of the version code of the package
+// whose file still exists.
+func (s *stateStore) KnownCode(packageName string, code int) (stateRecord, bool) {
+	return s.newestExisting(packageName, versionSelector{kind: selectExact, code: code})
+}
+
+func (s *stateStore) newestExisting(packageName string, selector versionSelector) (stateRecord, bool) {
+	records := s.Records(packageName)
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if !selector.matches(sources.Version{Code: record.VersionCode, Name: record.VersionName}) {
+			continue
+		}
+		if _, err := os.Stat(record.Path); err != nil {
+			continue
+		}
+		return record, true
+	}
+	return stateRecord{}, false
+}
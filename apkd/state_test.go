@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func TestStateStorePersistsRecords(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, stateFileName)
+	apkPath := filepath.Join(dir, "com.example-2.0-v2.apk")
+	if err := os.WriteFile(apkPath, []byte("apk"), 0o600); err != nil {
+		t.Fatalf("failed to write apk: %v", err)
+	}
+
+	store, err := openStateStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening missing state file: %v", err)
+	}
+	records := []stateRecord{
+		{Package: "com.example", VersionCode: 1, VersionName: "1.0", Source: "fdroid", Path: filepath.Join(dir, "removed.apk")},
+		{Package: "com.example", VersionCode: 2, VersionName: "2.0", Source: "rustore", SHA256: "abc", Size: 3, DownloadedAt: time.Unix(1700000000, 0).UTC(), Signers: []string{"def"}, Path: apkPath},
+		{Package: "com.other", VersionCode: 5, VersionName: "5.0", Source: "fdroid", Path: apkPath},
+	}
+	for _, record := range records {
+		if err := store.Record(record); err != nil {
+			t.Fatalf("unexpected error recording: %v", err)
+		}
+	}
+
+	reopened, err := openStateStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error reopening state file: %v", err)
+	}
+	got := reopened.Records("com.example")
+	if len(got) != 2 || got[1].SHA256 != "abc" || !got[1].DownloadedAt.Equal(records[1].DownloadedAt) || got[1].Signers[0] != "def" {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+	if len(reopened.Records("")) != 3 {
+		t.Fatalf("expected every record without a package filter")
+	}
+
+	if known, ok := reopened.Known("com.example", versionSelector{kind: selectRange, min: 1}); !ok || known.VersionCode != 2 {
+		t.Fatalf("expected version 2 to be known, got %+v %v", known, ok)
+	}
+	if _, ok := reopened.Known("com.example", versionSelector{kind: selectExact, code: 1}); ok {
+		t.Fatalf("expected a record whose file was removed to be unknown")
+	}
+	if _, ok := reopened.KnownCode("com.example", 1); ok {
+		t.Fatalf("expected a version code whose file was removed to be unknown")
+	}
+	if known, ok := reopened.KnownCode("com.example", 2); !ok || known.Source != "rustore" {
+		t.Fatalf("expected version code 2 to be known, got %+v %v", known, ok)
+	}
+	if _, ok := reopened.Known("com.missing", versionSelector{kind: selectRange, min: 1}); ok {
+		t.Fatalf("expected an unrecorded package to be unknown")
+	}
+}
+
+func TestSkipKnownChecksMultiVersionSelectorsPerVersion(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openStateStore(filepath.Join(dir, stateFileName))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, code := range []int{1, 2} {
+		apkPath := filepath.Join(dir, fmt.Sprintf("com.example-v%d.apk", code))
+		if err := os.WriteFile(apkPath, []byte("apk"), 0o600); err != nil {
+			t.Fatalf("failed to write apk: %v", err)
+		}
+		if err := store.Record(stateRecord{Package: "com.example", VersionCode: code, Source: "fdroid", Path: apkPath}); err != nil {
+			t.Fatalf("unexpected error recording: %v", err)
+		}
+	}
+	previous := runState
+	runState = store
+	t.Cleanup(func() { runState = previous })
+
+	for _, raw := range []string{"all", "latest", "latest-1"} {
+		selector, err := parseVersionSelector(raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if known, ok := store.Known("com.example", selector); ok {
+			t.Fatalf("expected @%s not to skip the package before querying sources, got %+v", raw, known)
+		}
+	}
+
+	matches := []sourcedVersion{
+		{Version: sources.Version{PackageName: "com.example", Code: 3}},
+		{Version: sources.Version{PackageName: "com.example", Code: 2}},
+		{Version: sources.Version{PackageName: "com.example", Code: 1}},
+	}
+	remaining, known := dropKnownVersions("com.example", matches)
+	if len(remaining) != 1 || remaining[0].Version.Code != 3 {
+		t.Fatalf("expected only the unrecorded version to remain, got %+v", remaining)
+	}
+	if len(known) != 2 || known[0].VersionCode != 2 || known[1].VersionCode != 1 {
+		t.Fatalf("unexpected known records: %+v", known)
+	}
+}
+
+func TestNilStateStoreIsNoop(t *testing.T) {
+	var store *stateStore
+	if err := store.Record(stateRecord{Package: "com.example"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.Known("com.example", versionSelector{}); ok {
+		t.Fatalf("expected nil store to know nothing")
+	}
+}
+
+func TestWriteHistoryTable(t *testing.T) {
+	var out bytes.Buffer
+	records := []stateRecord{{Package: "com.example", VersionCode: 2, VersionName: "2.0", Source: "fdroid", Size: 3, Path: "/tmp/app.apk"}}
+	if err := writeHistoryTable(&out, records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "com.example") || !strings.Contains(lines[1], "/tmp/app.apk") {
+		t.Fatalf("unexpected history table:\n%s", out.String())
+	}
+}
+
+func TestResolveStatePath(t *testing.T) {
+	prevOutputDir, prevStatePath := outputDir, statePath
+	defer func() {
+		outputDir, statePath = prevOutputDir, prevStatePath
+	}()
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+
+	outputDir, statePath = "", ""
+	path, err := resolveStatePath()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != filepath.Join(dataHome, "apkd", dataStateFileName) {
+		t.Fatalf("expected the state file in the data directory, got %s", path)
+	}
+	// The data directory is created with the first record.
+	store, err := openStateStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Record(stateRecord{Package: "com.example", VersionCode: 1, Path: "com.example-1.0-v1.apk"}); err != nil {
+		t.Fatalf("unexpected record error: %v", err)
+	}
+	if records := store.Records("com.example"); len(records) != 1 || !filepath.IsAbs(records[0].Path) {
+		t.Fatalf("expected the file path to be recorded absolute, got %+v", records)
+	}
+
+	outputDir = t.TempDir()
+	if path, err = resolveStatePath(); err != nil || path != filepath.Join(outputDir, stateFileName) {
+		t.Fatalf("expected the state file in the output directory, got %s (%v)", path, err)
+	}
+	statePath = filepath.Join(t.TempDir(), "state.jsonl")
+	if path, err = resolveStatePath(); err != nil || path != statePath {
+		t.Fatalf("expected --state to win, got %s (%v)", path, err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+const (
+	strategyHighestVersion       = "highest-version"
+	strategyPriorityOrder        = "priority-order"
+	strategyPreferApk            = "prefer-apk-over-xapk"
+	strategySmallestSameCode     = "smallest-size-for-same-code"
+	defaultSelectionStrategyName = strategyHighestVersion
+)
+
+var selectionStrategyNames = []string{
+	strategyHighestVersion,
+	strategyPriorityOrder,
+	strategyPreferApk,
+	strategySmallestSameCode,
+}
+
+// selectionStrategy decides which source a version is taken from when several
+// sources offer the package. Priority lists source names from most to least
+// preferred; it orders priority-order and breaks ties in the other
+// strategies. Sources missing from it rank last, by name.
+type selectionStrategy struct {
+	Name     string
+	Priority []string
+}
+
+// sourceSelection is the strategy used by findVersion. It is set from the
+// selection config section.
+var sourceSelection = selectionStrategy{Name: defaultSelectionStrategyName}
+
+func validateSelectionStrategyName(name string) error {
+	if !slices.Contains(selectionStrategyNames, name) {
+		return fmt.Errorf("unknown strategy %q, expected one of %s", name, strings.Join(selectionStrategyNames, ", "))
+	}
+	return nil
+}
+
+func (s selectionStrategy) rank(sourceName string) int {
+	if i := slices.Index(s.Priority, sourceName); i >= 0 {
+		return i
+	}
+	return len(s.Priority)
+}
+
+// compare orders two candidates so that the preferred one comes first.
+func (s selectionStrategy) compare(a, b sourcedVersion) int {
+	byPriority := func() int {
+		if c := cmp.Compare(s.rank(a.Source.Name()), s.rank(b.Source.Name())); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Source.Name(), b.Source.Name())
+	}
+	if s.Name == strategyPriorityOrder {
+		if c := byPriority(); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Version.Code, a.Version.Code)
+	}
+	if c := cmp.Compare(b.Version.Code, a.Version.Code); c != 0 {
+		return c
+	}
+	switch s.Name {
+	case strategyPreferApk:
+		if c := preferTrue(a.Version.Type == sources.APK, b.Version.Type == sources.APK); c != 0 {
+			return c
+		}
+	case strategySmallestSameCode:
+		// Unknown sizes are reported as 0 and rank after every known size.
+		if c := preferTrue(a.Version.Size > 0, b.Version.Size > 0); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Version.Size, b.Version.Size); c != 0 {
+			return c
+		}
+	}
+	return byPriority()
+}
+
+// preferTrue orders a condition that holds before one that does not.
+func preferTrue(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// order returns the candidates sorted from most to least preferred.
+func (s selectionStrategy) order(candidates []sourcedVersion) []sourcedVersion {
+	ordered := slices.Clone(candidates)
+	slices.SortStableFunc(ordered, s.compare)
+	return ordered
+}
+
+// describe explains why the strategy picked a version for a selector that
+// asks for a single newest version.
+func (s selectionStrategy) describe() string {
+	switch s.Name {
+	case strategyPriorityOrder:
+		return "first source in priority order"
+	case strategyPreferApk:
+		return "highest version code, APK preferred"
+	case strategySmallestSameCode:
+		return "highest version code, smallest file"
+	default:
+		return "highest version code"
+	}
+}
+
+const (
+	fallbackSameVersionOnly = "same-version-only"
+	fallbackAnyVersion      = "any-version"
+	defaultFallbackPolicy   = fallbackSameVersionOnly
+)
+
+var fallbackPolicyNames = []string{fallbackSameVersionOnly, fallbackAnyVersion}
+
+// downloadFallback controls which candidates processPackageTask tries when the
+// download from the selected source fails. It is set from selection.fallback.
+var downloadFallback = defaultFallbackPolicy
+
+func validateFallbackPolicyName(name string) error {
+	if !slices.Contains(fallbackPolicyNames, name) {
+		return fmt.Errorf("unknown fallback %q, expected one of %s", name, strings.Join(fallbackPolicyNames, ", "))
+	}
+	return nil
+}
+
+// fallbackCandidates returns the candidates to try, in order, when the
+// download of match fails. Same-version-only allows other sources with the
+// same version code; any-version also allows older versions that satisfy the
+// selector. Versions picked for their own download (selected) and the source
+// of match are skipped. Candidates with the same version code come first,
+// the rest follow the strategy order.
+func (s selectionStrategy) fallbackCandidates(match sourcedVersion, candidates, selected []sourcedVersion, policy string) []sourcedVersion {
+	var fallbacks []sourcedVersion
+	for _, candidate := range candidates {
+		if candidate.Source.Name() == match.Source.Name() {
+			continue
+		}
+		if candidate.Version.Code != match.Version.Code {
+			if policy != fallbackAnyVersion || candidate.Version.Code > match.Version.Code {
+				continue
+			}
+			if slices.ContainsFunc(selected, func(other sourcedVersion) bool {
+				return other.Version.Code == candidate.Version.Code
+			}) {
+				continue
+			}
+		}
+		fallbacks = append(fallbacks, candidate)
+	}
+	slices.SortStableFunc(fallbacks, func(a, b sourcedVersion) int {
+		if c := preferTrue(a.Version.Code == match.Version.Code, b.Version.Code == match.Version.Code); c != 0 {
+			return c
+		}
+		return s.compare(a, b)
+	})
+	return fallbacks
+}
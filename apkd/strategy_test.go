@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func TestSelectionStrategies(t *testing.T) {
+	fdroid := &findStubSource{name: "fdroid"}
+	rustore := &findStubSource{name: "rustore"}
+	apkcombo := &findStubSource{name: "apkcombo"}
+	candidates := []sourcedVersion{
+		{Version: sources.Version{Code: 10, Size: 300, Type: sources.XAPK}, Source: apkcombo},
+		{Version: sources.Version{Code: 10, Size: 200, Type: sources.APK}, Source: rustore},
+		{Version: sources.Version{Code: 10, Size: 100, Type: sources.XAPK}, Source: fdroid},
+		{Version: sources.Version{Code: 9, Size: 50, Type: sources.APK}, Source: fdroid},
+	}
+	tests := []struct {
+		strategy selectionStrategy
+		source   string
+		code     int
+	}{
+		{strategy: selectionStrategy{Name: strategyHighestVersion}, source: "apkcombo", code: 10},
+		{strategy: selectionStrategy{Name: strategyHighestVersion, Priority: []string{"rustore"}}, source: "rustore", code: 10},
+		{strategy: selectionStrategy{Name: strategyPriorityOrder, Priority: []string{"fdroid", "rustore"}}, source: "fdroid", code: 10},
+		{strategy: selectionStrategy{Name: strategyPreferApk}, source: "rustore", code: 10},
+		{strategy: selectionStrategy{Name: strategySmallestSameCode}, source: "fdroid", code: 10},
+	}
+	for _, tt := range tests {
+		selected := versionSelector{}.selectVersions(candidates, tt.strategy)
+		if len(selected) != 1 || selected[0].Source.Name() != tt.source || selected[0].Version.Code != tt.code {
+			t.Fatalf("%+v: unexpected selection %+v", tt.strategy, selected)
+		}
+	}
+}
+
+func TestPriorityOrderPrefersSourceOverNewerVersion(t *testing.T) {
+	fdroid := &findStubSource{name: "fdroid"}
+	apkcombo := &findStubSource{name: "apkcombo"}
+	candidates := []sourcedVersion{
+		{Version: sources.Version{Code: 11}, Source: apkcombo},
+		{Version: sources.Version{Code: 10}, Source: fdroid},
+	}
+	strategy := selectionStrategy{Name: strategyPriorityOrder, Priority: []string{"fdroid"}}
+
+	selected := versionSelector{}.selectVersions(candidates, strategy)
+	if len(selected) != 1 || selected[0].Source != fdroid {
+		t.Fatalf("expected the fdroid version, got %+v", selected)
+	}
+	all := versionSelector{kind: selectAll}.selectVersions(candidates, strategy)
+	if len(all) != 2 || all[0].Version.Code != 11 || all[1].Version.Code != 10 {
+		t.Fatalf("expected every version newest first, got %+v", all)
+	}
+}
+
+func TestValidateSelectionStrategyName(t *testing.T) {
+	if err := validateSelectionStrategyName(strategyPriorityOrder); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateSelectionStrategyName("newest"); err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+}
+
+func TestFallbackCandidates(t *testing.T) {
+	fdroid := &findStubSource{name: "fdroid"}
+	rustore := &findStubSource{name: "rustore"}
+	apkcombo := &findStubSource{name: "apkcombo"}
+	match := sourcedVersion{Version: sources.Version{Code: 10}, Source: apkcombo}
+	candidates := []sourcedVersion{
+		match,
+		{Version: sources.Version{Code: 9}, Source: fdroid},
+		{Version: sources.Version{Code: 10}, Source: rustore},
+		{Version: sources.Version{Code: 8}, Source: rustore},
+	}
+	strategy := selectionStrategy{Name: strategyHighestVersion}
+
+	sameVersion := strategy.fallbackCandidates(match, candidates, []sourcedVersion{match}, fallbackSameVersionOnly)
+	if len(sameVersion) != 1 || sameVersion[0].Source != rustore || sameVersion[0].Version.Code != 10 {
+		t.Fatalf("unexpected same-version fallbacks: %+v", sameVersion)
+	}
+	anyVersion := strategy.fallbackCandidates(match, candidates, []sourcedVersion{match}, fallbackAnyVersion)
+	if len(anyVersion) != 3 || anyVersion[0].Version.Code != 10 || anyVersion[1].Version.Code != 9 || anyVersion[2].Version.Code != 8 {
+		t.Fatalf("unexpected any-version fallbacks: %+v", anyVersion)
+	}
+	selected := []sourcedVersion{match, candidates[1]}
+	withoutSelected := strategy.fallbackCandidates(match, candidates, selected, fallbackAnyVersion)
+	if len(withoutSelected) != 2 || withoutSelected[1].Version.Code != 8 {
+		t.Fatalf("expected versions selected on their own to be skipped, got %+v", withoutSelected)
+	}
+}
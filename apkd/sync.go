@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/spf13/cobra"
+)
+
+var syncKeepVersions int
+
+var syncCmd = cobra.Command{
+	Use:           "sync",
+	Short:         "Keep an output directory in sync with a package list",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		resetRunState()
+		sourceProxies, resolvedCfg, err := prepareRun(cmd)
+		if err != nil {
+			return err
+		}
+		if syncKeepVersions < 0 {
+			return errors.New("error validating --keep: must be >= 0")
+		}
+		if outputFileName != "" {
+			return errors.New("--output-file is not supported by sync")
+		}
+		if outputDir == "" {
+			return errors.New("sync needs an output directory. Use --output-dir or defaults.output_dir")
+		}
+		if err := parsePackageNames(); err != nil {
+			return err
+		}
+		if err := selectActiveSources(sourceProxies, resolvedCfg); err != nil {
+			return err
+		}
+		return prepareOutputDir()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := notifyInterrupt(cmd.Context())
+		defer stop()
+
+		before, err := scanMirror(outputDir, packageNamesMap)
+		if err != nil {
+			return err
+		}
+		tasks := make([]PackageTask, 0, len(packageNamesMap))
+		for packageName, selector := range packageNamesMap {
+			tasks = append(tasks, PackageTask{
+				PackageName: packageName,
+				Selector:    selector,
+				LocalCodes:  before.codes(packageName),
+			})
+		}
+		if err := runPackageTasks(ctx, tasks); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return finishRun(ctx)
+		}
+
+		after, err := scanMirror(outputDir, packageNamesMap)
+		if err != nil {
+			return err
+		}
+		var removed []mirrorFile
+		if syncKeepVersions > 0 {
+			removed = pruneMirror(after, syncKeepVersions)
+		}
+		printSyncChangelog(os.Stdout, before, after, removed)
+		return finishRun(ctx)
+	},
+}
+
+// mirrorFile is a file in the output directory named like the files apkd
+// downloads: <pkg>-<name>-v<code>.<type>.
+type mirrorFile struct {
+	Path        string
+	PackageName string
+	VersionName string
+	Code        int
+}
+
+// mirrorState maps a package name to its files, newest version first.
+type mirrorState map[string][]mirrorFile
+
+func (m mirrorState) codes(packageName string) []int {
+	files := m[packageName]
+	codes := make([]int, 0, len(files))
+	for _, file := range files {
+		codes = append(codes, file.Code)
+	}
+	return codes
+}
+
+var mirrorFileRe = regexp.MustCompile(`^(.*)-v(\d+)\.(` + mirrorFileTypes() + `)$`)
+
+func mirrorFileTypes() string {
+	var types []string
+	for _, fileType := range sources.FileTypes() {
+		types = append(types, regexp.QuoteMeta(string(fileType)))
+	}
+	return strings.Join(types, "|")
+}
+
+// scanMirror lists the files of the given packages in dir. Files of other
+// packages, partial downloads and files apkd did not name are ignored.
+func scanMirror(dir string, packages map[string]versionSelector) (mirrorState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading output directory %s: %w", dir, err)
+	}
+	state := make(mirrorState)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		match := mirrorFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		code, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		for packageName := range packages {
+			versionName, isPackage := cutPackagePrefix(match[1], packageName)
+			if !isPackage {
+				continue
+			}
+			state[packageName] = append(state[packageName], mirrorFile{
+				Path:        filepath.Join(dir, entry.Name()),
+				PackageName: packageName,
+				VersionName: versionName,
+				Code:        code,
+			})
+			break
+		}
+	}
+	for _, files := range state {
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].Code != files[j].Code {
+				return files[i].Code > files[j].Code
+			}
+			return files[i].Path < files[j].Path
+		})
+	}
+	return state, nil
+}
+
+// cutPackagePrefix strips "<packageName>-" from a file name stem. Package
+// names cannot contain '-', so the prefix is unambiguous.
+func cutPackagePrefix(stem, packageName string) (string, bool) {
+	prefix := sanitizeFileName(packageName) + "-"
+	if len(stem) <= len(prefix) || stem[:len(prefix)] != prefix {
+		return "", false
+	}
+	return stem[len(prefix):], true
+}
+
+// pruneMirror deletes all but the keep newest versions of every package and
+// returns the files it removed. Files sharing a version code count as one
+// version.
+func pruneMirror(state mirrorState, keep int) []mirrorFile {
+	var removed []mirrorFile
+	for _, packageName := range sortedPackageNames(state) {
+		var keptCodes []int
+		for _, file := range state[packageName] {
+			if len(keptCodes) < keep && !slices.Contains(keptCodes, file.Code) {
+				keptCodes = append(keptCodes, file.Code)
+			}
+		}
+		for _, file := range state[packageName] {
+			if slices.Contains(keptCodes, file.Code) {
+				continue
+			}
+			if err := os.Remove(file.Path); err != nil {
+				reportError(fmt.Sprintf("Error removing %s: %v", file.Path, err))
+				continue
+			}
+			logger.Logd("Removed " + file.Path)
+			removed = append(removed, file)
+		}
+	}
+	return removed
+}
+
+// printSyncChangelog prints the files sync added and removed.
+func printSyncChangelog(w io.Writer, before, after mirrorState, removed []mirrorFile) {
+	existing := make(map[string]struct{})
+	for _, files := range before {
+		for _, file := range files {
+			existing[file.Path] = struct{}{}
+		}
+	}
+	var added []mirrorFile
+	for _, packageName := range sortedPackageNames(after) {
+		for _, file := range after[packageName] {
+			if _, exists := existing[file.Path]; !exists {
+				added = append(added, file)
+			}
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintln(w, "Mirror is up to date")
+		return
+	}
+	fmt.Fprintln(w, "Changes:")
+	for _, file := range added {
+		fmt.Fprintf(w, "+ %s v%s (%d) %s\n", file.PackageName, file.VersionName, file.Code, filepath.Base(file.Path))
+	}
+	for _, file := range removed {
+		fmt.Fprintf(w, "- %s v%s (%d) %s\n", file.PackageName, file.VersionName, file.Code, filepath.Base(file.Path))
+	}
+}
+
+func sortedPackageNames(state mirrorState) []string {
+	names := make([]string, 0, len(state))
+	for packageName := range state {
+		names = append(names, packageName)
+	}
+	sort.Strings(names)
+	return names
+}
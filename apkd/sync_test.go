@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+func writeMirrorFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("apk"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestScanMirrorGroupsFilesByPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeMirrorFiles(t, dir,
+		"com.example-1.0-v1.apk",
+		"com.example-2.0-beta-v2.xapk",
+		"com.example-2.1-v4.apks",
+		"com.example.other-1.0-v5.apk",
+		"com.example.other-1.1-v6.apkm",
+		"com.example-3.0-v3.apk.part",
+		"notes.txt",
+	)
+	packages := map[string]versionSelector{"com.example": {}, "com.example.other": {}}
+
+	state, err := scanMirror(dir, packages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := state["com.example"]
+	if len(files) != 3 || files[0].Code != 4 || files[0].VersionName != "2.1" || files[1].Code != 2 || files[1].VersionName != "2.0-beta" || files[2].Code != 1 {
+		t.Fatalf("unexpected com.example files: %+v", files)
+	}
+	if codes := state.codes("com.example.other"); len(codes) != 2 || codes[0] != 6 || codes[1] != 5 {
+		t.Fatalf("unexpected com.example.other codes: %v", codes)
+	}
+}
+
+func TestPruneMirrorKeepsNewestVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeMirrorFiles(t, dir,
+		"com.example-1.0-v1.apk",
+		"com.example-2.0-v2.apk",
+		"com.example-3.0-v3.apk",
+		"com.example-3.0-v3.xapk",
+	)
+	packages := map[string]versionSelector{"com.example": {}}
+	state, err := scanMirror(dir, packages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	removed := pruneMirror(state, 2)
+	if len(removed) != 1 || removed[0].Code != 1 {
+		t.Fatalf("expected only version 1 to be removed, got %+v", removed)
+	}
+	after, err := scanMirror(dir, packages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if codes := after.codes("com.example"); len(codes) != 3 || codes[2] != 2 {
+		t.Fatalf("unexpected remaining codes: %v", codes)
+	}
+
+	var changelog bytes.Buffer
+	printSyncChangelog(&changelog, state, after, removed)
+	if !strings.Contains(changelog.String(), "- com.example v1.0 (1) com.example-1.0-v1.apk") {
+		t.Fatalf("unexpected changelog:\n%s", changelog.String())
+	}
+}
+
+func TestNewVersionsSkipsLocalAndOlderVersions(t *testing.T) {
+	src := &findStubSource{name: "fdroid"}
+	matches := []sourcedVersion{
+		{Version: sources.Version{Code: 3}, Source: src},
+		{Version: sources.Version{Code: 2}, Source: src},
+	}
+	if got := newVersions(versionSelector{}, matches[1:], []int{3}); len(got) != 0 {
+		t.Fatalf("expected an older latest version to be skipped, got %+v", got)
+	}
+	if got := newVersions(versionSelector{}, matches[:1], []int{2}); len(got) != 1 {
+		t.Fatalf("expected a newer version to be downloaded, got %+v", got)
+	}
+	if got := newVersions(versionSelector{kind: selectAll}, matches, []int{3}); len(got) != 1 || got[0].Version.Code != 2 {
+		t.Fatalf("expected only the missing version, got %+v", got)
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/kiber-io/apkd/apkd/logging"
 	"github.com/kiber-io/apkd/apkd/sources"
@@ -24,8 +26,11 @@ type Task any
 type PackageTask struct {
 	Task
 	PackageName string
-	VersionCode int
-	Bar         *mpb.Bar
+	Selector    versionSelector
+	// LocalCodes lists the version codes already present in the output
+	// directory. Set by sync, which downloads only versions not in it.
+	LocalCodes []int
+	Bar        *mpb.Bar
 }
 
 type VersionTask struct {
@@ -36,6 +41,7 @@ type VersionTask struct {
 }
 
 type TaskQueue struct {
+	ctx                 context.Context
 	queue               chan Task
 	wg                  sync.WaitGroup
 	maxWorkers          int
@@ -45,19 +51,29 @@ type TaskQueue struct {
 	runningTasks        atomic.Int64
 	completedTasks      atomic.Int64
 	activeDownloadTasks atomic.Int64
+	waitingForSlot      atomic.Int64
+	workerSlots         chan struct{}
 	stateMu             sync.RWMutex
 	processedPackages   map[string]struct{}
 	processedDevelopers map[string]map[string]struct{}
+	downloadSlotsMu     sync.Mutex
+	downloadSlots       map[string]chan struct{}
 }
 
-func NewTaskQueue(maxWorkers int) *TaskQueue {
+// NewTaskQueue runs up to maxWorkers searches and downloads at a time. When
+// ctx is cancelled, running tasks abort their requests and queued tasks are
+// dropped.
+func NewTaskQueue(ctx context.Context, maxWorkers int) *TaskQueue {
 	wg := sync.WaitGroup{}
 	tq := &TaskQueue{
+		ctx:                 ctx,
 		queue:               make(chan Task, 100),
 		maxWorkers:          maxWorkers,
+		workerSlots:         make(chan struct{}, maxWorkers),
 		progress:            mpb.New(mpb.WithAutoRefresh(), mpb.WithWaitGroup(&wg)),
 		processedPackages:   make(map[string]struct{}),
 		processedDevelopers: make(map[string]map[string]struct{}),
+		downloadSlots:       make(map[string]chan struct{}),
 	}
 	tq.statusBar = tq.progress.New(0, mpb.NopStyle(),
 		mpb.BarFillerTrim(),
@@ -68,9 +84,7 @@ func NewTaskQueue(maxWorkers int) *TaskQueue {
 	tq.statusBar.SetPriority(1_000_000 + tq.statusBar.ID())
 	log.SetOutput(tq.progress)
 
-	for range tq.maxWorkers {
-		go tq.worker()
-	}
+	go tq.dispatch()
 
 	return tq
 }
@@ -134,23 +148,120 @@ func (tq *TaskQueue) reserveDeveloperSource(developerID, sourceName string) bool
 	return true
 }
 
-func (tq *TaskQueue) worker() {
+// sourceSlots returns the download semaphore for the source, sized by
+// sources.MaxParallelDownloads on first use.
+func (tq *TaskQueue) sourceSlots(source sources.Source) chan struct{} {
+	tq.downloadSlotsMu.Lock()
+	defer tq.downloadSlotsMu.Unlock()
+	if tq.downloadSlots == nil {
+		tq.downloadSlots = make(map[string]chan struct{})
+	}
+	slots, exists := tq.downloadSlots[source.Name()]
+	if !exists {
+		limit := sources.MaxParallelDownloads(source)
+		logger.Logd(fmt.Sprintf("Limiting parallel downloads from source %s to %d", source.Name(), limit))
+		slots = make(chan struct{}, limit)
+		tq.downloadSlots[source.Name()] = slots
+	}
+	return slots
+}
+
+// acquireDownloadSlot blocks until the source has a free download slot or ctx
+// is cancelled, and returns a function that releases the slot.
+func (tq *TaskQueue) acquireDownloadSlot(ctx context.Context, source sources.Source) (func(), error) {
+	slots := tq.sourceSlots(source)
+	select {
+	case slots <- struct{}{}:
+	default:
+		tq.waitingForSlot.Add(1)
+		defer tq.waitingForSlot.Add(-1)
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		<-slots
+	}, nil
+}
+
+// acquireWorker blocks until one of the --workers slots is free or ctx is
+// cancelled, and returns a function that frees the slot. Downloads take a
+// worker slot only once their source has a free download slot, so a download
+// waiting for its source never keeps other sources from running.
+func (tq *TaskQueue) acquireWorker(ctx context.Context) (func(), error) {
+	if tq.workerSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case tq.workerSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-tq.workerSlots
+	}, nil
+}
+
+func (tq *TaskQueue) runContext() context.Context {
+	if tq.ctx == nil {
+		return context.Background()
+	}
+	return tq.ctx
+}
+
+// dispatch starts the queued tasks in order, each once a worker slot is free.
+// Tasks queued after ctx is cancelled still run to record their outcome.
+func (tq *TaskQueue) dispatch() {
 	for task := range tq.queue {
-		tq.runningTasks.Add(1)
-		switch t := task.(type) {
-		case PackageTask:
-			tq.markPackageProcessed(t.PackageName)
-			tq.processPackageTask(t)
-		case VersionTask:
-			tq.markPackageProcessed(t.Version.PackageName)
-			tq.processVersionTask(t)
-		default:
-			reportError(fmt.Sprintf("Unknown task type: %T", t))
+		tq.workerSlots <- struct{}{}
+		go tq.runTask(task)
+	}
+}
+
+// runTask runs a task holding the worker slot dispatch acquired for it.
+func (tq *TaskQueue) runTask(task Task) {
+	ctx := tq.runContext()
+	releaseWorker := func() {
+		<-tq.workerSlots
+	}
+	tq.runningTasks.Add(1)
+	switch t := task.(type) {
+	case PackageTask:
+		tq.markPackageProcessed(t.PackageName)
+		if ctx.Err() != nil {
+			releaseWorker()
+			tq.removeBar(t.Bar)
+			recordPackageOutcome(reportRecord{
+				Package:              t.PackageName,
+				RequestedVersion:     t.Selector.String(),
+				RequestedVersionCode: t.Selector.exactCode(),
+				Status:               reportStatusInterrupted,
+			})
+			break
+		}
+		tq.processPackageTask(ctx, t)
+		releaseWorker()
+	case VersionTask:
+		// processVersionTask takes a worker slot of its own once the source
+		// has a free download slot.
+		releaseWorker()
+		tq.markPackageProcessed(t.Version.PackageName)
+		if ctx.Err() != nil {
+			tq.removeBar(t.Bar)
+			break
+		}
+		if outcome := tq.processVersionTask(ctx, t); outcome.Err != "" {
+			reportError(outcome.Err)
 		}
-		tq.runningTasks.Add(-1)
-		tq.completedTasks.Add(1)
-		tq.wg.Done()
+	default:
+		releaseWorker()
+		reportError(fmt.Sprintf("Unknown task type: %T", t))
 	}
+	tq.runningTasks.Add(-1)
+	tq.completedTasks.Add(1)
+	tq.wg.Done()
 }
 
 func (tq *TaskQueue) progressStatusLine() string {
@@ -159,9 +270,10 @@ func (tq *TaskQueue) progressStatusLine() string {
 		queued = 0
 	}
 	return fmt.Sprintf(
-		"Progress: downloaded %d | in progress %d | queued %d | errors %d",
+		"Progress: downloaded %d | in progress %d | waiting for source %d | queued %d | errors %d",
 		downloadSuccessCount.Load(),
 		tq.activeDownloadTasks.Load(),
+		tq.waitingForSlot.Load(),
 		queued,
 		downloadErrorCount.Load(),
 	)
@@ -174,8 +286,8 @@ func getDecoratorsForTask(task Task, status string) []decor.Decorator {
 	switch t := task.(type) {
 	case PackageTask:
 		decorators = append(decorators, decor.Name(t.PackageName, wc), decor.Name("-", wc))
-		if t.VersionCode != 0 {
-			decorators = append(decorators, decor.Name(fmt.Sprintf("(%d)", t.VersionCode), wc))
+		if t.Selector.kind != selectLatest {
+			decorators = append(decorators, decor.Name(fmt.Sprintf("(%s)", t.Selector), wc))
 		} else {
 			decorators = append(decorators, decor.Name("-", wc))
 		}
@@ -194,7 +306,7 @@ func getDecoratorsForTask(task Task, status string) []decor.Decorator {
 	return decorators
 }
 
-func (tq *TaskQueue) processPackageTask(task PackageTask) {
+func (tq *TaskQueue) processPackageTask(ctx context.Context, task PackageTask) {
 	bar := tq.progress.AddBar(1,
 		mpb.BarQueueAfter(task.Bar),
 		mpb.BarRemoveOnComplete(),
@@ -208,32 +320,113 @@ func (tq *TaskQueue) processPackageTask(task PackageTask) {
 		p := 3000 - bar.ID()
 		bar.SetPriority(p)
 	}
-	version, source, errs := tq.findVersion(task.PackageName, task.VersionCode)
-	if version == (sources.Version{}) || source == nil {
-		if len(errs) == 0 {
+	started := time.Now()
+	record := reportRecord{
+		Package:              task.PackageName,
+		RequestedVersion:     task.Selector.String(),
+		RequestedVersionCode: task.Selector.exactCode(),
+	}
+	if skipKnown {
+		if known, ok := runState.Known(task.PackageName, task.Selector); ok {
+			logger.Logd(fmt.Sprintf("Package %s v%s (%d) is already recorded at %s", task.PackageName, known.VersionName, known.VersionCode, known.Path))
+			record.Status = reportStatusSkippedKnown
+			record.Source = known.Source
+			record.OutputPath = known.Path
+			record.DurationMs = durationMs(started)
+			recordPackageOutcome(record)
+			tq.removeBar(bar)
+			return
+		}
+	}
+	search := tq.findVersion(ctx, task.PackageName, task.Selector)
+	record.SourceErrors = newReportSourceErrors(search.Errors)
+	if len(search.Matches) == 0 {
+		switch {
+		case ctx.Err() != nil:
+			record.Status = reportStatusInterrupted
+		case len(search.Errors) > 0:
+			record.Status = reportStatusError
+			record.Error = "all sources failed"
+		case search.FilteredOnlyApk > 0:
+			record.Status = reportStatusFilteredOnlyApk
+			reportError(fmt.Sprintf("Package %s found only in non-APK formats (--only-apk)", task.PackageName))
+		default:
+			record.Status = reportStatusNotFound
 			reportError(fmt.Sprintf("Package %s not found in active sources", task.PackageName))
 		}
+		record.DurationMs = durationMs(started)
+		recordPackageOutcome(record)
 		tq.removeBar(bar)
 		return
 	}
-	var wg2 sync.WaitGroup
-	wg2.Add(1)
-	go func() {
-		defer wg2.Done()
-		tq.processVersionTask(VersionTask{
-			Version: version,
-			Source:  source,
-			Bar:     bar,
-		})
-	}()
-	defer wg2.Wait()
+	matches := newVersions(task.Selector, search.Matches, task.LocalCodes)
+	if len(matches) == 0 {
+		logger.Logd(fmt.Sprintf("Package %s is up to date", task.PackageName))
+		record.Status = reportStatusUpToDate
+		record.Source = search.Matches[0].Source.Name()
+		record.Version = newReportVersion(search.Matches[0].Version)
+		record.DurationMs = durationMs(started)
+		recordPackageOutcome(record)
+		tq.removeBar(bar)
+		return
+	}
+	if skipKnown {
+		var known []stateRecord
+		matches, known = dropKnownVersions(task.PackageName, matches)
+		if len(matches) == 0 {
+			newest := known[0]
+			logger.Logd(fmt.Sprintf("Package %s v%s (%d) is already recorded at %s", task.PackageName, newest.VersionName, newest.VersionCode, newest.Path))
+			record.Status = reportStatusSkippedKnown
+			record.Source = newest.Source
+			record.OutputPath = newest.Path
+			record.DurationMs = durationMs(started)
+			recordPackageOutcome(record)
+			tq.removeBar(bar)
+			return
+		}
+	}
+	var matching []sourcedVersion
+	for _, hit := range search.Hits {
+		if task.Selector.matches(hit.Version) {
+			matching = append(matching, hit)
+		}
+	}
+	for i, match := range matches {
+		// Selectors such as @all resolve to several versions; each one is
+		// downloaded and reported separately. The downloads outlive the
+		// package task: they wait for their source without holding its
+		// worker slot.
+		versionBar := bar
+		if i > 0 {
+			versionBar = nil
+		}
+		fallbacks := sourceSelection.fallbackCandidates(match, matching, search.Matches, downloadFallback)
+		tq.wg.Add(1)
+		go func(match sourcedVersion, versionBar *mpb.Bar) {
+			defer tq.wg.Done()
+			versionRecord := record
+			outcome := tq.downloadWithFallback(ctx, match, fallbacks, versionBar, &versionRecord)
+			versionRecord.Status = outcome.Status
+			versionRecord.OutputPath = outcome.OutputPath
+			versionRecord.Bytes = outcome.Bytes
+			versionRecord.SHA256 = outcome.SHA256
+			versionRecord.Error = outcome.Err
+			versionRecord.DurationMs = durationMs(started)
+			recordPackageOutcome(versionRecord)
+		}(match, versionBar)
+	}
+	version, source := search.Matches[0].Version, search.Matches[0].Source
 	if batchDeveloperDownloadMode && version.DeveloperId != "" {
 		if !tq.reserveDeveloperSource(version.DeveloperId, source.Name()) {
 			return
 		}
 		logger.Logd(fmt.Sprintf("Searching for packages by developer %s at source %s", version.DeveloperId, source.Name()))
-		packages, err := source.FindByDeveloper(version.DeveloperId)
+		packages, err := source.FindByDeveloper(ctx, version.DeveloperId)
 		if err != nil {
+			if ctx.Err() != nil {
+				tq.removeBar(bar)
+				return
+			}
 			reportError(fmt.Sprintf("Error finding packages by developer %s at source %s: %v", version.DeveloperId, source.Name(), err))
 			tq.removeBar(bar)
 			return
@@ -258,7 +451,100 @@ func (tq *TaskQueue) processPackageTask(task PackageTask) {
 	}
 }
 
-func (tq *TaskQueue) processVersionTask(task VersionTask) {
+// newVersions drops the matches that are already present locally. For the
+// latest selector a version older than the newest local one is dropped as
+// well, so a store downgrade does not add an older file to a synced mirror.
+func newVersions(selector versionSelector, matches []sourcedVersion, localCodes []int) []sourcedVersion {
+	if len(localCodes) == 0 {
+		return matches
+	}
+	newestLocal := slices.Max(localCodes)
+	var result []sourcedVersion
+	for _, match := range matches {
+		if slices.Contains(localCodes, match.Version.Code) {
+			continue
+		}
+		if selector.kind == selectLatest && match.Version.Code < newestLocal {
+			continue
+		}
+		result = append(result, match)
+	}
+	return result
+}
+
+// dropKnownVersions removes the matches whose version code the state file
+// records as downloaded, and returns the records of the removed ones in the
+// order of the matches.
+func dropKnownVersions(packageName string, matches []sourcedVersion) ([]sourcedVersion, []stateRecord) {
+	var result []sourcedVersion
+	var known []stateRecord
+	for _, match := range matches {
+		if record, ok := runState.KnownCode(packageName, match.Version.Code); ok {
+			known = append(known, record)
+			continue
+		}
+		result = append(result, match)
+	}
+	return result, known
+}
+
+// downloadWithFallback downloads match and, when that fails, tries the
+// fallback candidates in order, skipping sources that already failed. Every
+// failed attempt is logged as a warning and added to record.Fallbacks;
+// record.Source and record.Version describe the last attempt. Only the
+// failure that ends the chain is reported as an error.
+func (tq *TaskQueue) downloadWithFallback(ctx context.Context, match sourcedVersion, fallbacks []sourcedVersion, bar *mpb.Bar, record *reportRecord) versionOutcome {
+	failedSources := make(map[string]struct{})
+	attempt := match
+	for {
+		record.Source = attempt.Source.Name()
+		record.Version = newReportVersion(attempt.Version)
+		outcome := tq.processVersionTask(ctx, VersionTask{
+			Version: attempt.Version,
+			Source:  attempt.Source,
+			Bar:     bar,
+		})
+		bar = nil
+		if outcome.Status != reportStatusError || ctx.Err() != nil {
+			if outcome.Err != "" {
+				reportError(outcome.Err)
+			}
+			return outcome
+		}
+		failedSources[attempt.Source.Name()] = struct{}{}
+		next := -1
+		for i, candidate := range fallbacks {
+			if _, failed := failedSources[candidate.Source.Name()]; !failed {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			reportError(outcome.Err)
+			return outcome
+		}
+		record.Fallbacks = append(record.Fallbacks, reportFallback{
+			Source:      attempt.Source.Name(),
+			VersionCode: attempt.Version.Code,
+			Error:       outcome.Err,
+		})
+		previous := attempt
+		attempt, fallbacks = fallbacks[next], fallbacks[next+1:]
+		logger.Logw(fmt.Sprintf("Download of package %s v%d from source %s failed, falling back to source %s v%d: %s",
+			previous.Version.PackageName, previous.Version.Code, previous.Source.Name(), attempt.Source.Name(), attempt.Version.Code, outcome.Err))
+	}
+}
+
+// versionOutcome is the result of a version task, used for the run report.
+type versionOutcome struct {
+	Status     reportStatus
+	OutputPath string
+	Bytes      int64
+	SHA256     string
+	Err        string
+}
+
+func (tq *TaskQueue) processVersionTask(ctx context.Context, task VersionTask) versionOutcome {
 	bar := tq.progress.AddBar(0,
 		mpb.BarQueueAfter(task.Bar),
 		mpb.PrependDecorators(getDecoratorsForTask(task, "")...),
@@ -276,14 +562,18 @@ func (tq *TaskQueue) processVersionTask(task VersionTask) {
 		p := 3000 - bar.ID()
 		bar.SetPriority(p)
 	}
+	// Failures are returned rather than reported: a later fallback may still
+	// download the package.
+	fail := func(status reportStatus, errText string) versionOutcome {
+		tq.removeBar(bar)
+		return versionOutcome{Status: status, Err: errText}
+	}
 	var outFile string
 	if outputFileName != "" {
 		outFile = outputFileName
 	} else {
 		if task.Version.Type == "" {
-			reportError("File type not found for package " + task.Version.PackageName)
-			tq.removeBar(bar)
-			return
+			return fail(reportStatusError, "File type not found for package "+task.Version.PackageName)
 		}
 		outFile = fmt.Sprintf("%s-%s-v%d.%s", task.Version.PackageName, task.Version.Name, task.Version.Code, task.Version.Type)
 		outFile = sanitizeFileName(outFile)
@@ -293,95 +583,82 @@ func (tq *TaskQueue) processVersionTask(task VersionTask) {
 	}
 	if _, err := os.Stat(outFile); err == nil {
 		if !forceDownload {
-			reportError(fmt.Sprintf("File %s already exists. Use --force to overwrite.", outFile))
-			tq.removeBar(bar)
-			return
-		}
-		logger.Logd(fmt.Sprintf("File %s already exists. Removing...", outFile))
-		if err := os.Remove(outFile); err != nil {
-			reportError(fmt.Sprintf("Error removing existing file %s: %v", outFile, err))
-			tq.removeBar(bar)
-			return
+			outcome := fail(reportStatusSkippedExists, fmt.Sprintf("File %s already exists. Use --force to overwrite.", outFile))
+			outcome.OutputPath = outFile
+			return outcome
 		}
+		logger.Logd(fmt.Sprintf("File %s already exists and will be replaced", outFile))
 	}
-	logger.Logd(fmt.Sprintf("Downloading package %s from source %s to file %s", task.Version.PackageName, task.Source.Name(), outFile))
-	tq.activeDownloadTasks.Add(1)
-	defer tq.activeDownloadTasks.Add(-1)
-	stream, err := task.Source.Download(task.Version)
+	releaseSlot, err := tq.acquireDownloadSlot(ctx, task.Source)
 	if err != nil {
-		reportError(fmt.Sprintf("Error downloading package %s from source %s: %v", task.Version.PackageName, task.Source.Name(), err))
 		tq.removeBar(bar)
-		return
-	}
-	// Prefer Content-Length from the response (authoritative). Fall back to
-	// source-reported metadata size, which is sometimes inaccurate. Zero means
-	// unknown: the bar tracks bytes without a target percentage.
-	barSize := stream.Size
-	if barSize <= 0 {
-		barSize = int64(task.Version.Size) //nolint:gosec // G115: APK sizes never approach int64 max
-	}
-	if barSize < 0 {
-		barSize = 0
-	}
-	bar.SetTotal(barSize, false)
-	progressReader := bar.ProxyReader(stream.Body)
-	progressReaderClosed := false
-	defer func() {
-		if progressReaderClosed {
-			return
-		}
-		if closeErr := progressReader.Close(); closeErr != nil {
-			reportError(fmt.Sprintf("Error closing download stream for package %s: %v", task.Version.PackageName, closeErr))
-		}
-	}()
-	source, isRuStore := task.Source.(*sources.RuStore)
-	downloadPath := outFile
-	if isRuStore {
-		downloadPath = outFile + ".download"
-		if err := os.Remove(downloadPath); err != nil && !os.IsNotExist(err) {
-			reportError(fmt.Sprintf("Error removing existing temporary file %s: %v", downloadPath, err))
-			tq.removeBar(bar)
-			return
-		}
+		return versionOutcome{Status: reportStatusInterrupted, OutputPath: outFile}
 	}
-
-	file, err := os.Create(downloadPath)
+	defer releaseSlot()
+	releaseWorker, err := tq.acquireWorker(ctx)
 	if err != nil {
-		reportError(fmt.Sprintf("Error creating file %s: %v", downloadPath, err))
 		tq.removeBar(bar)
-		return
+		return versionOutcome{Status: reportStatusInterrupted, OutputPath: outFile}
 	}
-	if _, err = io.Copy(file, progressReader); err != nil {
-		if closeErr := file.Close(); closeErr != nil {
-			reportError(fmt.Sprintf("Error closing file %s after write error: %v", downloadPath, closeErr))
+	defer releaseWorker()
+	partPath := outFile + partFileSuffix
+	logger.Logd(fmt.Sprintf("Downloading package %s from source %s to file %s", task.Version.PackageName, task.Source.Name(), partPath))
+	tq.activeDownloadTasks.Add(1)
+	defer tq.activeDownloadTasks.Add(-1)
+	result, err := tq.downloadToPart(ctx, task, partPath, bar)
+	if err != nil {
+		if ctx.Err() != nil {
+			logger.Logi(fmt.Sprintf("Download of package %s interrupted", task.Version.PackageName))
+			tq.removeBar(bar)
+			return versionOutcome{Status: reportStatusInterrupted, OutputPath: outFile}
 		}
-		reportError(fmt.Sprintf("Error saving file %s: %v", downloadPath, err))
-		tq.removeBar(bar)
-		return
-	}
-	if err := file.Close(); err != nil {
-		reportError(fmt.Sprintf("Error closing file %s: %v", downloadPath, err))
-		tq.removeBar(bar)
-		return
-	}
-	if err := progressReader.Close(); err != nil {
-		reportError(fmt.Sprintf("Error closing download stream for package %s: %v", task.Version.PackageName, err))
-		tq.removeBar(bar)
-		return
+		var mismatchErr *sources.ChecksumMismatchError
+		if errors.As(err, &mismatchErr) {
+			return fail(reportStatusError, fmt.Sprintf("Integrity check failed for package %s from source %s: %v", task.Version.PackageName, task.Source.Name(), err))
+		}
+		return fail(reportStatusError, err.Error())
 	}
-	progressReaderClosed = true
-	if isRuStore {
+	if source, isRuStore := task.Source.(*sources.RuStore); isRuStore && task.Version.Type == sources.APK {
 		// workaround for rustore: sometimes it responds with a zip file in which the APK is stored
-		err := source.ExtractApkFromZip(downloadPath, outFile)
+		err := source.ExtractApkFromZip(partPath, partPath)
 		if err != nil {
-			reportError(fmt.Sprintf("Error extracting APK from zip file %s: %v", downloadPath, err))
-			tq.removeBar(bar)
-			return
+			return fail(reportStatusError, fmt.Sprintf("Error extracting APK from zip file %s: %v", partPath, err))
+		}
+	}
+	// The signers are checked before the rename, so a repackaged build never
+	// replaces an existing file.
+	signers, err := verifySignerPins(task, partPath)
+	if err != nil {
+		if removeErr := removePartFiles(partPath); removeErr != nil {
+			logger.Logw(fmt.Sprintf("Failed to remove %s: %v", partPath, removeErr))
 		}
+		return fail(reportStatusError, fmt.Sprintf("Signature check failed for package %s from source %s: %v", task.Version.PackageName, task.Source.Name(), err))
+	}
+	if err := os.Rename(partPath, outFile); err != nil {
+		return fail(reportStatusError, fmt.Sprintf("Error moving %s to %s: %v", partPath, outFile, err))
 	}
 	tq.removeBar(bar)
 	reportDownloadSuccess()
 	logger.Logd(fmt.Sprintf("Package %s downloaded successfully", task.Version.PackageName))
+	if err := runState.Record(stateRecord{
+		Package:      task.Version.PackageName,
+		VersionCode:  task.Version.Code,
+		VersionName:  task.Version.Name,
+		Source:       task.Source.Name(),
+		SHA256:       result.SHA256,
+		Size:         result.Size,
+		DownloadedAt: time.Now().UTC(),
+		Signers:      signers,
+		Path:         outFile,
+	}); err != nil {
+		logger.Logw(fmt.Sprintf("Failed to record download of package %s: %v", task.Version.PackageName, err))
+	}
+	return versionOutcome{
+		Status:     reportStatusDownloaded,
+		OutputPath: outFile,
+		Bytes:      result.Size,
+		SHA256:     result.SHA256,
+	}
 }
 
 func (tq *TaskQueue) removeBar(prevBar *mpb.Bar) {
@@ -391,24 +668,50 @@ func (tq *TaskQueue) removeBar(prevBar *mpb.Bar) {
 	prevBar.Abort(true)
 }
 
-func (tq *TaskQueue) findVersion(packageName string, versionCode int) (sources.Version, sources.Source, []sources.Error) {
+// versionSearch is the result of looking a package up in all active sources.
+// Matches is empty when no usable version was found.
+type versionSearch struct {
+	Matches []sourcedVersion
+	// Hits holds every usable version the sources returned, in source order.
+	Hits   []sourcedVersion
+	Errors []sources.Error
+	// FilteredOnlyApk counts sources whose versions were all skipped by
+	// --only-apk.
+	FilteredOnlyApk int
+}
+
+// findVersion asks every active source for the package and resolves the
+// selector against the versions they return. Latest and exact selectors use
+// Source.FindByPackage; the others need each source's full version list.
+func (tq *TaskQueue) findVersion(ctx context.Context, packageName string, selector versionSelector) versionSearch {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	var latestSource sources.Source
-	var latestVersion sources.Version
-	var sourcesErrors []sources.Error
-	logger.Logd(fmt.Sprintf("Searching for package %s in %d sources", packageName, len(activeSources)))
-	for _, source := range activeSources {
+	var search versionSearch
+	found := make([][]sources.Version, len(activeSources))
+	logger.Logd(fmt.Sprintf("Searching for package %s (%s) in %d sources", packageName, selector, len(activeSources)))
+	for i, source := range activeSources {
 		wg.Add(1)
-		go func(src sources.Source) {
+		go func(i int, src sources.Source) {
 			defer wg.Done()
-			version, err := src.FindByPackage(packageName, versionCode)
+			var versions []sources.Version
+			var err error
+			if selector.needsListing() {
+				versions, err = sources.ListVersions(ctx, src, packageName)
+			} else {
+				var version sources.Version
+				version, err = src.FindByPackage(ctx, packageName, selector.exactCode())
+				versions = []sources.Version{version}
+			}
 			if err != nil {
+				if ctx.Err() != nil {
+					logger.Logd(fmt.Sprintf("Search for package %s at source %s cancelled", packageName, src.Name()))
+					return
+				}
 				var appNotFoundError *sources.AppNotFoundError
 				if !errors.As(err, &appNotFoundError) {
 					reportError(fmt.Sprintf("Error finding package %s at source %s: %v", packageName, src.Name(), err))
 					mu.Lock()
-					sourcesErrors = append(sourcesErrors, sources.Error{
+					search.Errors = append(search.Errors, sources.Error{
 						SourceName:  src.Name(),
 						PackageName: packageName,
 						Err:         err,
@@ -419,21 +722,33 @@ func (tq *TaskQueue) findVersion(packageName string, versionCode int) (sources.V
 				}
 				return
 			}
-			if onlyApk && version.Type != sources.APK {
-				logger.Logd(fmt.Sprintf("Skipping package %s v%s at source %s: type %s (--only-apk)", packageName, version.Name, src.Name(), version.Type))
-				return
+			var usable []sources.Version
+			for _, version := range versions {
+				if onlyApk && version.Type != sources.APK {
+					logger.Logd(fmt.Sprintf("Skipping package %s v%s at source %s: type %s (--only-apk)", packageName, version.Name, src.Name(), version.Type))
+					continue
+				}
+				logger.Logd(fmt.Sprintf("Found package %s v%s (%v) at source %s", packageName, version.Name, version.Code, src.Name()))
+				usable = append(usable, version)
 			}
 			mu.Lock()
-			logger.Logd(fmt.Sprintf("Found package %s v%s (%v) at source %s", packageName, version.Name, version.Code, src.Name()))
-			if version.Code > latestVersion.Code {
-				latestVersion = version
-				latestSource = src
+			if len(usable) == 0 && len(versions) > 0 {
+				search.FilteredOnlyApk++
 			}
+			found[i] = usable
 			mu.Unlock()
-		}(source)
+		}(i, source)
 	}
 
 	wg.Wait()
 
-	return latestVersion, latestSource, sourcesErrors
+	var candidates []sourcedVersion
+	for i, versions := range found {
+		for _, version := range versions {
+			candidates = append(candidates, sourcedVersion{Version: version, Source: activeSources[i]})
+		}
+	}
+	search.Hits = candidates
+	search.Matches = selector.selectVersions(candidates, sourceSelection)
+	return search
 }
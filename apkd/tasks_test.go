@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
 
 func TestProgressStatusLine(t *testing.T) {
 	prevSuccess := downloadSuccessCount.Load()
@@ -18,9 +28,10 @@ func TestProgressStatusLine(t *testing.T) {
 	tq.runningTasks.Store(2)
 	tq.completedTasks.Store(4)
 	tq.activeDownloadTasks.Store(2)
+	tq.waitingForSlot.Store(1)
 
 	got := tq.progressStatusLine()
-	want := "Progress: downloaded 3 | in progress 2 | queued 4 | errors 1"
+	want := "Progress: downloaded 3 | in progress 2 | waiting for source 1 | queued 4 | errors 1"
 	if got != want {
 		t.Fatalf("unexpected progress line:\n got: %q\nwant: %q", got, want)
 	}
@@ -44,8 +55,225 @@ func TestProgressStatusLineClampsQueuedToZero(t *testing.T) {
 	tq.activeDownloadTasks.Store(1)
 
 	got := tq.progressStatusLine()
-	want := "Progress: downloaded 0 | in progress 1 | queued 0 | errors 2"
+	want := "Progress: downloaded 0 | in progress 1 | waiting for source 0 | queued 0 | errors 2"
 	if got != want {
 		t.Fatalf("unexpected progress line:\n got: %q\nwant: %q", got, want)
 	}
 }
+
+type limitedStubSource struct {
+	sources.BaseSource
+	name  string
+	limit int
+}
+
+func (s *limitedStubSource) Name() string               { return s.name }
+func (s *limitedStubSource) MaxParallelsDownloads() int { return s.limit }
+
+func TestAcquireDownloadSlotLimitsPerSource(t *testing.T) {
+	tq := &TaskQueue{}
+	limited := &limitedStubSource{name: "limited", limit: 2}
+	other := &limitedStubSource{name: "other", limit: 1}
+
+	ctx := context.Background()
+	release1, _ := tq.acquireDownloadSlot(ctx, limited)
+	release2, _ := tq.acquireDownloadSlot(ctx, limited)
+	// Slots are per source: a full "limited" source must not block "other".
+	releaseOther, _ := tq.acquireDownloadSlot(ctx, other)
+	releaseOther()
+
+	acquired := make(chan struct{})
+	go func() {
+		release, _ := tq.acquireDownloadSlot(ctx, limited)
+		close(acquired)
+		release()
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for tq.waitingForSlot.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected third download to wait for a slot")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatalf("expected third download to block while both slots are taken")
+	default:
+	}
+
+	release1()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected waiting download to acquire a released slot")
+	}
+	release2()
+	if got := tq.waitingForSlot.Load(); got != 0 {
+		t.Fatalf("expected no waiting downloads, got %d", got)
+	}
+}
+
+func TestAcquireDownloadSlotStopsOnCancel(t *testing.T) {
+	tq := &TaskQueue{}
+	limited := &limitedStubSource{name: "limited", limit: 1}
+	release, err := tq.acquireDownloadSlot(context.Background(), limited)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tq.acquireDownloadSlot(ctx, limited); err == nil {
+		t.Fatalf("expected cancelled context to abort waiting for a slot")
+	}
+	if got := tq.waitingForSlot.Load(); got != 0 {
+		t.Fatalf("expected no waiting downloads, got %d", got)
+	}
+}
+
+type findStubSource struct {
+	sources.BaseSource
+	name    string
+	version sources.Version
+	err     error
+}
+
+func (s *findStubSource) Name() string { return s.name }
+
+func (s *findStubSource) FindByPackage(context.Context, string, int) (sources.Version, error) {
+	return s.version, s.err
+}
+
+func TestFindVersionCountsOnlyApkFilteredSources(t *testing.T) {
+	prevSources, prevOnlyApk := activeSources, onlyApk
+	defer func() {
+		activeSources, onlyApk = prevSources, prevOnlyApk
+	}()
+	onlyApk = true
+	activeSources = []sources.Source{
+		&findStubSource{name: "xapk", version: sources.Version{PackageName: "com.example", Code: 2, Type: sources.XAPK}},
+		&findStubSource{name: "missing", err: &sources.AppNotFoundError{PackageName: "com.example"}},
+	}
+
+	search := (&TaskQueue{}).findVersion(context.Background(), "com.example", versionSelector{})
+	if len(search.Matches) != 0 {
+		t.Fatalf("expected no usable source, got %s", search.Matches[0].Source.Name())
+	}
+	if search.FilteredOnlyApk != 1 || len(search.Errors) != 0 {
+		t.Fatalf("unexpected search result: %+v", search)
+	}
+}
+
+func TestFindVersionResolvesSelectorAgainstListedVersions(t *testing.T) {
+	prevSources, prevOnlyApk := activeSources, onlyApk
+	defer func() {
+		activeSources, onlyApk = prevSources, prevOnlyApk
+	}()
+	onlyApk = false
+	activeSources = []sources.Source{
+		&listStubSource{
+			findStubSource: findStubSource{name: "fdroid"},
+			versions: []sources.Version{
+				{PackageName: "com.example", Code: 3, Type: sources.APK},
+				{PackageName: "com.example", Code: 2, Type: sources.APK},
+				{PackageName: "com.example", Code: 1, Type: sources.APK},
+			},
+		},
+	}
+
+	_, selector, err := parsePackageSpec("com.example@latest-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	search := (&TaskQueue{}).findVersion(context.Background(), "com.example", selector)
+	if len(search.Matches) != 1 || search.Matches[0].Version.Code != 2 {
+		t.Fatalf("unexpected matches: %+v", search.Matches)
+	}
+}
+
+// slotStubSource serves one package and limits downloads like a real source.
+type slotStubSource struct {
+	limitedStubSource
+	packageName string
+}
+
+func (s *slotStubSource) FindByPackage(_ context.Context, packageName string, _ int) (sources.Version, error) {
+	if packageName != s.packageName {
+		return sources.Version{}, &sources.AppNotFoundError{PackageName: packageName}
+	}
+	return sources.Version{PackageName: packageName, Name: "1.0", Code: 1, Type: sources.APK}, nil
+}
+
+func (s *slotStubSource) Download(context.Context, sources.Version) (*sources.DownloadStream, error) {
+	return &sources.DownloadStream{Body: io.NopCloser(strings.NewReader("apk")), Size: 3}, nil
+}
+
+func TestDownloadWaitingForSourceDoesNotHoldWorker(t *testing.T) {
+	prevSources, prevOutputDir := activeSources, outputDir
+	defer func() {
+		activeSources, outputDir = prevSources, prevOutputDir
+	}()
+	outputDir = t.TempDir()
+	busy := &slotStubSource{limitedStubSource: limitedStubSource{name: "busy", limit: 1}, packageName: "com.busy"}
+	free := &slotStubSource{limitedStubSource: limitedStubSource{name: "free", limit: 1}, packageName: "com.free"}
+	activeSources = []sources.Source{busy, free}
+
+	tq := NewTaskQueue(context.Background(), 1)
+	// Another download holds the only slot of the busy source.
+	releaseBusy, err := tq.acquireDownloadSlot(context.Background(), busy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tq.AddTask(PackageTask{PackageName: "com.busy"})
+	tq.AddTask(PackageTask{PackageName: "com.free"})
+
+	freeFile := filepath.Join(outputDir, "com.free-1.0-v1.apk")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(freeFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			releaseBusy()
+			t.Fatalf("expected the free source to download while the busy source waits for a slot")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	releaseBusy()
+	tq.Wait()
+	if _, err := os.Stat(filepath.Join(outputDir, "com.busy-1.0-v1.apk")); err != nil {
+		t.Fatalf("expected the busy source to download once its slot was free: %v", err)
+	}
+}
+
+func TestSignerPinMismatchKeepsExistingFile(t *testing.T) {
+	resetPackagePins(t)
+	prevOutputDir, prevForce := outputDir, forceDownload
+	defer func() {
+		outputDir, forceDownload = prevOutputDir, prevForce
+	}()
+	outputDir = t.TempDir()
+	forceDownload = true
+	addPackagePins("com.pinned", []string{testPin})
+	outFile := filepath.Join(outputDir, "com.pinned-1.0-v1.apk")
+	if err := os.WriteFile(outFile, []byte("good"), 0o600); err != nil {
+		t.Fatalf("failed to write apk: %v", err)
+	}
+	source := &slotStubSource{limitedStubSource: limitedStubSource{name: "stub", limit: 1}, packageName: "com.pinned"}
+	version, _ := source.FindByPackage(context.Background(), "com.pinned", 0)
+
+	tq := NewTaskQueue(context.Background(), 1)
+	outcome := tq.processVersionTask(context.Background(), VersionTask{Version: version, Source: source})
+	tq.Wait()
+	if outcome.Status != reportStatusError || !strings.Contains(outcome.Err, "Signature check failed") {
+		t.Fatalf("expected a signature check failure, got %+v", outcome)
+	}
+	if data, err := os.ReadFile(outFile); err != nil || string(data) != "good" {
+		t.Fatalf("expected the existing file to be kept, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(outFile + partFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the part file to be removed, got %v", err)
+	}
+}
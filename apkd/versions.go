@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+
+	"github.com/spf13/cobra"
+)
+
+var versionsOutputJSON bool
+
+var versionsCmd = cobra.Command{
+	Use:           "versions",
+	Short:         "List the versions of a package available in every source",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		resetRunState()
+		sourceProxies, resolvedCfg, err := prepareRun(cmd)
+		if err != nil {
+			return err
+		}
+		if err := parsePackageNames(); err != nil {
+			return err
+		}
+		return selectActiveSources(sourceProxies, resolvedCfg)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := notifyInterrupt(cmd.Context())
+		defer stop()
+
+		packages := make([]string, 0, len(packageNamesMap))
+		for packageName := range packageNamesMap {
+			packages = append(packages, packageName)
+		}
+		sort.Strings(packages)
+
+		var listings []versionListing
+		var found, missing int
+		for _, packageName := range packages {
+			packageListings := listVersions(ctx, packageName)
+			if ctx.Err() != nil {
+				return &exitError{code: exitCodeInterrupted}
+			}
+			if len(packageListings) == 0 {
+				fmt.Fprintf(os.Stderr, "Package %s not found\n", packageName)
+				missing++
+				continue
+			}
+			found++
+			listings = append(listings, packageListings...)
+		}
+
+		var err error
+		if versionsOutputJSON {
+			err = writeVersionsJSON(os.Stdout, listings)
+		} else {
+			err = writeVersionsTable(os.Stdout, listings)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing versions: %w", err)
+		}
+		switch {
+		case missing == 0:
+			return nil
+		case found == 0:
+			return &exitError{code: exitCodeFailed}
+		default:
+			return &exitError{code: exitCodePartial}
+		}
+	},
+}
+
+// versionListing is one version of a package offered by one source.
+type versionListing struct {
+	Package string `json:"package"`
+	Source  string `json:"source"`
+	Name    string `json:"name"`
+	Code    int    `json:"code"`
+	Size    uint64 `json:"size"`
+	Type    string `json:"type"`
+}
+
+// listVersions queries every active source for the versions of the package
+// and merges them, newest first. Sources that fail or do not have the package
+// are logged and skipped.
+func listVersions(ctx context.Context, packageName string) []versionListing {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var listings []versionListing
+	for _, source := range activeSources {
+		wg.Add(1)
+		go func(src sources.Source) {
+			defer wg.Done()
+			versions, err := sources.ListVersions(ctx, src, packageName)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				var appNotFoundError *sources.AppNotFoundError
+				if !errors.As(err, &appNotFoundError) {
+					reportError(fmt.Sprintf("Error listing versions of package %s at source %s: %v", packageName, src.Name(), err))
+				} else {
+					logger.Logd(fmt.Sprintf("Package %s not found at source %s", packageName, src.Name()))
+				}
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, version := range versions {
+				listings = append(listings, versionListing{
+					Package: packageName,
+					Source:  src.Name(),
+					Name:    version.Name,
+					Code:    version.Code,
+					Size:    version.Size,
+					Type:    string(version.Type),
+				})
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	sortVersionListings(listings)
+	return listings
+}
+
+// sortVersionListings orders listings by package, then newest version first,
+// then by source name so the output is stable between runs.
+func sortVersionListings(listings []versionListing) {
+	slices.SortFunc(listings, func(a, b versionListing) int {
+		if c := cmp.Compare(a.Package, b.Package); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Code, a.Code); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Type, b.Type)
+	})
+}
+
+func writeVersionsJSON(w io.Writer, listings []versionListing) error {
+	if listings == nil {
+		listings = []versionListing{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(listings)
+}
+
+func writeVersionsTable(w io.Writer, listings []versionListing) error {
+	if len(listings) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tCODE\tSOURCE\tSIZE\tTYPE")
+	for _, listing := range listings {
+		size := "-"
+		if listing.Size > 0 {
+			size = strconv.FormatUint(listing.Size, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", listing.Package, listing.Name, listing.Code, listing.Source, size, listing.Type)
+	}
+	return tw.Flush()
+}
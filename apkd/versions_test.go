@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kiber-io/apkd/apkd/sources"
+)
+
+type listStubSource struct {
+	findStubSource
+	versions []sources.Version
+}
+
+func (s *listStubSource) ListVersions(context.Context, string) ([]sources.Version, error) {
+	return s.versions, s.err
+}
+
+func TestListVersionsMergesSourcesNewestFirst(t *testing.T) {
+	prevSources := activeSources
+	defer func() {
+		activeSources = prevSources
+	}()
+	activeSources = []sources.Source{
+		&listStubSource{
+			findStubSource: findStubSource{name: "fdroid"},
+			versions: []sources.Version{
+				{Name: "1.0", Code: 1, Size: 10, Type: sources.APK},
+				{Name: "3.0", Code: 3, Size: 30, Type: sources.APK},
+			},
+		},
+		&findStubSource{name: "rustore", version: sources.Version{Name: "2.0", Code: 2, Type: sources.APK}},
+		&findStubSource{name: "missing", err: &sources.AppNotFoundError{PackageName: "com.example"}},
+	}
+
+	listings := listVersions(context.Background(), "com.example")
+	var codes []int
+	for _, listing := range listings {
+		codes = append(codes, listing.Code)
+	}
+	if len(codes) != 3 || codes[0] != 3 || codes[1] != 2 || codes[2] != 1 {
+		t.Fatalf("expected versions 3, 2, 1, got %v", codes)
+	}
+	if listings[1].Source != "rustore" || listings[1].Package != "com.example" {
+		t.Fatalf("unexpected listing: %+v", listings[1])
+	}
+}
+
+func TestWriteVersionsJSONAndTable(t *testing.T) {
+	listings := []versionListing{{Package: "com.example", Source: "fdroid", Name: "1.0", Code: 1, Type: "apk"}}
+
+	var jsonOut bytes.Buffer
+	if err := writeVersionsJSON(&jsonOut, listings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded []versionListing
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0] != listings[0] {
+		t.Fatalf("unexpected decoded listings: %+v", decoded)
+	}
+
+	var tableOut bytes.Buffer
+	if err := writeVersionsTable(&tableOut, listings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(tableOut.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "PACKAGE") || !strings.Contains(lines[1], "fdroid") {
+		t.Fatalf("unexpected table output:\n%s", tableOut.String())
+	}
+}
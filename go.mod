@@ -25,6 +25,6 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mattn/go-runewidth v0.0.21 // indirect
 	github.com/spf13/pflag v1.0.10 // indirect
-	golang.org/x/net v0.51.0 // indirect
+	golang.org/x/net v0.51.0 // direct
 	golang.org/x/sys v0.42.0 // indirect
 )